@@ -0,0 +1,155 @@
+package app
+
+/*** Queues ***/
+type AdminQueue struct {
+	Name                string `json:"Name"`
+	Url                 string `json:"Url"`
+	Arn                 string `json:"Arn"`
+	IsFIFO              bool   `json:"IsFIFO"`
+	Visible             int    `json:"Visible"`
+	InFlight            int    `json:"InFlight"`
+	Delayed             int    `json:"Delayed"`
+	Total               int    `json:"Total"`
+	DeadLetterTargetArn string `json:"DeadLetterTargetArn,omitempty"`
+	MaxReceiveCount     int    `json:"MaxReceiveCount,omitempty"`
+}
+
+type AdminListQueuesResponse struct {
+	Queues []AdminQueue `json:"Queues"`
+}
+
+/*** Messages ***/
+type AdminMessageAttribute struct {
+	DataType    string `json:"DataType"`
+	StringValue string `json:"StringValue,omitempty"`
+	BinaryValue string `json:"BinaryValue,omitempty"`
+}
+
+type AdminMessage struct {
+	MessageId              string                           `json:"MessageId"`
+	Body                   string                           `json:"Body"`
+	MD5OfBody              string                           `json:"MD5OfBody"`
+	MessageAttributes      map[string]AdminMessageAttribute `json:"MessageAttributes,omitempty"`
+	State                  string                           `json:"State"`
+	ReceiptHandle          string                           `json:"ReceiptHandle,omitempty"`
+	ReceiveCount           int                              `json:"ReceiveCount"`
+	SentTimestamp          int64                            `json:"SentTimestamp"`
+	VisibleTimestamp       int64                            `json:"VisibleTimestamp"`
+	MessageGroupId         string                           `json:"MessageGroupId,omitempty"`
+	MessageDeduplicationId string                           `json:"MessageDeduplicationId,omitempty"`
+}
+
+type AdminListMessagesResponse struct {
+	Messages []AdminMessage `json:"Messages"`
+}
+
+type AdminSendMessageRequest struct {
+	Body                   string                           `json:"Body"`
+	MessageAttributes      map[string]AdminMessageAttribute `json:"MessageAttributes"`
+	SentTimestamp          int64                            `json:"SentTimestamp"`
+	DelaySeconds           int                              `json:"DelaySeconds"`
+	MessageGroupId         string                           `json:"MessageGroupId"`
+	MessageDeduplicationId string                           `json:"MessageDeduplicationId"`
+}
+
+type AdminSendMessageResponse struct {
+	MessageId              string `json:"MessageId"`
+	MD5OfMessageBody       string `json:"MD5OfMessageBody"`
+	MD5OfMessageAttributes string `json:"MD5OfMessageAttributes,omitempty"`
+	SequenceNumber         string `json:"SequenceNumber,omitempty"`
+}
+
+/*** Topics ***/
+type AdminSubscription struct {
+	SubscriptionArn string `json:"SubscriptionArn"`
+	Protocol        string `json:"Protocol"`
+	Endpoint        string `json:"Endpoint"`
+	Raw             bool   `json:"RawMessageDelivery"`
+	FilterPolicy    string `json:"FilterPolicy,omitempty"`
+	Pending         bool   `json:"PendingConfirmation"`
+}
+
+type AdminTopic struct {
+	Name          string              `json:"Name"`
+	Arn           string              `json:"Arn"`
+	Subscriptions []AdminSubscription `json:"Subscriptions"`
+}
+
+type AdminListTopicsResponse struct {
+	Topics []AdminTopic `json:"Topics"`
+}
+
+/*** Outbox ***/
+type AdminOutboxMessage struct {
+	MessageId         string                           `json:"MessageId"`
+	Type              string                           `json:"Type"`
+	Protocol          string                           `json:"Protocol"`
+	Endpoint          string                           `json:"Endpoint"`
+	TopicArn          string                           `json:"TopicArn,omitempty"`
+	SubscriptionArn   string                           `json:"SubscriptionArn,omitempty"`
+	Subject           string                           `json:"Subject,omitempty"`
+	Message           string                           `json:"Message"`
+	MessageAttributes map[string]AdminMessageAttribute `json:"MessageAttributes,omitempty"`
+	SubscribeURL      string                           `json:"SubscribeURL,omitempty"`
+	SentTimestamp     int64                            `json:"SentTimestamp"`
+}
+
+type AdminListOutboxResponse struct {
+	Messages []AdminOutboxMessage `json:"Messages"`
+}
+
+/*** Published messages ***/
+type AdminDelivery struct {
+	SubscriptionArn string `json:"SubscriptionArn,omitempty"`
+	Protocol        string `json:"Protocol"`
+	Endpoint        string `json:"Endpoint"`
+	Status          string `json:"Status"`
+	Error           string `json:"Error,omitempty"`
+}
+
+type AdminPublishedMessage struct {
+	MessageId         string                           `json:"MessageId"`
+	TopicArn          string                           `json:"TopicArn,omitempty"`
+	Subject           string                           `json:"Subject,omitempty"`
+	Message           string                           `json:"Message"`
+	MessageStructure  string                           `json:"MessageStructure,omitempty"`
+	MessageAttributes map[string]AdminMessageAttribute `json:"MessageAttributes,omitempty"`
+	MessageGroupId    string                           `json:"MessageGroupId,omitempty"`
+	PublishTimestamp  int64                            `json:"PublishTimestamp"`
+	Deliveries        []AdminDelivery                  `json:"Deliveries"`
+}
+
+type AdminListPublishedResponse struct {
+	Messages []AdminPublishedMessage `json:"Messages"`
+}
+
+/*** Snapshot ***/
+type AdminSnapshotQueue struct {
+	AdminQueue
+	Messages []AdminMessage `json:"Messages"`
+}
+
+type AdminSnapshot struct {
+	Time   int64                `json:"Time"`
+	Queues []AdminSnapshotQueue `json:"Queues"`
+	Topics []AdminTopic         `json:"Topics"`
+}
+
+/*** Clock ***/
+type AdminAdvanceClockRequest struct {
+	Duration string `json:"Duration"`
+}
+
+type AdminClockResponse struct {
+	Now int64 `json:"Now"`
+}
+
+/*** Faults ***/
+type AdminListFaultsResponse struct {
+	Faults []FaultRule `json:"Faults"`
+}
+
+/*** Error Response ***/
+type AdminErrorResponse struct {
+	Error string `json:"Error"`
+}
@@ -0,0 +1,156 @@
+// Package certs provides the certificates GoAws serves HTTPS with.
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+var ca = struct {
+	sync.Mutex
+	pem []byte
+}{}
+
+// CAPEM returns the PEM encoded certificate of the CA generated at startup, or nil
+// when GoAws serves a certificate it was given.
+func CAPEM() []byte {
+	ca.Lock()
+	defer ca.Unlock()
+	return ca.pem
+}
+
+// Authority is a certificate authority that issues server certificates.
+type Authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	PEM  []byte // the PEM encoded CA certificate
+}
+
+// NewAuthority generates a self-signed CA.
+func NewAuthority() (*Authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{Organization: []string{"GoAws"}, CommonName: "GoAws Local CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{
+		cert: cert,
+		key:  key,
+		PEM:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}, nil
+}
+
+// Issue returns a server certificate for the given host names and IP addresses.
+func (a *Authority) Issue(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{Organization: []string{"GoAws"}, CommonName: hosts[0]},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(0, 0, 397), // the longest validity clients accept
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der, a.cert.Raw}, PrivateKey: key}, nil
+}
+
+// ServerConfig returns the TLS config to serve HTTPS with for the environment: its
+// CertFile and KeyFile, or a certificate for its host names issued by a generated CA.
+func ServerConfig(env app.Environment) (*tls.Config, error) {
+	if env.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(env.TLS.CertFile, env.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the TLS certificate: %s", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+	}
+
+	authority, err := NewAuthority()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := authority.Issue(Hosts(env))
+	if err != nil {
+		return nil, err
+	}
+	if env.TLS.CAFile != "" {
+		if err := os.WriteFile(env.TLS.CAFile, authority.PEM, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write the CA certificate: %s", err)
+		}
+	}
+	ca.Lock()
+	ca.pem = authority.PEM
+	ca.Unlock()
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// Hosts returns the host names a generated certificate is for: the environment's
+// host, with and without the region queue URLs use, localhost and the TLS Hosts.
+func Hosts(env app.Environment) []string {
+	hosts := []string{}
+	seen := map[string]bool{}
+	add := func(host string) {
+		if host != "" && !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	add(env.Host)
+	if env.Host != "" && env.Region != "" {
+		add(env.Region + "." + env.Host)
+	}
+	for _, host := range append([]string{"localhost", "127.0.0.1", "::1"}, env.TLS.Hosts...) {
+		add(host)
+	}
+	return hosts
+}
+
+func serialNumber() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
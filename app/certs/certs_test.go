@@ -0,0 +1,74 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+func TestAuthority_Issue(t *testing.T) {
+	authority, err := NewAuthority()
+	assert.NoError(t, err)
+	cert, err := authority.Issue([]string{"goaws.local", "127.0.0.1"})
+	assert.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	assert.True(t, roots.AppendCertsFromPEM(authority.PEM))
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	for _, host := range []string{"goaws.local", "127.0.0.1"} {
+		_, err = leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+		assert.NoError(t, err, host)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots})
+	assert.Error(t, err)
+}
+
+func TestHosts(t *testing.T) {
+	env := app.Environment{Host: "goaws.com", Region: "us-east-1", TLS: app.TLS{Hosts: []string{"goaws", "localhost"}}}
+	assert.Equal(t, []string{"goaws.com", "us-east-1.goaws.com", "localhost", "127.0.0.1", "::1", "goaws"}, Hosts(env))
+}
+
+func TestServerConfig_GeneratedCA(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	config, err := ServerConfig(app.Environment{Host: "localhost", TLS: app.TLS{Enabled: true, CAFile: caFile}})
+	assert.NoError(t, err)
+
+	caPEM, err := os.ReadFile(caFile)
+	assert.NoError(t, err)
+	assert.Equal(t, caPEM, CAPEM())
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "OK")
+	}))
+	srv.TLS = config
+	srv.StartTLS()
+	defer srv.Close()
+
+	// a client trusting the exported CA accepts the certificate
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get(srv.URL)
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "OK", string(body))
+	}
+}
+
+func TestServerConfig_GivenCertificate(t *testing.T) {
+	_, err := ServerConfig(app.Environment{TLS: app.TLS{Enabled: true, CertFile: "missing.pem", KeyFile: "missing.key"}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to load the TLS certificate")
+}
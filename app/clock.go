@@ -0,0 +1,98 @@
+package app
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for everything time dependent in the emulator:
+// visibility timeouts, delays, deduplication windows and timestamps.
+type Clock interface {
+	Now() time.Time
+	Advance(d time.Duration)
+}
+
+// SystemClock follows the wall clock, shifted by however far it has been advanced.
+type SystemClock struct {
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+func (c *SystemClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Add(c.offset)
+}
+
+func (c *SystemClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset += d
+}
+
+// ManualClock stands still until it is advanced, which makes tests deterministic.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+var currentClock = struct {
+	sync.RWMutex
+	clock         Clock
+	afterAdvances []func()
+}{clock: &SystemClock{}}
+
+// Now returns the current time of the emulator clock.
+func Now() time.Time {
+	currentClock.RLock()
+	defer currentClock.RUnlock()
+	return currentClock.clock.Now()
+}
+
+// SetClock replaces the emulator clock, e.g. with a ManualClock in tests.
+// Passing nil restores the wall clock.
+func SetClock(clock Clock) {
+	if clock == nil {
+		clock = &SystemClock{}
+	}
+	currentClock.Lock()
+	defer currentClock.Unlock()
+	currentClock.clock = clock
+}
+
+// AdvanceClock moves the emulator clock forward and immediately runs the
+// functions registered with OnClockAdvance, so e.g. expired visibility
+// timeouts take effect without waiting for the next periodic task.
+func AdvanceClock(d time.Duration) {
+	currentClock.RLock()
+	currentClock.clock.Advance(d)
+	afterAdvances := currentClock.afterAdvances
+	currentClock.RUnlock()
+
+	for _, fn := range afterAdvances {
+		fn()
+	}
+}
+
+// OnClockAdvance registers a function to run every time the clock is advanced.
+func OnClockAdvance(fn func()) {
+	currentClock.Lock()
+	defer currentClock.Unlock()
+	currentClock.afterAdvances = append(currentClock.afterAdvances, fn)
+}
@@ -0,0 +1,45 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManualClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	assert.Equal(t, start, clock.Now())
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), clock.Now())
+}
+
+func TestSystemClock(t *testing.T) {
+	clock := &SystemClock{}
+	clock.Advance(time.Hour)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), clock.Now(), time.Second)
+}
+
+func TestAdvanceClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	SetClock(NewManualClock(start))
+	defer SetClock(nil)
+
+	advanced := 0
+	OnClockAdvance(func() {
+		advanced++
+	})
+	AdvanceClock(30 * time.Second)
+	assert.Equal(t, start.Add(30*time.Second), Now())
+	assert.Equal(t, 1, advanced)
+
+	msg := Message{SentTime: start, DelaySecs: 60}
+	assert.False(t, msg.IsReadyForReceipt())
+	AdvanceClock(31 * time.Second)
+	assert.True(t, msg.IsReadyForReceipt())
+
+	SetClock(nil)
+	assert.WithinDuration(t, time.Now(), Now(), time.Second)
+}
@@ -0,0 +1,636 @@
+// Package cloudformation turns the SQS and SNS resources of CloudFormation
+// templates into the queues and topics of a goaws environment.
+package cloudformation
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	yamlv3 "gopkg.in/yaml.v3"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+// Resource types that are imported, every other type is ignored.
+const (
+	TypeQueue        = "AWS::SQS::Queue"
+	TypeQueuePolicy  = "AWS::SQS::QueuePolicy"
+	TypeTopic        = "AWS::SNS::Topic"
+	TypeTopicPolicy  = "AWS::SNS::TopicPolicy"
+	TypeSubscription = "AWS::SNS::Subscription"
+)
+
+// TypeFunction is not imported, but lambda subscriptions can refer to its Arn.
+const TypeFunction = "AWS::Lambda::Function"
+
+// Options are the values of the pseudo parameters and template parameters used
+// to resolve the template.
+type Options struct {
+	Region     string
+	AccountID  string
+	StackName  string            // defaults to goaws
+	Parameters map[string]string // override the Default of the template's parameters
+}
+
+type resource struct {
+	Type       string
+	Properties map[string]interface{}
+}
+
+type importer struct {
+	opts       Options
+	parameters map[string]interface{}
+	resources  map[string]resource
+	names      map[string]string
+	resolving  map[string]bool
+}
+
+// noValue is what AWS::NoValue resolves to. Properties set to it are dropped.
+type noValue struct{}
+
+var subVariable = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// Import returns the queues and topics of a CloudFormation template, in JSON or
+// YAML with or without the short form of intrinsic functions. Queues and topics
+// without a name are named after their logical id. Ref, Fn::GetAtt, Fn::Sub and
+// Fn::Join are resolved, other intrinsic functions are not supported.
+func Import(data []byte, opts Options) (app.Environment, error) {
+	env := app.Environment{Region: opts.Region, AccountID: opts.AccountID}
+	if opts.StackName == "" {
+		opts.StackName = "goaws"
+	}
+
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &doc); err != nil {
+		return env, err
+	}
+	if len(doc.Content) == 0 {
+		return env, fmt.Errorf("empty template")
+	}
+	decoded, err := decode(doc.Content[0])
+	if err != nil {
+		return env, err
+	}
+	template, ok := decoded.(map[string]interface{})
+	if !ok {
+		return env, fmt.Errorf("a template must be a mapping")
+	}
+
+	i := &importer{
+		opts:       opts,
+		parameters: map[string]interface{}{},
+		resources:  map[string]resource{},
+		names:      map[string]string{},
+		resolving:  map[string]bool{},
+	}
+	parameters, _ := template["Parameters"].(map[string]interface{})
+	for name, parameter := range parameters {
+		if value, ok := opts.Parameters[name]; ok {
+			i.parameters[name] = value
+		} else if parameter, ok := parameter.(map[string]interface{}); ok && parameter["Default"] != nil {
+			i.parameters[name] = parameter["Default"]
+		}
+	}
+	resources, _ := template["Resources"].(map[string]interface{})
+	for id, r := range resources {
+		r, _ := r.(map[string]interface{})
+		typ, _ := r["Type"].(string)
+		properties, _ := r["Properties"].(map[string]interface{})
+		if properties == nil {
+			properties = map[string]interface{}{}
+		}
+		i.resources[id] = resource{Type: typ, Properties: properties}
+	}
+
+	ids := make([]string, 0, len(i.resources))
+	for id := range i.resources {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	// queues and topics first, so subscriptions and policies can be added to them
+	topics := map[string]int{}
+	for _, typ := range []string{TypeQueue, TypeTopic, TypeSubscription, TypeQueuePolicy, TypeTopicPolicy} {
+		for _, id := range ids {
+			if i.resources[id].Type != typ {
+				continue
+			}
+			properties, err := i.resolve(i.resources[id].Properties)
+			if err != nil {
+				return env, fmt.Errorf("%s: %s", id, err)
+			}
+			if err := i.add(&env, topics, id, typ, properties.(map[string]interface{})); err != nil {
+				return env, fmt.Errorf("%s: %s", id, err)
+			}
+		}
+	}
+	for _, id := range ids {
+		if typ := i.resources[id].Type; !isImported(typ) {
+			log.Debugf("Ignoring %s %s", typ, id)
+		}
+	}
+	return env, nil
+}
+
+func isImported(typ string) bool {
+	switch typ {
+	case TypeQueue, TypeQueuePolicy, TypeTopic, TypeTopicPolicy, TypeSubscription:
+		return true
+	}
+	return false
+}
+
+// add adds a resource with resolved properties to env. topics indexes env.Topics by name.
+func (i *importer) add(env *app.Environment, topics map[string]int, id string, typ string, p map[string]interface{}) error {
+	switch typ {
+	case TypeQueue:
+		name, err := i.name(id)
+		if err != nil {
+			return err
+		}
+		queue := app.EnvQueue{Name: name}
+		queue.FifoQueue, err = toBool(p["FifoQueue"], err)
+		queue.ContentBasedDeduplication, err = toBool(p["ContentBasedDeduplication"], err)
+		queue.DelaySeconds, err = toInt(p["DelaySeconds"], err)
+		queue.MaximumMessageSize, err = toInt(p["MaximumMessageSize"], err)
+		queue.MessageRetentionPeriod, err = toInt(p["MessageRetentionPeriod"], err)
+		queue.ReceiveMessageWaitTimeSeconds, err = toInt(p["ReceiveMessageWaitTimeSeconds"], err)
+		queue.VisibilityTimeout, err = toInt(p["VisibilityTimeout"], err)
+		queue.KmsMasterKeyId, err = toString(p["KmsMasterKeyId"], err)
+		queue.KmsDataKeyReusePeriodSeconds, err = toInt(p["KmsDataKeyReusePeriodSeconds"], err)
+		queue.SqsManagedSseEnabled, err = toBool(p["SqsManagedSseEnabled"], err)
+		queue.RedrivePolicy, err = toJSON(p["RedrivePolicy"], err)
+		queue.Tags, err = toTags(p["Tags"], err)
+		if err != nil {
+			return err
+		}
+		env.Queues = append(env.Queues, queue)
+
+	case TypeTopic:
+		name, err := i.name(id)
+		if err != nil {
+			return err
+		}
+		topic := app.EnvTopic{Name: name}
+		topic.DisplayName, err = toString(p["DisplayName"], err)
+		topic.KmsMasterKeyId, err = toString(p["KmsMasterKeyId"], err)
+		topic.DeliveryPolicy, err = toJSON(p["DeliveryPolicy"], err)
+		topic.SignatureVersion, err = toString(p["SignatureVersion"], err)
+		topic.Tags, err = toTags(p["Tags"], err)
+		subscriptions, _ := p["Subscription"].([]interface{})
+		for _, s := range subscriptions {
+			s, _ := s.(map[string]interface{})
+			var sub app.EnvSubsciption
+			if sub, err = toSubscription(s, err); err == nil && sub.Protocol != "" {
+				topic.Subscriptions = append(topic.Subscriptions, sub)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		topics[name] = len(env.Topics)
+		env.Topics = append(env.Topics, topic)
+
+	case TypeSubscription:
+		topicArn, _ := toString(p["TopicArn"], nil)
+		if topicArn == "" {
+			return fmt.Errorf("TopicArn is required")
+		}
+		sub, err := toSubscription(p, nil)
+		if err != nil || sub.Protocol == "" {
+			return err
+		}
+		topicName := lastSegment(topicArn, ":")
+		if _, ok := topics[topicName]; !ok {
+			topics[topicName] = len(env.Topics)
+			env.Topics = append(env.Topics, app.EnvTopic{Name: topicName})
+		}
+		topic := &env.Topics[topics[topicName]]
+		topic.Subscriptions = append(topic.Subscriptions, sub)
+
+	case TypeQueuePolicy, TypeTopicPolicy:
+		policy, err := toJSON(p["PolicyDocument"], nil)
+		if err != nil || policy == "" {
+			return fmt.Errorf("PolicyDocument is required")
+		}
+		if typ == TypeQueuePolicy {
+			urls, _ := p["Queues"].([]interface{})
+			for _, url := range urls {
+				url, _ := url.(string)
+				queue := findQueue(env, lastSegment(url, "/"))
+				queue.Policy = policy
+			}
+		} else {
+			arns, _ := p["Topics"].([]interface{})
+			for _, arn := range arns {
+				arn, _ := arn.(string)
+				topicName := lastSegment(arn, ":")
+				if _, ok := topics[topicName]; !ok {
+					topics[topicName] = len(env.Topics)
+					env.Topics = append(env.Topics, app.EnvTopic{Name: topicName})
+				}
+				env.Topics[topics[topicName]].Policy = policy
+			}
+		}
+	}
+	return nil
+}
+
+// findQueue returns the queue with the given name, adding it to env if it's missing.
+func findQueue(env *app.Environment, name string) *app.EnvQueue {
+	for i := range env.Queues {
+		if env.Queues[i].Name == name {
+			return &env.Queues[i]
+		}
+	}
+	env.Queues = append(env.Queues, app.EnvQueue{Name: name})
+	return &env.Queues[len(env.Queues)-1]
+}
+
+func toSubscription(p map[string]interface{}, err error) (app.EnvSubsciption, error) {
+	sub := app.EnvSubsciption{}
+	sub.Protocol, err = toString(p["Protocol"], err)
+	endpoint, err := toString(p["Endpoint"], err)
+	sub.Raw, err = toBool(p["RawMessageDelivery"], err)
+	sub.FilterPolicy, err = toJSON(p["FilterPolicy"], err)
+	sub.DeliveryPolicy, err = toJSON(p["DeliveryPolicy"], err)
+	switch sub.Protocol {
+	case "sqs":
+		sub.QueueName = lastSegment(endpoint, ":")
+	case "http", "https", "lambda", "email", "email-json", "sms", "application":
+		sub.EndPoint = endpoint
+	default:
+		log.Warnf("Skipping %s subscription to %s, the protocol isn't supported", sub.Protocol, endpoint)
+		sub.Protocol = ""
+	}
+	return sub, err
+}
+
+// name returns the physical name of a queue or topic.
+func (i *importer) name(id string) (string, error) {
+	if name, ok := i.names[id]; ok {
+		return name, nil
+	}
+	if i.resolving[id] {
+		return "", fmt.Errorf("the name of %s depends on itself", id)
+	}
+	i.resolving[id] = true
+	defer delete(i.resolving, id)
+
+	r := i.resources[id]
+	nameProperty, fifoProperty := "QueueName", "FifoQueue"
+	if r.Type == TypeTopic {
+		nameProperty, fifoProperty = "TopicName", "FifoTopic"
+	} else if r.Type == TypeFunction {
+		nameProperty, fifoProperty = "FunctionName", ""
+	}
+	name := id
+	if value, ok := r.Properties[nameProperty]; ok {
+		resolved, err := i.resolve(value)
+		if err == nil {
+			name, err = toString(resolved, nil)
+		}
+		if err != nil {
+			return "", err
+		}
+	} else if value, ok := r.Properties[fifoProperty]; ok {
+		resolved, err := i.resolve(value)
+		if fifo, _ := toBool(resolved, err); fifo {
+			name += ".fifo"
+		}
+	}
+	i.names[id] = name
+	return name, nil
+}
+
+// resolve returns the value with every intrinsic function in it replaced by its result.
+func (i *importer) resolve(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 1 {
+			for fn, arg := range v {
+				if fn == "Ref" || strings.HasPrefix(fn, "Fn::") {
+					return i.call(fn, arg)
+				}
+			}
+		}
+		resolved := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			item, err := i.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := item.(noValue); !ok {
+				resolved[key] = item
+			}
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			item, err := i.resolve(item)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := item.(noValue); !ok {
+				resolved = append(resolved, item)
+			}
+		}
+		return resolved, nil
+	}
+	return value, nil
+}
+
+func (i *importer) call(fn string, arg interface{}) (interface{}, error) {
+	switch fn {
+	case "Ref":
+		name, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("Ref needs the name of a resource or parameter")
+		}
+		return i.ref(name)
+	case "Fn::GetAtt":
+		if s, ok := arg.(string); ok {
+			arg = []interface{}{}
+			if id, attribute, ok := strings.Cut(s, "."); ok {
+				arg = []interface{}{id, attribute}
+			}
+		}
+		args, _ := arg.([]interface{})
+		if len(args) != 2 {
+			return nil, fmt.Errorf("Fn::GetAtt needs a resource and an attribute")
+		}
+		id, _ := args[0].(string)
+		attribute, _ := args[1].(string)
+		return i.getAtt(id, attribute)
+	case "Fn::Sub":
+		return i.sub(arg)
+	case "Fn::Join":
+		args, _ := arg.([]interface{})
+		if len(args) != 2 {
+			return nil, fmt.Errorf("Fn::Join needs a delimiter and a list")
+		}
+		delimiter, _ := args[0].(string)
+		resolved, err := i.resolve(args[1])
+		if err != nil {
+			return nil, err
+		}
+		items, ok := resolved.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Fn::Join needs a delimiter and a list")
+		}
+		parts := make([]string, len(items))
+		for n, item := range items {
+			if parts[n], err = toString(item, nil); err != nil {
+				return nil, err
+			}
+		}
+		return strings.Join(parts, delimiter), nil
+	}
+	return nil, fmt.Errorf("%s is not supported", fn)
+}
+
+func (i *importer) ref(name string) (interface{}, error) {
+	switch name {
+	case "AWS::Region":
+		return i.opts.Region, nil
+	case "AWS::AccountId":
+		return i.opts.AccountID, nil
+	case "AWS::StackName":
+		return i.opts.StackName, nil
+	case "AWS::Partition":
+		return "aws", nil
+	case "AWS::URLSuffix":
+		return "amazonaws.com", nil
+	case "AWS::NoValue":
+		return noValue{}, nil
+	}
+	if value, ok := i.parameters[name]; ok {
+		return value, nil
+	}
+	r, ok := i.resources[name]
+	if !ok {
+		return nil, fmt.Errorf("Ref to %s, which is neither a resource nor a parameter with a value", name)
+	}
+	switch r.Type {
+	case TypeQueue:
+		return i.getAtt(name, "QueueUrl")
+	case TypeTopic:
+		return i.getAtt(name, "TopicArn")
+	case TypeFunction:
+		return i.name(name)
+	}
+	return nil, fmt.Errorf("Ref to %s, a %s, is not supported", name, r.Type)
+}
+
+func (i *importer) getAtt(id string, attribute string) (interface{}, error) {
+	r, ok := i.resources[id]
+	if !ok {
+		return nil, fmt.Errorf("Fn::GetAtt of %s, which is not a resource", id)
+	}
+	if r.Type == TypeQueue || r.Type == TypeTopic || r.Type == TypeFunction {
+		name, err := i.name(id)
+		if err != nil {
+			return nil, err
+		}
+		switch r.Type + "." + attribute {
+		case TypeQueue + ".Arn":
+			return "arn:aws:sqs:" + i.opts.Region + ":" + i.opts.AccountID + ":" + name, nil
+		case TypeQueue + ".QueueName", TypeTopic + ".TopicName":
+			return name, nil
+		case TypeQueue + ".QueueUrl":
+			return "https://sqs." + i.opts.Region + ".amazonaws.com/" + i.opts.AccountID + "/" + name, nil
+		case TypeTopic + ".TopicArn":
+			return "arn:aws:sns:" + i.opts.Region + ":" + i.opts.AccountID + ":" + name, nil
+		case TypeFunction + ".Arn":
+			return "arn:aws:lambda:" + i.opts.Region + ":" + i.opts.AccountID + ":function:" + name, nil
+		}
+	}
+	return nil, fmt.Errorf("Fn::GetAtt of %s.%s is not supported", id, attribute)
+}
+
+func (i *importer) sub(arg interface{}) (interface{}, error) {
+	format, ok := arg.(string)
+	variables := map[string]interface{}{}
+	if args, isList := arg.([]interface{}); isList && len(args) == 2 {
+		format, ok = args[0].(string)
+		resolved, err := i.resolve(args[1])
+		if err != nil {
+			return nil, err
+		}
+		variables, _ = resolved.(map[string]interface{})
+	}
+	if !ok {
+		return nil, fmt.Errorf("Fn::Sub needs a string, or a string and a mapping of variables")
+	}
+
+	var err error
+	result := subVariable.ReplaceAllStringFunc(format, func(match string) string {
+		name := match[2 : len(match)-1]
+		if strings.HasPrefix(name, "!") {
+			return "${" + name[1:] + "}"
+		}
+		var value interface{}
+		var e error
+		if v, ok := variables[name]; ok {
+			value = v
+		} else if id, attribute, ok := strings.Cut(name, "."); ok {
+			value, e = i.getAtt(id, attribute)
+		} else {
+			value, e = i.ref(name)
+		}
+		if e == nil {
+			value, e = toString(value, nil)
+		}
+		if e != nil {
+			if err == nil {
+				err = e
+			}
+			return match
+		}
+		return value.(string)
+	})
+	return result, err
+}
+
+// decode converts a YAML node to maps, lists and scalars, turning the short form
+// of intrinsic functions, e.g. !Ref, into their long form, e.g. {"Ref": ...}.
+func decode(node *yamlv3.Node) (interface{}, error) {
+	if node.Kind == yamlv3.AliasNode {
+		return decode(node.Alias)
+	}
+
+	var value interface{}
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		m := map[string]interface{}{}
+		for n := 0; n+1 < len(node.Content); n += 2 {
+			item, err := decode(node.Content[n+1])
+			if err != nil {
+				return nil, err
+			}
+			m[node.Content[n].Value] = item
+		}
+		value = m
+	case yamlv3.SequenceNode:
+		list := make([]interface{}, 0, len(node.Content))
+		for _, child := range node.Content {
+			item, err := decode(child)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		value = list
+	default:
+		if strings.HasPrefix(node.Tag, "!!") || node.Tag == "" {
+			if err := node.Decode(&value); err != nil {
+				return nil, err
+			}
+		} else {
+			value = node.Value
+		}
+	}
+
+	if !strings.HasPrefix(node.Tag, "!") || strings.HasPrefix(node.Tag, "!!") {
+		return value, nil
+	}
+	fn := node.Tag[1:]
+	if fn == "Ref" {
+		return map[string]interface{}{"Ref": value}, nil
+	}
+	if fn == "GetAtt" {
+		if s, ok := value.(string); ok {
+			id, attribute, _ := strings.Cut(s, ".")
+			value = []interface{}{id, attribute}
+		}
+	}
+	return map[string]interface{}{"Fn::" + fn: value}, nil
+}
+
+// The to* functions convert resolved property values, passing on the error of a
+// previous conversion so a run of them can be checked once.
+
+func toString(value interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case int, bool:
+		return fmt.Sprint(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("expected a string, got %v", value)
+}
+
+func toInt(value interface{}, err error) (int, error) {
+	s, err := toString(value, err)
+	if err != nil || s == "" {
+		return 0, err
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("expected a whole number, got %q", s)
+	}
+	return i, nil
+}
+
+func toBool(value interface{}, err error) (bool, error) {
+	s, err := toString(value, err)
+	if err != nil || s == "" {
+		return false, err
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("expected true or false, got %q", s)
+	}
+	return b, nil
+}
+
+// toJSON returns objects as JSON. Strings are returned as they are, CloudFormation
+// accepts JSON documents in either form.
+func toJSON(value interface{}, err error) (string, error) {
+	if err != nil || value == nil {
+		return "", err
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(value)
+	return string(b), err
+}
+
+func toTags(value interface{}, err error) (map[string]string, error) {
+	if err != nil || value == nil {
+		return nil, err
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Tags must be a list of Key and Value pairs")
+	}
+	tags := map[string]string{}
+	for _, item := range list {
+		tag, _ := item.(map[string]interface{})
+		key, err := toString(tag["Key"], nil)
+		value, err := toString(tag["Value"], err)
+		if err != nil || key == "" {
+			return nil, fmt.Errorf("Tags must be a list of Key and Value pairs")
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+func lastSegment(s string, separator string) string {
+	return s[strings.LastIndex(s, separator)+1:]
+}
@@ -0,0 +1,176 @@
+package cloudformation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+var options = Options{Region: "us-east-1", AccountID: "100010001000", StackName: "orders-stack"}
+
+func TestImport_Yaml(t *testing.T) {
+	template := `
+AWSTemplateFormatVersion: "2010-09-09"
+Parameters:
+  Environment:
+    Type: String
+    Default: dev
+Resources:
+  OrdersQueue:
+    Type: AWS::SQS::Queue
+    Properties:
+      QueueName: !Sub "${Environment}-orders"
+      VisibilityTimeout: 60
+      DelaySeconds: "5"
+      RedrivePolicy:
+        deadLetterTargetArn: !GetAtt OrdersDeadLetterQueue.Arn
+        maxReceiveCount: 3
+      Tags:
+        - Key: team
+          Value: !Ref AWS::StackName
+  OrdersDeadLetterQueue:
+    Type: AWS::SQS::Queue
+  EventsQueue:
+    Type: AWS::SQS::Queue
+    Properties:
+      FifoQueue: true
+      ContentBasedDeduplication: true
+  OrdersTopic:
+    Type: AWS::SNS::Topic
+    Properties:
+      TopicName: !Join ["-", [!Ref Environment, "orders"]]
+      DisplayName: Orders
+      Subscription:
+        - Protocol: https
+          Endpoint: https://example.com/orders
+        - Protocol: email
+          Endpoint: orders@example.com
+        - Protocol: firehose
+          Endpoint: arn:aws:firehose:us-east-1:100010001000:deliverystream/orders
+  OrdersSubscription:
+    Type: AWS::SNS::Subscription
+    Properties:
+      TopicArn: !Ref OrdersTopic
+      Protocol: sqs
+      Endpoint: !GetAtt [OrdersQueue, Arn]
+      RawMessageDelivery: "true"
+      FilterPolicy:
+        type: [created]
+  OrdersQueuePolicy:
+    Type: AWS::SQS::QueuePolicy
+    Properties:
+      Queues: [!Ref OrdersQueue]
+      PolicyDocument:
+        Statement:
+          - Effect: Allow
+            Principal: "*"
+            Action: sqs:SendMessage
+            Resource: !GetAtt OrdersQueue.Arn
+            Condition:
+              ArnEquals:
+                aws:SourceArn: !Ref OrdersTopic
+  OrdersFunction:
+    Type: AWS::Lambda::Function
+    Properties:
+      FunctionName: !Sub "${Environment}-orders-handler"
+      Handler: !GetAtt Unknown.Handler
+  OrdersFunctionSubscription:
+    Type: AWS::SNS::Subscription
+    Properties:
+      TopicArn: !Ref OrdersTopic
+      Protocol: lambda
+      Endpoint: !GetAtt OrdersFunction.Arn
+`
+	env, err := Import([]byte(template), options)
+	assert.NoError(t, err)
+	assert.Equal(t, "us-east-1", env.Region)
+	assert.Equal(t, "100010001000", env.AccountID)
+
+	assert.Equal(t, []app.EnvQueue{
+		{Name: "EventsQueue.fifo", FifoQueue: true, ContentBasedDeduplication: true},
+		{Name: "OrdersDeadLetterQueue"},
+		{
+			Name:              "dev-orders",
+			VisibilityTimeout: 60,
+			DelaySeconds:      5,
+			RedrivePolicy:     `{"deadLetterTargetArn":"arn:aws:sqs:us-east-1:100010001000:OrdersDeadLetterQueue","maxReceiveCount":3}`,
+			Tags:              map[string]string{"team": "orders-stack"},
+			Policy: `{"Statement":[{"Action":"sqs:SendMessage","Condition":{"ArnEquals":{"aws:SourceArn":"arn:aws:sns:us-east-1:100010001000:dev-orders"}},` +
+				`"Effect":"Allow","Principal":"*","Resource":"arn:aws:sqs:us-east-1:100010001000:dev-orders"}]}`,
+		},
+	}, env.Queues)
+
+	// the firehose subscription is skipped, it isn't supported
+	assert.Equal(t, []app.EnvTopic{
+		{
+			Name:        "dev-orders",
+			DisplayName: "Orders",
+			Subscriptions: []app.EnvSubsciption{
+				{Protocol: "https", EndPoint: "https://example.com/orders"},
+				{Protocol: "email", EndPoint: "orders@example.com"},
+				{Protocol: "lambda", EndPoint: "arn:aws:lambda:us-east-1:100010001000:function:dev-orders-handler"},
+				{Protocol: "sqs", QueueName: "dev-orders", Raw: true, FilterPolicy: `{"type":["created"]}`},
+			},
+		},
+	}, env.Topics)
+}
+
+func TestImport_Json(t *testing.T) {
+	template := `{
+  "Parameters": {"Prefix": {"Type": "String"}},
+  "Resources": {
+    "Queue": {"Type": "AWS::SQS::Queue", "Properties": {"QueueName": {"Fn::Sub": "${Prefix}-queue"}}},
+    "Topic": {"Type": "AWS::SNS::Topic", "Properties": {"TopicName": {"Fn::Sub": ["${Name}-topic", {"Name": {"Fn::GetAtt": ["Queue", "QueueName"]}}]}}},
+    "TopicPolicy": {
+      "Type": "AWS::SNS::TopicPolicy",
+      "Properties": {"Topics": [{"Ref": "Topic"}], "PolicyDocument": "{\"Statement\": []}"}
+    }
+  }
+}`
+	env, err := Import([]byte(template), Options{Region: "local", AccountID: "queue", Parameters: map[string]string{"Prefix": "test"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []app.EnvQueue{{Name: "test-queue"}}, env.Queues)
+	assert.Equal(t, []app.EnvTopic{{Name: "test-queue-topic", Policy: `{"Statement": []}`}}, env.Topics)
+}
+
+func TestImport_Errors(t *testing.T) {
+	tests := map[string]string{
+		"Queue: Ref to Missing, which is neither a resource nor a parameter with a value": `
+Resources:
+  Queue:
+    Type: AWS::SQS::Queue
+    Properties:
+      QueueName: !Ref Missing`,
+		"Queue: Fn::If is not supported": `
+Resources:
+  Queue:
+    Type: AWS::SQS::Queue
+    Properties:
+      QueueName: !If [IsProd, prod, dev]`,
+		"Queue: the name of Queue depends on itself": `
+Resources:
+  Queue:
+    Type: AWS::SQS::Queue
+    Properties:
+      QueueName: !GetAtt Queue.Arn`,
+		`Queue: expected a whole number, got "soon"`: `
+Resources:
+  Queue:
+    Type: AWS::SQS::Queue
+    Properties:
+      VisibilityTimeout: soon`,
+		"Subscription: TopicArn is required": `
+Resources:
+  Subscription:
+    Type: AWS::SNS::Subscription
+    Properties:
+      Protocol: sqs`,
+		"a template must be a mapping": `[]`,
+	}
+	for expected, template := range tests {
+		_, err := Import([]byte(template), options)
+		assert.EqualError(t, err, expected)
+	}
+}
@@ -1,27 +1,44 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/Admiral-Piett/goaws/app"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/Admiral-Piett/goaws/app/certs"
 	"github.com/Admiral-Piett/goaws/app/conf"
+	admin "github.com/Admiral-Piett/goaws/app/goadmin"
+	sns "github.com/Admiral-Piett/goaws/app/gosns"
 	"github.com/Admiral-Piett/goaws/app/gosqs"
 	"github.com/Admiral-Piett/goaws/app/router"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImport(os.Args[2:]))
+	}
+
 	var filename string
 	var debug bool
 	var loglevel string
-	flag.StringVar(&filename, "config", "", "config file location + name")
-	flag.BoolVar(&debug, "debug", false, "set debug log level")
-	flag.StringVar(&loglevel, "loglevel", "info", "log level (default info)")
+	var watch bool
+	var overrides conf.Overrides
+	flag.StringVar(&filename, "config", os.Getenv("GOAWS_CONFIG"), "config file location + name (env GOAWS_CONFIG)")
+	flag.BoolVar(&debug, "debug", envBool("GOAWS_DEBUG", false), "set debug log level (env GOAWS_DEBUG)")
+	flag.StringVar(&loglevel, "loglevel", envString("GOAWS_LOGLEVEL", "info"), "log level (env GOAWS_LOGLEVEL)")
+	flag.BoolVar(&watch, "watch", envBool("GOAWS_WATCH", true), "reload the config file when it changes (env GOAWS_WATCH)")
+	conf.BindOverrides(flag.CommandLine, &overrides, os.Getenv)
 	flag.Parse()
 
 	log.SetFormatter(&log.JSONFormatter{})
@@ -39,15 +56,24 @@ func main() {
 		}
 	}
 
-	env := "Local"
+	env := envString("GOAWS_ENV", "Local")
 	if flag.NArg() > 0 {
 		env = flag.Arg(0)
 	}
 
-	portNumbers := conf.LoadYamlConfig(filename, env)
+	if err := conf.SetOverrides(overrides); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	portNumbers, err := conf.LoadYamlConfig(filename, env)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	if app.CurrentEnvironment.LogToFile {
-		filename := app.CurrentEnvironment.LogFile
+	environment := app.GetCurrentEnvironment()
+	if environment.LogToFile {
+		filename := environment.LogFile
 		file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 		if err == nil {
 			log.SetOutput(file)
@@ -60,21 +86,105 @@ func main() {
 
 	quit := make(chan struct{}, 0)
 	go gosqs.PeriodicTasks(1*time.Second, quit)
+	go sns.PeriodicTasks(1*time.Second, quit)
 
-	if len(portNumbers) == 1 {
-		log.Warnf("GoAws listening on: 0.0.0.0:%s", portNumbers[0])
-		err := http.ListenAndServe("0.0.0.0:"+portNumbers[0], r)
-		log.Fatal(err)
-	} else if len(portNumbers) == 2 {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := conf.ReloadYamlConfig(); err != nil {
+				log.Errorf("Failed to reload config: %s", err)
+			}
+		}
+	}()
+	if watch {
+		go conf.WatchYamlConfig(2*time.Second, quit)
+	}
+
+	if signing := environment.SnsSigning; signing.CertFile != "" {
+		if err := sns.LoadSigningCertificate(signing.CertFile, signing.KeyFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	var tlsConfig *tls.Config
+	if environment.TLS.Enabled {
+		tlsConfig, err = certs.ServerConfig(environment)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if len(portNumbers) < 1 || len(portNumbers) > 2 {
+		log.Fatal("Not enough or too many ports defined to start GoAws.")
+	}
+	var servers []*http.Server
+	for _, port := range portNumbers {
+		srv := &http.Server{Addr: "0.0.0.0:" + port, Handler: r, TLSConfig: tlsConfig}
+		servers = append(servers, srv)
 		go func() {
-			log.Warnf("GoAws listening on: 0.0.0.0:%s", portNumbers[0])
-			err := http.ListenAndServe("0.0.0.0:"+portNumbers[0], r)
-			log.Fatal(err)
+			log.Warnf("GoAws listening on: %s://%s", app.Scheme(), srv.Addr)
+			var err error
+			if tlsConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
 		}()
-		log.Warnf("GoAws listening on: 0.0.0.0:%s", portNumbers[1])
-		err := http.ListenAndServe("0.0.0.0:"+portNumbers[1], r)
-		log.Fatal(err)
-	} else {
-		log.Fatal("Not enough or too many ports defined to start GoAws.")
 	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	os.Exit(shutdown(servers, quit))
+}
+
+// shutdown stops accepting connections, ends long polls and waits for the requests
+// in flight, SNS deliveries included, for up to the ShutdownTimeout. It then stops
+// the periodic tasks, writes the snapshot if configured and returns the exit code.
+func shutdown(servers []*http.Server, quit chan struct{}) int {
+	timeout := time.Duration(app.GetCurrentEnvironment().ShutdownTimeout) * time.Second
+	log.Warnf("Shutting down, waiting up to %s for requests to finish", timeout)
+	app.BeginShutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	code := 0
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Errorf("Requests still running after %s, closing their connections", timeout)
+			srv.Close()
+			code = 1
+		}
+	}
+	close(quit)
+
+	if filename := app.GetCurrentEnvironment().SnapshotFile; filename != "" {
+		if err := admin.WriteSnapshot(filename); err != nil {
+			log.Errorf("Failed to write the snapshot: %s", err)
+			code = 1
+		} else {
+			log.Infof("Wrote snapshot: %s", filename)
+		}
+	}
+	log.Warn("GoAws stopped")
+	return code
+}
+
+func envString(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func envBool(name string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(name)); err == nil {
+		return value
+	}
+	return defaultValue
 }
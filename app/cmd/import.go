@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/conf"
+)
+
+// runImport is the import command. It writes a config file with the queues and
+// topics of CloudFormation templates, and returns the exit code.
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("goaws import", flag.ContinueOnError)
+	output := fs.String("o", "", "file to write the config to, standard output by default")
+	envName := fs.String("env", "Local", "name of the environment in the config")
+	region := fs.String("region", "us-east-1", "region of the ARNs and URLs in the templates")
+	accountID := fs.String("account-id", "100010001000", "account id of the ARNs and URLs in the templates")
+	parameters := fs.String("parameters", "", "comma separated Name=Value parameters of the templates")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: goaws import [flags] template...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	env, err := conf.ImportTemplates(app.Environment{Region: *region, AccountID: *accountID}, fs.Args(), conf.ParseParameters(*parameters))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	data, err := conf.MarshalEnvironment(*envName, env)
+	if err == nil {
+		if *output == "" {
+			_, err = os.Stdout.Write(data)
+		} else {
+			err = os.WriteFile(*output, data, 0644)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -1,32 +1,54 @@
 package app
 
+import "sync"
+
 /*** config ***/
 type EnvSubsciption struct {
-	Protocol     string
-	EndPoint     string
-	TopicArn     string
-	QueueName    string
-	Raw          bool
-	FilterPolicy string
+	Protocol       string
+	EndPoint       string
+	TopicArn       string
+	QueueName      string
+	Raw            bool
+	FilterPolicy   string
+	DeliveryPolicy string
 }
 
 type EnvTopic struct {
-	Name          string
-	Subscriptions []EnvSubsciption
+	Name             string
+	DisplayName      string
+	KmsMasterKeyId   string
+	Policy           string
+	DeliveryPolicy   string
+	SignatureVersion string
+	Tags             map[string]string
+	Subscriptions    []EnvSubsciption
 }
 
 type EnvQueue struct {
 	Name                          string
+	FifoQueue                     bool
+	ContentBasedDeduplication     bool
 	ReceiveMessageWaitTimeSeconds int
 	RedrivePolicy                 string
 	MaximumMessageSize            int
 	VisibilityTimeout             int
+	DelaySeconds                  int
+	MessageRetentionPeriod        int
+	Policy                        string
+	KmsMasterKeyId                string
+	KmsDataKeyReusePeriodSeconds  int
+	SqsManagedSseEnabled          bool
+	Tags                          map[string]string
+	DuplicateProbability          float64
+	ReorderProbability            float64
 }
 
 type EnvQueueAttributes struct {
 	VisibilityTimeout             int
 	ReceiveMessageWaitTimeSeconds int
 	MaximumMessageSize            int
+	DuplicateProbability          float64
+	ReorderProbability            float64
 }
 
 type Environment struct {
@@ -43,10 +65,37 @@ type Environment struct {
 	Queues                 []EnvQueue
 	QueueAttributeDefaults EnvQueueAttributes
 	RandomLatency          RandomLatency
+	InFlightLimits         InFlightLimits
+	Faults                 []FaultRule
+	RandomSeed             int64
+	PruneOnReload          bool // delete resources removed from the config file when it is reloaded
+	TLS                    TLS
+	SnsSigning             SnsSigning
+	LambdaFunctions        []LambdaFunction
+	ShutdownTimeout        int    // seconds to wait for requests to finish when shutting down, 30 by default
+	SnapshotFile           string // file the queues, messages and topics are written to when shutting down
 }
 
 var CurrentEnvironment Environment
 
+// environmentLock guards CurrentEnvironment, which is replaced as a whole when the
+// config file is reloaded while requests are reading it.
+var environmentLock sync.RWMutex
+
+// GetCurrentEnvironment returns a copy of CurrentEnvironment.
+func GetCurrentEnvironment() Environment {
+	environmentLock.RLock()
+	defer environmentLock.RUnlock()
+	return CurrentEnvironment
+}
+
+// SetCurrentEnvironment replaces CurrentEnvironment.
+func SetCurrentEnvironment(env Environment) {
+	environmentLock.Lock()
+	defer environmentLock.Unlock()
+	CurrentEnvironment = env
+}
+
 /*** Common ***/
 type ResponseMetadata struct {
 	RequestId string `xml:"RequestId"`
@@ -64,7 +113,53 @@ type ErrorResponse struct {
 	RequestId string      `xml:"RequestId"`
 }
 
+// TLS makes GoAws serve HTTPS. Without a CertFile and KeyFile, the certificate is
+// issued at startup by a generated CA, which is written to CAFile if set and served
+// at /_goaws/ca.pem so it can be added to trust stores.
+type TLS struct {
+	Enabled  bool
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	Hosts    []string // extra host names and IP addresses of the generated certificate
+}
+
+// SnsSigning is the certificate SNS messages are signed with and served at their
+// SigningCertURL. Without a CertFile and KeyFile, one is generated at startup.
+type SnsSigning struct {
+	CertFile string
+	KeyFile  string // an RSA key
+}
+
+// LambdaFunction is a local endpoint implementing the Lambda Invoke API, like
+// `sam local start-lambda` or the Lambda runtime interface emulator, that lambda
+// subscriptions to the function deliver to.
+type LambdaFunction struct {
+	Name     string // the function name or ARN
+	EndPoint string // base URL of the Invoke API, or the URL of the function's invocations
+}
+
 type RandomLatency struct {
 	Min int
 	Max int
 }
+
+// InFlightLimits caps the number of received but not yet deleted messages per queue.
+// Zero values fall back to the AWS limits.
+type InFlightLimits struct {
+	Standard int
+	FIFO     int
+}
+
+// FaultRule injects a fault into requests whose action and queue or topic name
+// match the Action and Resource glob patterns. An empty pattern matches anything.
+type FaultRule struct {
+	Id          string
+	Action      string
+	Resource    string
+	Fault       string   // throttle, error, reset, latency or duplicate
+	Probability *float64 // chance of a matching request being affected, defaults to 1
+	Count       int      // only affect the next Count requests, 0 for no limit
+	LatencyMs   int      // delay added by latency faults
+	Code        string   // overrides the error code returned by throttle and error faults
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"regexp"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+const (
+	// MaxBatchEntries is the maximum number of entries of an SQS or SNS batch request.
+	MaxBatchEntries = 10
+	// MaxBatchPayloadSize is the maximum combined size of the messages of a
+	// SendMessageBatch or PublishBatch request.
+	MaxBatchPayloadSize = 262144 // 256K
+)
+
+// batchEntryIdPattern matches the ids AWS accepts for batch entries: up to 80
+// alphanumeric characters, hyphens and underscores.
+var batchEntryIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,80}$`)
+
+// ValidateBatchEntryIds checks the ids of a batch request and returns the name
+// of the SqsErrors and SnsErrors entry describing the first problem found, or ""
+// if all of the ids are valid and distinct.
+func ValidateBatchEntryIds(ids []string) string {
+	if len(ids) == 0 {
+		return "EmptyBatchRequest"
+	}
+	if len(ids) > MaxBatchEntries {
+		return "TooManyEntriesInBatchRequest"
+	}
+	seen := map[string]struct{}{}
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			return "BatchEntryIdsNotDistinct"
+		}
+		seen[id] = struct{}{}
+	}
+	for _, id := range ids {
+		if !batchEntryIdPattern.MatchString(id) {
+			return "InvalidBatchEntryId"
+		}
+	}
+	return ""
+}
+
+// MessageSize returns the size SQS and SNS account for a message: the body plus
+// the name, data type and value of every message attribute.
+func MessageSize(body string, attributes map[string]app.MessageAttributeValue) int {
+	size := len(body)
+	for name, attr := range attributes {
+		size += len(name) + len(attr.DataType) + len(attr.Value)
+	}
+	return size
+}
+
+func NewBatchResultErrorEntry(id string, code string, message string) app.BatchResultErrorEntry {
+	return app.BatchResultErrorEntry{
+		Code:        code,
+		Id:          id,
+		Message:     message,
+		SenderFault: true,
+	}
+}
@@ -0,0 +1,28 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBatchEntryIds(t *testing.T) {
+	cases := map[string]struct {
+		ids      []string
+		expected string
+	}{
+		"valid":        {[]string{"a", "b-1", "c_2"}, ""},
+		"empty":        {[]string{}, "EmptyBatchRequest"},
+		"too_many":     {[]string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"}, "TooManyEntriesInBatchRequest"},
+		"not_distinct": {[]string{"a", "a"}, "BatchEntryIdsNotDistinct"},
+		"invalid_char": {[]string{"a.b"}, "InvalidBatchEntryId"},
+		"missing_id":   {[]string{""}, "InvalidBatchEntryId"},
+		"too_long":     {[]string{strings.Repeat("a", 81)}, "InvalidBatchEntryId"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := ValidateBatchEntryIds(c.ids); got != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, got)
+			}
+		})
+	}
+}
@@ -3,9 +3,11 @@ package common
 import (
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"hash"
 	"io"
@@ -36,6 +38,18 @@ func GetMD5Hash(text string) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
+func GetSHA256Hash(text string) string {
+	hasher := sha256.New()
+	hasher.Write([]byte(text))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// IsJSONObject reports whether text is a JSON object, as access and delivery policies must be.
+func IsJSONObject(text string) bool {
+	var object map[string]interface{}
+	return json.Unmarshal([]byte(text), &object) == nil && object != nil
+}
+
 func HashAttributes(attributes map[string]app.MessageAttributeValue) string {
 	hasher := md5.New()
 
@@ -3,215 +3,450 @@ package conf
 import (
 	"encoding/json"
 	"fmt"
-	"io/fs"
 	"os"
-	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/Admiral-Piett/goaws/app"
 	"github.com/Admiral-Piett/goaws/app/common"
-	"github.com/ghodss/yaml"
+	"github.com/Admiral-Piett/goaws/app/fault"
 )
 
 var envs map[string]app.Environment
 
-func LoadYamlConfig(filename string, env string) []string {
+// DefaultConfigFiles are where LoadYamlConfig looks for a config file when none is
+// given, in order: the working directory, the layout of the docker image, the root
+// of this repository and the volume docker-compose.yml mounts.
+var DefaultConfigFiles = []string{"goaws.yaml", "conf/goaws.yaml", "app/conf/goaws.yaml", "/conf/goaws.yaml"}
+
+// loaded remembers the config file and environment LoadYamlConfig used, so they
+// can be reloaded, and the resources created from them, so a reload can tell
+// which ones were removed from the file.
+var loaded = struct {
+	sync.Mutex
+	filename      string
+	env           string
+	info          os.FileInfo // of the file when it was last read
+	overrides     Overrides
+	queues        map[string]bool
+	topics        map[string]bool
+	subscriptions map[string]bool
+}{}
+
+// LoadYamlConfig loads the given environment of the config file, looking through
+// DefaultConfigFiles when no file is given, applies the overrides set by SetOverrides,
+// imports their CloudFormation templates and returns the ports to listen on. The whole file is validated first: if anything
+// in it is invalid nothing is applied and a *ValidationError is returned. Without a
+// config file only the overrides and the defaults are applied.
+func LoadYamlConfig(filename string, env string) ([]string, error) {
 	ports := []string{"4100"}
+	if env == "" {
+		env = "Local"
+	}
 
 	if filename == "" {
-		root, _ := filepath.Abs(".")
-		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-			if "goaws.yaml" == d.Name() {
-				filename = path
+		for _, candidate := range DefaultConfigFiles {
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				filename = candidate
+				break
 			}
-			return nil
-		})
-		if err != nil || filename == "" {
-			log.Warn("Failure to find default config file")
-			return ports
 		}
 	}
-	log.Infof("Loading config file: %s", filename)
-	yamlFile, err := os.ReadFile(filename)
+
+	environment := app.Environment{}
+	var info os.FileInfo
+	if filename == "" {
+		log.Warn("Failure to find default config file, using the defaults")
+		envs = map[string]app.Environment{env: environment}
+	} else {
+		log.Infof("Loading config file: %s", filename)
+		info, _ = os.Stat(filename)
+		yamlFile, err := os.ReadFile(filename)
+		if err != nil {
+			return ports, err
+		}
+
+		environments, err := validateConfig(filename, yamlFile)
+		if err != nil {
+			return ports, err
+		}
+		var ok bool
+		environment, ok = environments[env]
+		if !ok {
+			return ports, fmt.Errorf("environment %s not found in config file %s", env, filename)
+		}
+		envs = environments
+	}
+
+	loaded.Lock()
+	defer loaded.Unlock()
+	environment, err := loaded.overrides.importTemplates(loaded.overrides.apply(environment))
 	if err != nil {
-		return ports
+		return ports, err
+	}
+	loaded.filename, loaded.env, loaded.info = filename, env, info
+	loaded.queues = map[string]bool{}
+	loaded.topics = map[string]bool{}
+	loaded.subscriptions = map[string]bool{}
+
+	if environment.Port != "" {
+		ports = []string{environment.Port}
+	} else if environment.SqsPort != "" && environment.SnsPort != "" {
+		ports = []string{environment.SqsPort, environment.SnsPort}
+	}
+
+	if environment.RandomSeed != 0 {
+		app.SeedRandom(environment.RandomSeed)
+	}
+	fault.SetRules(environment.Faults)
+	applyConfig(environment, false)
+	return ports, nil
+}
+
+// ReloadYamlConfig reads the config file loaded by LoadYamlConfig again and reconciles
+// the queues, topics and subscriptions with it: new ones are created and existing ones
+// updated, keeping their messages. Resources removed from the file are only deleted
+// when the environment sets PruneOnReload. The overrides set by SetOverrides still
+// win over the file. Changes to the ports need a restart. Nothing changes if the
+// file is invalid.
+func ReloadYamlConfig() error {
+	loaded.Lock()
+	defer loaded.Unlock()
+	if loaded.filename == "" {
+		return fmt.Errorf("no config file loaded")
 	}
 
-	err = yaml.Unmarshal(yamlFile, &envs)
+	log.Infof("Reloading config file: %s", loaded.filename)
+	loaded.info, _ = os.Stat(loaded.filename)
+	yamlFile, err := os.ReadFile(loaded.filename)
 	if err != nil {
-		log.Errorf("err: %v\n", err)
-		return ports
+		return err
 	}
-	if env == "" {
-		env = "Local"
+	reloaded, err := validateConfig(loaded.filename, yamlFile)
+	if err != nil {
+		return err
 	}
+	environment, ok := reloaded[loaded.env]
+	if !ok {
+		return fmt.Errorf("environment %s not found in config file %s", loaded.env, loaded.filename)
+	}
+	environment, err = loaded.overrides.importTemplates(loaded.overrides.apply(environment))
+	if err != nil {
+		return err
+	}
+	previous := loaded.overrides.apply(envs[loaded.env])
+	if environment.Port != previous.Port || environment.SqsPort != previous.SqsPort ||
+		environment.SnsPort != previous.SnsPort {
+		log.Warn("Port changes in the config file only take effect after a restart")
+	}
+	envs = reloaded
+
+	// applying them again would restart the random numbers and drop the fault rules
+	// added through the admin API
+	if environment.RandomSeed != 0 && environment.RandomSeed != previous.RandomSeed {
+		app.SeedRandom(environment.RandomSeed)
+	}
+	if !reflect.DeepEqual(environment.Faults, previous.Faults) {
+		fault.SetRules(environment.Faults)
+	}
+	applyConfig(environment, environment.PruneOnReload)
+	return nil
+}
 
-	if envs[env].Region == "" {
-		app.CurrentEnvironment.Region = "local"
+// WatchYamlConfig reloads the config file whenever its modification time or size
+// changes, checking every interval until quit is closed.
+func WatchYamlConfig(interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !configChanged() {
+				continue
+			}
+			if err := ReloadYamlConfig(); err != nil {
+				log.Errorf("err: failed to reload config: %s", err)
+			}
+		case <-quit:
+			return
+		}
 	}
+}
 
-	app.CurrentEnvironment = envs[env]
+func configChanged() bool {
+	loaded.Lock()
+	defer loaded.Unlock()
+	if loaded.filename == "" {
+		return false
+	}
+	info, err := os.Stat(loaded.filename)
+	if err != nil {
+		return false // the file may be in the middle of being replaced
+	}
+	return loaded.info == nil || !info.ModTime().Equal(loaded.info.ModTime()) || info.Size() != loaded.info.Size()
+}
 
-	if envs[env].Port != "" {
-		ports = []string{envs[env].Port}
-	} else if envs[env].SqsPort != "" && envs[env].SnsPort != "" {
-		ports = []string{envs[env].SqsPort, envs[env].SnsPort}
-		app.CurrentEnvironment.Port = envs[env].SqsPort
+// applyConfig makes environment the current one and creates or updates its queues,
+// topics and subscriptions. With prune, the ones created by a previous load but
+// missing from environment are deleted. The environment must have been validated
+// and loaded must be locked.
+func applyConfig(environment app.Environment, prune bool) {
+	if environment.Port == "" && environment.SqsPort != "" && environment.SnsPort != "" {
+		environment.Port = environment.SqsPort
 	}
 
 	common.LogMessages = false
 	common.LogFile = "./goaws_messages.log"
 
-	if envs[env].LogToFile == true {
+	if environment.LogToFile == true {
 		common.LogMessages = true
-		if envs[env].LogFile != "" {
-			common.LogFile = envs[env].LogFile
+		if environment.LogFile != "" {
+			common.LogFile = environment.LogFile
 		}
 	}
 
-	if app.CurrentEnvironment.QueueAttributeDefaults.VisibilityTimeout == 0 {
-		app.CurrentEnvironment.QueueAttributeDefaults.VisibilityTimeout = 30
+	if environment.QueueAttributeDefaults.VisibilityTimeout == 0 {
+		environment.QueueAttributeDefaults.VisibilityTimeout = 30
+	}
+
+	if environment.QueueAttributeDefaults.MaximumMessageSize == 0 {
+		environment.QueueAttributeDefaults.MaximumMessageSize = 262144 // 256K
 	}
 
-	if app.CurrentEnvironment.QueueAttributeDefaults.MaximumMessageSize == 0 {
-		app.CurrentEnvironment.QueueAttributeDefaults.MaximumMessageSize = 262144 // 256K
+	if environment.ShutdownTimeout == 0 {
+		environment.ShutdownTimeout = 30
 	}
 
-	if app.CurrentEnvironment.AccountID == "" {
-		app.CurrentEnvironment.AccountID = "queue"
+	if environment.AccountID == "" {
+		environment.AccountID = "queue"
 	}
 
-	if app.CurrentEnvironment.Host == "" {
-		app.CurrentEnvironment.Host = "localhost"
-		app.CurrentEnvironment.Port = "4100"
+	if environment.Host == "" {
+		environment.Host = "localhost"
 	}
 
+	if environment.Port == "" {
+		environment.Port = "4100"
+	}
+
+	app.SetCurrentEnvironment(environment)
+
 	app.SyncQueues.Lock()
+	defer app.SyncQueues.Unlock()
 	app.SyncTopics.Lock()
-	for _, queue := range envs[env].Queues {
-		queueUrl := "http://" + app.CurrentEnvironment.Host + ":" + app.CurrentEnvironment.Port +
-			"/" + app.CurrentEnvironment.AccountID + "/" + queue.Name
-		if app.CurrentEnvironment.Region != "" {
-			queueUrl = "http://" + app.CurrentEnvironment.Region + "." + app.CurrentEnvironment.Host + ":" +
-				app.CurrentEnvironment.Port + "/" + app.CurrentEnvironment.AccountID + "/" + queue.Name
-		}
-		queueArn := "arn:aws:sqs:" + app.CurrentEnvironment.Region + ":" + app.CurrentEnvironment.AccountID + ":" + queue.Name
+	defer app.SyncTopics.Unlock()
+
+	queues := map[string]bool{}
+	topics := map[string]bool{}
+	subscriptions := map[string]bool{}
+	defer func() {
+		loaded.queues, loaded.topics, loaded.subscriptions = queues, topics, subscriptions
+	}()
 
+	for _, queue := range environment.Queues {
 		if queue.ReceiveMessageWaitTimeSeconds == 0 {
-			queue.ReceiveMessageWaitTimeSeconds = app.CurrentEnvironment.QueueAttributeDefaults.ReceiveMessageWaitTimeSeconds
+			queue.ReceiveMessageWaitTimeSeconds = environment.QueueAttributeDefaults.ReceiveMessageWaitTimeSeconds
 		}
 
 		if queue.MaximumMessageSize == 0 {
-			queue.MaximumMessageSize = app.CurrentEnvironment.QueueAttributeDefaults.MaximumMessageSize
+			queue.MaximumMessageSize = environment.QueueAttributeDefaults.MaximumMessageSize
 		}
 
 		if queue.VisibilityTimeout == 0 {
-			queue.VisibilityTimeout = app.CurrentEnvironment.QueueAttributeDefaults.VisibilityTimeout
+			queue.VisibilityTimeout = environment.QueueAttributeDefaults.VisibilityTimeout
 		}
 
-		app.SyncQueues.Queues[queue.Name] = &app.Queue{
-			Name:                queue.Name,
-			TimeoutSecs:         queue.VisibilityTimeout,
-			Arn:                 queueArn,
-			URL:                 queueUrl,
-			ReceiveWaitTimeSecs: queue.ReceiveMessageWaitTimeSeconds,
-			MaximumMessageSize:  queue.MaximumMessageSize,
-			IsFIFO:              app.HasFIFOQueueName(queue.Name),
-			EnableDuplicates:    app.CurrentEnvironment.EnableDuplicates,
-			Duplicates:          make(map[string]time.Time),
+		if queue.DuplicateProbability == 0 {
+			queue.DuplicateProbability = environment.QueueAttributeDefaults.DuplicateProbability
 		}
+
+		if queue.ReorderProbability == 0 {
+			queue.ReorderProbability = environment.QueueAttributeDefaults.ReorderProbability
+		}
+
+		q := getOrCreateQueue(queue.Name)
+		q.TimeoutSecs = queue.VisibilityTimeout
+		q.ReceiveWaitTimeSecs = queue.ReceiveMessageWaitTimeSeconds
+		q.MaximumMessageSize = queue.MaximumMessageSize
+		q.EnableDuplicates = environment.EnableDuplicates
+		q.DuplicateProbability = queue.DuplicateProbability
+		q.ReorderProbability = queue.ReorderProbability
+		q.DelaySecs = queue.DelaySeconds
+		q.ContentBasedDeduplication = queue.ContentBasedDeduplication
+		q.MessageRetentionPeriod = queue.MessageRetentionPeriod
+		q.Policy = queue.Policy
+		q.Tags = queue.Tags
+		q.KmsMasterKeyId = queue.KmsMasterKeyId
+		q.KmsDataKeyReuseSecs = queue.KmsDataKeyReusePeriodSeconds
+		q.SqsManagedSse = queue.SqsManagedSseEnabled
+		queues[queue.Name] = true
 	}
 
 	// loop one more time to create queue's RedrivePolicy and assign deadletter queues in case dead letter queue is defined first in the config
-	for _, queue := range envs[env].Queues {
+	for _, queue := range environment.Queues {
 		q := app.SyncQueues.Queues[queue.Name]
-		if queue.RedrivePolicy != "" {
-			err := setQueueRedrivePolicy(app.SyncQueues.Queues, q, queue.RedrivePolicy)
-			if err != nil {
-				log.Errorf("err: %s", err)
-				return ports
-			}
+		if queue.RedrivePolicy == "" {
+			q.DeadLetterQueue, q.MaxReceiveCount = nil, 0
+			continue
 		}
-
+		maxReceiveCount, deadLetterQueueName, _ := parseRedrivePolicy(queue.RedrivePolicy)
+		q.DeadLetterQueue = app.SyncQueues.Queues[deadLetterQueueName]
+		q.MaxReceiveCount = maxReceiveCount
 	}
 
-	for _, topic := range envs[env].Topics {
-		topicArn := "arn:aws:sns:" + app.CurrentEnvironment.Region + ":" + app.CurrentEnvironment.AccountID + ":" + topic.Name
+	for _, topic := range environment.Topics {
+		topicArn := "arn:aws:sns:" + environment.Region + ":" + environment.AccountID + ":" + topic.Name
 
-		newTopic := &app.Topic{Name: topic.Name, Arn: topicArn}
-		newTopic.Subscriptions = make([]*app.Subscription, 0, 0)
+		t, ok := app.SyncTopics.Topics[topic.Name]
+		if !ok {
+			log.Println("Creating Topic:", topic.Name)
+			t = &app.Topic{Name: topic.Name, Arn: topicArn}
+			t.Subscriptions = make([]*app.Subscription, 0, 0)
+			app.SyncTopics.Topics[topic.Name] = t
+		}
+		t.DisplayName = topic.DisplayName
+		t.KmsMasterKeyId = topic.KmsMasterKeyId
+		t.Policy = topic.Policy
+		t.DeliveryPolicy = topic.DeliveryPolicy
+		t.SignatureVersion = topic.SignatureVersion
+		t.Tags = topic.Tags
+		topics[topic.Name] = true
 
 		for _, subs := range topic.Subscriptions {
 			var newSub *app.Subscription
-			if strings.Contains(subs.Protocol, "http") {
-				newSub = createHttpSubscription(subs)
+			if subs.Protocol != "" && app.Protocol(subs.Protocol) != app.ProtocolSQS {
+				newSub = createHttpSubscription(subs, t.Arn)
 			} else {
 				//Queue does not exist yet, create it.
-				newSub = createSqsSubscription(subs, topicArn)
+				newSub = createSqsSubscription(subs, t.Arn)
+				queues[subs.QueueName] = true
 			}
 			if subs.FilterPolicy != "" {
 				filterPolicy := &app.FilterPolicy{}
-				err = json.Unmarshal([]byte(subs.FilterPolicy), filterPolicy)
-				if err != nil {
-					log.Errorf("err: %s", err)
-					return ports
-				}
+				json.Unmarshal([]byte(subs.FilterPolicy), filterPolicy)
 				newSub.FilterPolicy = filterPolicy
 			}
+			newSub.DeliveryPolicy = subs.DeliveryPolicy
 
-			newTopic.Subscriptions = append(newTopic.Subscriptions, newSub)
+			if existing := findSubscription(t, newSub.Protocol, newSub.EndPoint); existing != nil {
+				existing.Raw = newSub.Raw
+				existing.FilterPolicy = newSub.FilterPolicy
+				existing.DeliveryPolicy = newSub.DeliveryPolicy
+				newSub = existing
+			} else {
+				t.Subscriptions = append(t.Subscriptions, newSub)
+			}
+			subscriptions[newSub.SubscriptionArn] = true
 		}
-		app.SyncTopics.Topics[topic.Name] = newTopic
 	}
 
-	app.SyncQueues.Unlock()
-	app.SyncTopics.Unlock()
+	if prune {
+		pruneRemoved(queues, topics, subscriptions)
+	}
+}
 
-	return ports
+// pruneRemoved deletes the queues, topics and subscriptions created by the previous
+// load that are not in the given sets. Queues that used a deleted queue as their
+// dead letter queue are left without one.
+func pruneRemoved(queues, topics, subscriptions map[string]bool) {
+	for name := range loaded.queues {
+		if !queues[name] {
+			log.Println("Deleting Queue removed from config:", name)
+			delete(app.SyncQueues.Queues, name)
+		}
+	}
+	for _, queue := range app.SyncQueues.Queues {
+		if queue.DeadLetterQueue != nil && app.SyncQueues.Queues[queue.DeadLetterQueue.Name] != queue.DeadLetterQueue {
+			log.Println("Removing deleted dead letter queue", queue.DeadLetterQueue.Name, "from Queue:", queue.Name)
+			queue.DeadLetterQueue, queue.MaxReceiveCount = nil, 0
+		}
+	}
+	for name := range loaded.topics {
+		if !topics[name] {
+			log.Println("Deleting Topic removed from config:", name)
+			delete(app.SyncTopics.Topics, name)
+		}
+	}
+	for _, topic := range app.SyncTopics.Topics {
+		kept := make([]*app.Subscription, 0, len(topic.Subscriptions))
+		for _, sub := range topic.Subscriptions {
+			if loaded.subscriptions[sub.SubscriptionArn] && !subscriptions[sub.SubscriptionArn] {
+				log.Println("Deleting Subscription removed from config:", sub.SubscriptionArn)
+				continue
+			}
+			kept = append(kept, sub)
+		}
+		topic.Subscriptions = kept
+	}
+}
+
+func findSubscription(topic *app.Topic, protocol string, endpoint string) *app.Subscription {
+	for _, sub := range topic.Subscriptions {
+		if sub.Protocol == protocol && sub.EndPoint == endpoint {
+			return sub
+		}
+	}
+	return nil
 }
 
-func createHttpSubscription(configSubscription app.EnvSubsciption) *app.Subscription {
-	newSub := &app.Subscription{EndPoint: configSubscription.EndPoint, Protocol: configSubscription.Protocol, TopicArn: configSubscription.TopicArn, Raw: configSubscription.Raw}
+// getOrCreateQueue returns the queue with the given name, creating it with the
+// default attributes if it does not exist. app.SyncQueues must be locked.
+func getOrCreateQueue(name string) *app.Queue {
+	if q, ok := app.SyncQueues.Queues[name]; ok {
+		return q
+	}
+	queueUrl := app.QueueURL(name)
+	env := app.GetCurrentEnvironment()
+	queueArn := "arn:aws:sqs:" + env.Region + ":" + env.AccountID + ":" + name
+	log.Println("Creating Queue:", name)
+	q := &app.Queue{
+		Name:                 name,
+		TimeoutSecs:          env.QueueAttributeDefaults.VisibilityTimeout,
+		Arn:                  queueArn,
+		URL:                  queueUrl,
+		ReceiveWaitTimeSecs:  env.QueueAttributeDefaults.ReceiveMessageWaitTimeSeconds,
+		MaximumMessageSize:   env.QueueAttributeDefaults.MaximumMessageSize,
+		IsFIFO:               app.HasFIFOQueueName(name),
+		EnableDuplicates:     env.EnableDuplicates,
+		Duplicates:           make(map[string]time.Time),
+		DuplicateProbability: env.QueueAttributeDefaults.DuplicateProbability,
+		ReorderProbability:   env.QueueAttributeDefaults.ReorderProbability,
+	}
+	app.SyncQueues.Queues[name] = q
+	return q
+}
+
+// createHttpSubscription creates a subscription with an EndPoint rather than a
+// QueueName to the topic, or to the TopicArn of the subscription if it has one.
+func createHttpSubscription(configSubscription app.EnvSubsciption, topicArn string) *app.Subscription {
+	if configSubscription.TopicArn != "" {
+		topicArn = configSubscription.TopicArn
+	}
+	// subscriptions of the config file don't need to be confirmed
+	newSub := &app.Subscription{EndPoint: configSubscription.EndPoint, Protocol: configSubscription.Protocol, TopicArn: topicArn, Raw: configSubscription.Raw, ConfirmationWasAuthenticated: true}
 	subArn, _ := common.NewUUID()
-	subArn = configSubscription.TopicArn + ":" + subArn
+	subArn = topicArn + ":" + subArn
 	newSub.SubscriptionArn = subArn
 	return newSub
 }
 
 func createSqsSubscription(configSubscription app.EnvSubsciption, topicArn string) *app.Subscription {
-	if _, ok := app.SyncQueues.Queues[configSubscription.QueueName]; !ok {
-		queueUrl := "http://" + app.CurrentEnvironment.Host + ":" + app.CurrentEnvironment.Port +
-			"/" + app.CurrentEnvironment.AccountID + "/" + configSubscription.QueueName
-		if app.CurrentEnvironment.Region != "" {
-			queueUrl = "http://" + app.CurrentEnvironment.Region + "." + app.CurrentEnvironment.Host + ":" +
-				app.CurrentEnvironment.Port + "/" + app.CurrentEnvironment.AccountID + "/" + configSubscription.QueueName
-		}
-		queueArn := "arn:aws:sqs:" + app.CurrentEnvironment.Region + ":" + app.CurrentEnvironment.AccountID + ":" + configSubscription.QueueName
-		app.SyncQueues.Queues[configSubscription.QueueName] = &app.Queue{
-			Name:                configSubscription.QueueName,
-			TimeoutSecs:         app.CurrentEnvironment.QueueAttributeDefaults.VisibilityTimeout,
-			Arn:                 queueArn,
-			URL:                 queueUrl,
-			ReceiveWaitTimeSecs: app.CurrentEnvironment.QueueAttributeDefaults.ReceiveMessageWaitTimeSeconds,
-			MaximumMessageSize:  app.CurrentEnvironment.QueueAttributeDefaults.MaximumMessageSize,
-			IsFIFO:              app.HasFIFOQueueName(configSubscription.QueueName),
-			EnableDuplicates:    app.CurrentEnvironment.EnableDuplicates,
-			Duplicates:          make(map[string]time.Time),
-		}
-	}
-	qArn := app.SyncQueues.Queues[configSubscription.QueueName].Arn
-	newSub := &app.Subscription{EndPoint: qArn, Protocol: "sqs", TopicArn: topicArn, Raw: configSubscription.Raw}
+	qArn := getOrCreateQueue(configSubscription.QueueName).Arn
+	newSub := &app.Subscription{EndPoint: qArn, Protocol: "sqs", TopicArn: topicArn, Raw: configSubscription.Raw, ConfirmationWasAuthenticated: true}
 	subArn, _ := common.NewUUID()
 	subArn = topicArn + ":" + subArn
 	newSub.SubscriptionArn = subArn
 	return newSub
 }
 
-func setQueueRedrivePolicy(queues map[string]*app.Queue, q *app.Queue, strRedrivePolicy string) error {
+// parseRedrivePolicy returns the maxReceiveCount and the name of the dead letter
+// queue of a RedrivePolicy.
+func parseRedrivePolicy(strRedrivePolicy string) (int, string, error) {
 	// support both int and string maxReceiveCount (Amazon clients use string)
 	redrivePolicy1 := struct {
 		MaxReceiveCount     int    `json:"maxReceiveCount"`
@@ -226,24 +461,15 @@ func setQueueRedrivePolicy(queues map[string]*app.Queue, q *app.Queue, strRedriv
 	maxReceiveCount := redrivePolicy1.MaxReceiveCount
 	deadLetterQueueArn := redrivePolicy1.DeadLetterTargetArn
 	if err1 != nil && err2 != nil {
-		return fmt.Errorf("invalid json for queue redrive policy ")
+		return 0, "", fmt.Errorf("invalid json for queue redrive policy")
 	} else if err1 != nil {
 		maxReceiveCount, _ = strconv.Atoi(redrivePolicy2.MaxReceiveCount)
 		deadLetterQueueArn = redrivePolicy2.DeadLetterTargetArn
 	}
 
-	if (deadLetterQueueArn != "" && maxReceiveCount == 0) ||
-		(deadLetterQueueArn == "" && maxReceiveCount != 0) {
-		return fmt.Errorf("invalid redrive policy values")
+	if deadLetterQueueArn == "" || maxReceiveCount <= 0 {
+		return 0, "", fmt.Errorf("invalid redrive policy values, it needs a deadLetterTargetArn and a maxReceiveCount > 0")
 	}
 	dlt := strings.Split(deadLetterQueueArn, ":")
-	deadLetterQueueName := dlt[len(dlt)-1]
-	deadLetterQueue, ok := queues[deadLetterQueueName]
-	if !ok {
-		return fmt.Errorf("deadletter queue not found")
-	}
-	q.DeadLetterQueue = deadLetterQueue
-	q.MaxReceiveCount = maxReceiveCount
-
-	return nil
+	return maxReceiveCount, dlt[len(dlt)-1], nil
 }
@@ -1,16 +1,23 @@
 package conf
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/fault"
 )
 
 func TestConfig_NoQueuesOrTopics(t *testing.T) {
 	env := "NoQueuesOrTopics"
-	port := LoadYamlConfig("./mock-data/mock-config.yaml", env)
+	port, err := LoadYamlConfig("./mock-data/mock-config.yaml", env)
+	if err != nil {
+		t.Fatalf("Unexpected error loading the config: %s\n", err)
+	}
 	if port[0] != "4100" {
 		t.Errorf("Expected port number 4200 but got %s\n", port)
 	}
@@ -36,17 +43,20 @@ func TestConfig_NoQueuesOrTopics(t *testing.T) {
 
 func TestConfig_CreateQueuesTopicsAndSubscriptions(t *testing.T) {
 	env := "Local"
-	port := LoadYamlConfig("./mock-data/mock-config.yaml", env)
+	port, err := LoadYamlConfig("./mock-data/mock-config.yaml", env)
+	if err != nil {
+		t.Fatalf("Unexpected error loading the config: %s\n", err)
+	}
 	if port[0] != "4100" {
 		t.Errorf("Expected port number 4100 but got %s\n", port)
 	}
 
 	numQueues := len(envs[env].Queues)
-	if numQueues != 4 {
+	if numQueues != 5 {
 		t.Errorf("Expected three queues to be in the environment but got %d\n", numQueues)
 	}
 	numQueues = len(app.SyncQueues.Queues)
-	if numQueues != 6 {
+	if numQueues != 7 {
 		t.Errorf("Expected five queues to be in the sqs topics but got %d\n", numQueues)
 	}
 
@@ -62,7 +72,10 @@ func TestConfig_CreateQueuesTopicsAndSubscriptions(t *testing.T) {
 
 func TestConfig_QueueAttributes(t *testing.T) {
 	env := "Local"
-	port := LoadYamlConfig("./mock-data/mock-config.yaml", env)
+	port, err := LoadYamlConfig("./mock-data/mock-config.yaml", env)
+	if err != nil {
+		t.Fatalf("Unexpected error loading the config: %s\n", err)
+	}
 	if port[0] != "4100" {
 		t.Errorf("Expected port number 4100 but got %s\n", port)
 	}
@@ -109,6 +122,39 @@ func TestConfig_QueueAttributes(t *testing.T) {
 	}
 }
 
+func TestConfig_InFlightLimits(t *testing.T) {
+	LoadYamlConfig("./mock-data/mock-config.yaml", "Local")
+	assert.Equal(t, 5, app.SyncQueues.Queues["local-queue1"].InFlightLimit())
+	assert.Equal(t, 2, (&app.Queue{IsFIFO: true}).InFlightLimit())
+
+	LoadYamlConfig("./mock-data/mock-config.yaml", "NoQueuesOrTopics")
+	assert.Equal(t, app.DefaultStandardInFlightLimit, (&app.Queue{}).InFlightLimit())
+	assert.Equal(t, app.DefaultFIFOInFlightLimit, (&app.Queue{IsFIFO: true}).InFlightLimit())
+}
+
+func TestConfig_Faults(t *testing.T) {
+	LoadYamlConfig("./mock-data/mock-config.yaml", "Local")
+	rules := fault.Rules()
+	assert.Len(t, rules, 2)
+	assert.Equal(t, "throttle", rules[0].Fault)
+	assert.Equal(t, "local-queue*", rules[0].Resource)
+	assert.Equal(t, 0.5, *rules[0].Probability)
+	assert.Equal(t, 100, rules[1].LatencyMs)
+	assert.Equal(t, 3, rules[1].Count)
+
+	LoadYamlConfig("./mock-data/mock-config.yaml", "NoQueuesOrTopics")
+	assert.Len(t, fault.Rules(), 0)
+}
+
+func TestConfig_DuplicateAndReorderProbabilities(t *testing.T) {
+	LoadYamlConfig("./mock-data/mock-config.yaml", "Local")
+	assert.Equal(t, 0.0, app.SyncQueues.Queues["local-queue1"].DuplicateProbability)
+	assert.Equal(t, 0.25, app.SyncQueues.Queues["local-queue1"].ReorderProbability)
+	assert.Equal(t, 0.25, app.SyncQueues.Queues["local-queue4"].ReorderProbability)
+	assert.Equal(t, 0.5, app.SyncQueues.Queues["local-queue-flaky"].DuplicateProbability)
+	assert.Equal(t, 0.75, app.SyncQueues.Queues["local-queue-flaky"].ReorderProbability)
+}
+
 func TestConfig_NoQueueAttributeDefaults(t *testing.T) {
 	env := "NoQueueAttributeDefaults"
 	LoadYamlConfig("./mock-data/mock-config.yaml", env)
@@ -157,3 +203,319 @@ func TestConfig_LoadYamlConfig_finds_default_config(t *testing.T) {
 		assert.True(t, ok)
 	}
 }
+
+func writeConfig(t *testing.T, filename string, config string) {
+	if err := os.WriteFile(filename, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfig_ReloadYamlConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "goaws.yaml")
+	writeConfig(t, filename, `
+Reload:
+  Host: localhost
+  Port: 4100
+  Region: us-east-1
+  AccountId: "100010001000"
+  Queues:
+    - Name: reload-queue1
+      VisibilityTimeout: 10
+    - Name: reload-queue2
+  Topics:
+    - Name: reload-topic1
+      Subscriptions:
+        - QueueName: reload-queue1
+`)
+	_, err := LoadYamlConfig(filename, "Reload")
+	assert.NoError(t, err)
+
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["reload-queue1"].Messages = []app.Message{{Uuid: "1", MessageBody: []byte("1")}}
+	app.SyncQueues.Unlock()
+	subArn := app.SyncTopics.Topics["reload-topic1"].Subscriptions[0].SubscriptionArn
+
+	writeConfig(t, filename, `
+Reload:
+  Host: localhost
+  Port: 4100
+  Region: us-east-1
+  AccountId: "100010001000"
+  Queues:
+    - Name: reload-queue1
+      VisibilityTimeout: 20
+    - Name: reload-queue3
+  Topics:
+    - Name: reload-topic1
+      Subscriptions:
+        - QueueName: reload-queue1
+          Raw: true
+        - QueueName: reload-queue3
+    - Name: reload-topic2
+`)
+	assert.NoError(t, ReloadYamlConfig())
+
+	queue1 := app.SyncQueues.Queues["reload-queue1"]
+	assert.Equal(t, 20, queue1.TimeoutSecs)
+	assert.Len(t, queue1.Messages, 1)
+	assert.Contains(t, app.SyncQueues.Queues, "reload-queue3")
+	assert.Contains(t, app.SyncTopics.Topics, "reload-topic2")
+
+	subscriptions := app.SyncTopics.Topics["reload-topic1"].Subscriptions
+	assert.Len(t, subscriptions, 2)
+	assert.Equal(t, subArn, subscriptions[0].SubscriptionArn)
+	assert.True(t, subscriptions[0].Raw)
+
+	// without PruneOnReload removed resources are kept
+	assert.Contains(t, app.SyncQueues.Queues, "reload-queue2")
+
+	writeConfig(t, filename, `
+Reload:
+  Host: localhost
+  Port: 4100
+  Region: us-east-1
+  AccountId: "100010001000"
+  PruneOnReload: true
+  Queues:
+    - Name: reload-queue1
+  Topics:
+    - Name: reload-topic1
+`)
+	assert.NoError(t, ReloadYamlConfig())
+
+	assert.Len(t, app.SyncQueues.Queues["reload-queue1"].Messages, 1)
+	assert.NotContains(t, app.SyncQueues.Queues, "reload-queue3")
+	assert.NotContains(t, app.SyncTopics.Topics, "reload-topic2")
+	assert.Len(t, app.SyncTopics.Topics["reload-topic1"].Subscriptions, 0)
+	// reload-queue2 was already removed from the file by the previous reload
+	assert.Contains(t, app.SyncQueues.Queues, "reload-queue2")
+
+	writeConfig(t, filename, "Reload: [")
+	assert.Error(t, ReloadYamlConfig())
+	assert.Contains(t, app.SyncQueues.Queues, "reload-queue1")
+}
+
+func TestConfig_ReloadYamlConfig_PrunesDeadLetterQueues(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "goaws.yaml")
+	writeConfig(t, filename, `
+Prune:
+  PruneOnReload: true
+  Queues:
+    - Name: prune-queue
+    - Name: prune-dlq
+`)
+	_, err := LoadYamlConfig(filename, "Prune")
+	assert.NoError(t, err)
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "prune-queue")
+		delete(app.SyncQueues.Queues, "prune-api-queue")
+		app.SyncQueues.Unlock()
+	}()
+
+	// a queue created through the API using a queue from the config as its dead letter queue
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["prune-api-queue"] = &app.Queue{Name: "prune-api-queue",
+		DeadLetterQueue: app.SyncQueues.Queues["prune-dlq"], MaxReceiveCount: 3}
+	app.SyncQueues.Unlock()
+
+	writeConfig(t, filename, `
+Prune:
+  PruneOnReload: true
+  Queues:
+    - Name: prune-queue
+`)
+	assert.NoError(t, ReloadYamlConfig())
+
+	assert.NotContains(t, app.SyncQueues.Queues, "prune-dlq")
+	apiQueue := app.SyncQueues.Queues["prune-api-queue"]
+	assert.Nil(t, apiQueue.DeadLetterQueue)
+	assert.Equal(t, 0, apiQueue.MaxReceiveCount)
+}
+
+func TestConfig_ReloadYamlConfig_KeepsFaultsAndRandomNumbers(t *testing.T) {
+	defer fault.SetRules(nil)
+	filename := filepath.Join(t.TempDir(), "goaws.yaml")
+	writeConfig(t, filename, `
+Reload:
+  RandomSeed: 42
+  Faults:
+    - Action: SendMessage
+      Fault: throttle
+`)
+	_, err := LoadYamlConfig(filename, "Reload")
+	assert.NoError(t, err)
+	first := app.RandomFloat64()
+	_, err = fault.AddRule(app.FaultRule{Action: "Publish", Fault: fault.Error})
+	assert.NoError(t, err)
+
+	// the sections didn't change, the RNG isn't reseeded and the admin rule is kept
+	assert.NoError(t, ReloadYamlConfig())
+	assert.NotEqual(t, first, app.RandomFloat64())
+	assert.Len(t, fault.Rules(), 2)
+
+	writeConfig(t, filename, `
+Reload:
+  RandomSeed: 42
+  Faults:
+    - Action: SendMessage
+      Fault: error
+`)
+	assert.NoError(t, ReloadYamlConfig())
+	rules := fault.Rules()
+	if assert.Len(t, rules, 1) {
+		assert.Equal(t, fault.Error, rules[0].Fault)
+	}
+}
+
+func TestConfig_WatchYamlConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "goaws.yaml")
+	writeConfig(t, filename, `
+Watch:
+  Queues:
+    - Name: watch-queue1
+`)
+	_, err := LoadYamlConfig(filename, "Watch")
+	assert.NoError(t, err)
+
+	quit := make(chan struct{})
+	defer close(quit)
+	go WatchYamlConfig(10*time.Millisecond, quit)
+
+	writeConfig(t, filename, `
+Watch:
+  Queues:
+    - Name: watch-queue1
+    - Name: watch-queue2
+`)
+	assert.Eventually(t, func() bool {
+		app.SyncQueues.RLock()
+		defer app.SyncQueues.RUnlock()
+		_, ok := app.SyncQueues.Queues["watch-queue2"]
+		return ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConfig_AllAttributes(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "goaws.yaml")
+	writeConfig(t, filename, `
+Attributes:
+  Queues:
+    - Name: attributes-queue.fifo
+      FifoQueue: true
+      ContentBasedDeduplication: true
+      DelaySeconds: 5
+      MessageRetentionPeriod: 3600
+      Policy: '{"Version": "2012-10-17"}'
+      Tags:
+        team: payments
+      RedrivePolicy: '{"maxReceiveCount": 3, "deadLetterTargetArn": "arn:aws:sqs:local:queue:attributes-dlq.fifo"}'
+    - Name: attributes-dlq.fifo
+  Topics:
+    - Name: attributes-topic
+      DisplayName: Attributes
+      Policy: '{"Version": "2012-10-17"}'
+      DeliveryPolicy: '{"http": {}}'
+      SignatureVersion: "2"
+      Tags:
+        team: payments
+      Subscriptions:
+        - QueueName: attributes-queue.fifo
+          DeliveryPolicy: '{"healthyRetryPolicy": {}}'
+`)
+	_, err := LoadYamlConfig(filename, "Attributes")
+	assert.NoError(t, err)
+
+	queue := app.SyncQueues.Queues["attributes-queue.fifo"]
+	assert.True(t, queue.IsFIFO)
+	assert.True(t, queue.ContentBasedDeduplication)
+	assert.Equal(t, 5, queue.DelaySecs)
+	assert.Equal(t, 3600, queue.MessageRetentionPeriod)
+	assert.Equal(t, `{"Version": "2012-10-17"}`, queue.Policy)
+	assert.Equal(t, map[string]string{"team": "payments"}, queue.Tags)
+	assert.Equal(t, app.SyncQueues.Queues["attributes-dlq.fifo"], queue.DeadLetterQueue)
+	assert.Equal(t, 3, queue.MaxReceiveCount)
+
+	topic := app.SyncTopics.Topics["attributes-topic"]
+	assert.Equal(t, "Attributes", topic.DisplayName)
+	assert.Equal(t, `{"http": {}}`, topic.DeliveryPolicy)
+	assert.Equal(t, "2", topic.SignatureVersion)
+	assert.Equal(t, map[string]string{"team": "payments"}, topic.Tags)
+	assert.Equal(t, `{"healthyRetryPolicy": {}}`, topic.Subscriptions[0].DeliveryPolicy)
+}
+
+func TestConfig_Validation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "goaws.yaml")
+	writeConfig(t, filename, `
+Invalid:
+  Port: 4100
+  Queus:
+    - Name: typo
+  Queues:
+    - Name: invalid-queue1
+      VisibilityTimeout: soon
+    - Name: invalid-queue2
+      ContentBasedDeduplication: true
+      RedrivePolicy: '{"maxReceiveCount": 3, "deadLetterTargetArn": "arn:aws:sqs:local:queue:missing"}'
+  Topics:
+    - Name: invalid-topic
+      Subscriptions:
+        - Protocol: https
+          EndPoint: example.com
+Valid:
+  Queues:
+    - Name: valid-queue
+`)
+	_, err := LoadYamlConfig(filename, "Valid")
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, []string{
+			"line 4: Invalid.Queus: unknown field",
+			`line 8: Invalid.Queues[0].VisibilityTimeout: expected a whole number, got "soon"`,
+		}, err.(*ValidationError).Problems)
+	}
+
+	writeConfig(t, filename, `
+Invalid:
+  Queues:
+    - Name: invalid-queue2
+      ContentBasedDeduplication: true
+      RedrivePolicy: '{"maxReceiveCount": 3, "deadLetterTargetArn": "arn:aws:sqs:local:queue:missing"}'
+    - Name: invalid-queue2
+  Topics:
+    - Name: invalid-topic
+      SignatureVersion: "3"
+      Subscriptions:
+        - Protocol: https
+          EndPoint: example.com
+        - Protocol: lambda
+          EndPoint: orders-function
+  LambdaFunctions:
+    - Name: bad
+      EndPoint: localhost:3001
+Valid:
+  Queues:
+    - Name: valid-queue
+`)
+	_, err = LoadYamlConfig(filename, "Valid")
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, []string{
+			"line 18: Invalid.LambdaFunctions[0].EndPoint: must be an http:// or https:// URL",
+			"line 7: Invalid.Queues[1].Name: queue invalid-queue2 is defined more than once",
+			"line 5: Invalid.Queues[0].ContentBasedDeduplication: only FIFO queues support content based deduplication",
+			"line 6: Invalid.Queues[0].RedrivePolicy: dead letter queue missing is not defined",
+			`line 10: Invalid.Topics[0].SignatureVersion: must be 1 or 2, got "3"`,
+			"line 13: Invalid.Topics[0].Subscriptions[0].EndPoint: https subscriptions need a https:// EndPoint",
+			"line 15: Invalid.Topics[0].Subscriptions[1].EndPoint: lambda subscriptions need a function ARN EndPoint",
+		}, err.(*ValidationError).Problems)
+	}
+	// nothing from an invalid file is applied, even from its valid environments
+	assert.NotContains(t, app.SyncQueues.Queues, "valid-queue")
+
+	writeConfig(t, filename, "Valid:\n  Queues: [\n")
+	_, err = LoadYamlConfig(filename, "Valid")
+	assert.IsType(t, &ValidationError{}, err)
+	assert.Contains(t, err.Error(), "line 2: did not find expected node content")
+
+	_, err = LoadYamlConfig("./mock-data/mock-config.yaml", "Missing")
+	assert.EqualError(t, err, "environment Missing not found in config file ./mock-data/mock-config.yaml")
+}
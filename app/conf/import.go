@@ -0,0 +1,104 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/ghodss/yaml"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/cloudformation"
+)
+
+// ImportTemplates adds the queues and topics of CloudFormation templates to env,
+// resolving ARNs and URLs with its Region and AccountID. Every template is
+// validated like an environment of the config file, and can't define queues or
+// topics env already has.
+func ImportTemplates(env app.Environment, filenames []string, parameters map[string]string) (app.Environment, error) {
+	accountID := env.AccountID
+	if accountID == "" {
+		accountID = "queue" // the default applyConfig uses
+	}
+	// copy the lists so the parsed config file isn't changed
+	env.Queues = append([]app.EnvQueue{}, env.Queues...)
+	env.Topics = append([]app.EnvTopic{}, env.Topics...)
+
+	for _, filename := range filenames {
+		invalid := func(problems ...string) error {
+			return &ValidationError{Source: "CloudFormation template " + filename, Problems: problems}
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return env, err
+		}
+		imported, err := cloudformation.Import(data, cloudformation.Options{
+			Region:     env.Region,
+			AccountID:  accountID,
+			Parameters: parameters,
+		})
+		if err != nil {
+			return env, invalid(err.Error())
+		}
+
+		v := &validator{lines: map[string]int{}}
+		v.checkEnvironment("Resources", imported)
+		for i, queue := range imported.Queues {
+			if hasQueue(env.Queues, queue.Name) {
+				v.errorf(fmt.Sprintf("Resources.Queues[%d].Name", i), "queue %s is already defined", queue.Name)
+			}
+		}
+		for i, topic := range imported.Topics {
+			for _, existing := range env.Topics {
+				if existing.Name == topic.Name {
+					v.errorf(fmt.Sprintf("Resources.Topics[%d].Name", i), "topic %s is already defined", topic.Name)
+				}
+			}
+		}
+		if len(v.problems) > 0 {
+			return env, invalid(v.problems...)
+		}
+		env.Queues = append(env.Queues, imported.Queues...)
+		env.Topics = append(env.Topics, imported.Topics...)
+	}
+	return env, nil
+}
+
+// importTemplates imports the templates of the overrides into env.
+func (o Overrides) importTemplates(env app.Environment) (app.Environment, error) {
+	filenames := splitList(o.Import, ",")
+	if len(filenames) == 0 {
+		return env, nil
+	}
+	return ImportTemplates(env, filenames, ParseParameters(o.ImportParameters))
+}
+
+// MarshalEnvironment returns a config file with a single environment, leaving out
+// the settings that have their zero value.
+func MarshalEnvironment(name string, env app.Environment) ([]byte, error) {
+	return yaml.Marshal(map[string]interface{}{name: compact(reflect.ValueOf(env))})
+}
+
+// compact returns v as maps and lists without the zero values, so it marshals to
+// the YAML someone would write by hand.
+func compact(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Struct:
+		m := map[string]interface{}{}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			if field.IsZero() || ((field.Kind() == reflect.Slice || field.Kind() == reflect.Map) && field.Len() == 0) {
+				continue
+			}
+			m[v.Type().Field(i).Name] = compact(field)
+		}
+		return m
+	case reflect.Slice:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = compact(v.Index(i))
+		}
+		return list
+	}
+	return v.Interface()
+}
@@ -0,0 +1,112 @@
+package conf
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+const importTemplate = `
+Parameters:
+  Prefix:
+    Type: String
+    Default: import
+Resources:
+  Queue:
+    Type: AWS::SQS::Queue
+    Properties:
+      QueueName: !Sub "${Prefix}-queue"
+      VisibilityTimeout: 45
+      RedrivePolicy:
+        deadLetterTargetArn: !GetAtt DeadLetterQueue.Arn
+        maxReceiveCount: 2
+  DeadLetterQueue:
+    Type: AWS::SQS::Queue
+    Properties:
+      QueueName: !Sub "${Prefix}-dlq"
+  Topic:
+    Type: AWS::SNS::Topic
+    Properties:
+      TopicName: !Sub "${Prefix}-topic"
+      Subscription:
+        - Protocol: sqs
+          Endpoint: !GetAtt Queue.Arn
+`
+
+func TestConfig_ImportTemplates(t *testing.T) {
+	defer SetOverrides(Overrides{})
+	dir := t.TempDir()
+	template := filepath.Join(dir, "template.yaml")
+	writeConfig(t, template, importTemplate)
+	filename := filepath.Join(dir, "goaws.yaml")
+	writeConfig(t, filename, `
+Import:
+  Region: us-east-1
+  AccountId: "100010001000"
+  Queues:
+    - Name: config-queue
+`)
+
+	err := SetOverrides(Overrides{Import: template, ImportParameters: "Prefix=startup"})
+	assert.NoError(t, err)
+	_, err = LoadYamlConfig(filename, "Import")
+	assert.NoError(t, err)
+
+	assert.Contains(t, app.SyncQueues.Queues, "config-queue")
+	queue := app.SyncQueues.Queues["startup-queue"]
+	if assert.NotNil(t, queue) {
+		assert.Equal(t, 45, queue.TimeoutSecs)
+		assert.Equal(t, app.SyncQueues.Queues["startup-dlq"], queue.DeadLetterQueue)
+		assert.Equal(t, 2, queue.MaxReceiveCount)
+	}
+	topic := app.SyncTopics.Topics["startup-topic"]
+	if assert.NotNil(t, topic) && assert.Len(t, topic.Subscriptions, 1) {
+		assert.Equal(t, "arn:aws:sqs:us-east-1:100010001000:startup-queue", topic.Subscriptions[0].EndPoint)
+	}
+
+	// a template can't redefine what the config file defines
+	writeConfig(t, filename, `
+Import:
+  Queues:
+    - Name: startup-queue
+`)
+	_, err = LoadYamlConfig(filename, "Import")
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, []string{"Resources.Queues[1].Name: queue startup-queue is already defined"}, err.(*ValidationError).Problems)
+	}
+}
+
+func TestConfig_MarshalEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	template := filepath.Join(dir, "template.yaml")
+	writeConfig(t, template, importTemplate)
+
+	env, err := ImportTemplates(app.Environment{Region: "us-east-1", AccountID: "100010001000"}, []string{template}, nil)
+	assert.NoError(t, err)
+	data, err := MarshalEnvironment("Local", env)
+	assert.NoError(t, err)
+	assert.Equal(t, `Local:
+  AccountID: "100010001000"
+  Queues:
+  - Name: import-dlq
+  - Name: import-queue
+    RedrivePolicy: '{"deadLetterTargetArn":"arn:aws:sqs:us-east-1:100010001000:import-dlq","maxReceiveCount":2}'
+    VisibilityTimeout: 45
+  Region: us-east-1
+  Topics:
+  - Name: import-topic
+    Subscriptions:
+    - Protocol: sqs
+      QueueName: import-queue
+`, string(data))
+
+	// the output is a valid config file
+	filename := filepath.Join(dir, "goaws.yaml")
+	writeConfig(t, filename, string(data))
+	_, err = LoadYamlConfig(filename, "Local")
+	assert.NoError(t, err)
+	assert.Contains(t, app.SyncQueues.Queues, "import-queue")
+}
@@ -0,0 +1,295 @@
+package conf
+
+import (
+	"flag"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+// Overrides are settings given as command line flags or GOAWS_* environment
+// variables. Flags win over environment variables, which win over the config
+// file. Empty fields leave the config file's setting alone.
+type Overrides struct {
+	Host                          string
+	Port                          string
+	SqsPort                       string
+	SnsPort                       string
+	Region                        string
+	AccountID                     string
+	LogToFile                     string
+	LogFile                       string
+	VisibilityTimeout             string
+	ReceiveMessageWaitTimeSeconds string
+	MaximumMessageSize            string
+	TLS                           string
+	TLSCertFile                   string
+	TLSKeyFile                    string
+	TLSCAFile                     string
+	SnsSigningCertFile            string
+	SnsSigningKeyFile             string
+	ShutdownTimeout               string
+	SnapshotFile                  string
+	// Queues is a comma separated list of queues to create, e.g. "orders,orders-dlq,events.fifo".
+	Queues string
+	// Topics is a comma separated list of topics to create, each optionally followed by a colon
+	// and the queues to subscribe to it separated by plus signs, e.g. "orders:orders-queue+audit-queue,events".
+	Topics string
+	// LambdaFunctions maps the functions of lambda subscriptions to their Invoke API
+	// endpoints, e.g. "orders-handler=http://localhost:3001".
+	LambdaFunctions string
+	// Import is a comma separated list of CloudFormation templates whose queues and topics are created too.
+	Import string
+	// ImportParameters sets the parameters of the templates, e.g. "Environment=dev,Prefix=orders".
+	ImportParameters string
+}
+
+// EnvVarName returns the environment variable that sets the default of a flag,
+// e.g. GOAWS_ACCOUNT_ID for -account-id.
+func EnvVarName(flagName string) string {
+	return "GOAWS_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// BindOverrides defines a flag for every override, defaulting to its environment variable.
+func BindOverrides(fs *flag.FlagSet, o *Overrides, getenv func(string) string) {
+	bind := func(p *string, name string, usage string) {
+		fs.StringVar(p, name, getenv(EnvVarName(name)), usage+" (env "+EnvVarName(name)+")")
+	}
+	bind(&o.Host, "host", "hostname used in queue URLs")
+	bind(&o.Port, "port", "port to listen on for both SQS and SNS")
+	bind(&o.SqsPort, "sqs-port", "port to listen on for SQS, used with -sns-port instead of -port")
+	bind(&o.SnsPort, "sns-port", "port to listen on for SNS, used with -sqs-port instead of -port")
+	bind(&o.Region, "region", "region used in ARNs and queue URLs")
+	bind(&o.AccountID, "account-id", "account id used in ARNs and queue URLs")
+	bind(&o.LogToFile, "log-to-file", "log messages to a file (true/false)")
+	bind(&o.LogFile, "log-file", "file messages are logged to")
+	bind(&o.VisibilityTimeout, "visibility-timeout", "default queue visibility timeout in seconds")
+	bind(&o.ReceiveMessageWaitTimeSeconds, "receive-wait-time", "default queue receive message wait time in seconds")
+	bind(&o.MaximumMessageSize, "max-message-size", "default queue maximum message size in bytes")
+	bind(&o.TLS, "tls", "serve HTTPS (true/false)")
+	bind(&o.TLSCertFile, "tls-cert", "certificate to serve HTTPS with, a CA generates one if not set")
+	bind(&o.TLSKeyFile, "tls-key", "private key of -tls-cert")
+	bind(&o.TLSCAFile, "tls-ca-file", "file to write the generated CA certificate to")
+	bind(&o.SnsSigningCertFile, "sns-signing-cert", "certificate SNS messages are signed with, one is generated if not set")
+	bind(&o.SnsSigningKeyFile, "sns-signing-key", "RSA private key of -sns-signing-cert")
+	bind(&o.ShutdownTimeout, "shutdown-timeout", "seconds to wait for requests to finish when shutting down")
+	bind(&o.SnapshotFile, "snapshot-file", "file to write the queues, messages and topics to when shutting down")
+	bind(&o.Queues, "queues", "comma separated queues to create")
+	bind(&o.Topics, "topics", "comma separated topics to create, each optionally followed by :queue1+queue2 to subscribe queues")
+	bind(&o.LambdaFunctions, "lambda-functions", "comma separated Name=URL Invoke API endpoints of the functions of lambda subscriptions")
+	bind(&o.Import, "import", "comma separated CloudFormation templates to create the queues and topics of")
+	bind(&o.ImportParameters, "import-parameters", "comma separated Name=Value parameters of the CloudFormation templates")
+}
+
+// SetOverrides validates the overrides and applies them to every load and reload of the config.
+func SetOverrides(o Overrides) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	loaded.Lock()
+	defer loaded.Unlock()
+	loaded.overrides = o
+	return nil
+}
+
+func (o Overrides) validate() error {
+	v := &validator{lines: map[string]int{}}
+	v.checkPort("-port", o.Port)
+	v.checkPort("-sqs-port", o.SqsPort)
+	v.checkPort("-sns-port", o.SnsPort)
+	v.checkBoolOverride("-log-to-file", o.LogToFile)
+	v.checkBoolOverride("-tls", o.TLS)
+	if (o.TLSCertFile == "") != (o.TLSKeyFile == "") {
+		v.errorf("-tls-cert", "-tls-cert and -tls-key must be set together")
+	}
+	if (o.SnsSigningCertFile == "") != (o.SnsSigningKeyFile == "") {
+		v.errorf("-sns-signing-cert", "-sns-signing-cert and -sns-signing-key must be set together")
+	}
+	v.checkIntOverride("-visibility-timeout", o.VisibilityTimeout, 0, 43200)
+	v.checkIntOverride("-receive-wait-time", o.ReceiveMessageWaitTimeSeconds, 0, 20)
+	v.checkIntOverride("-max-message-size", o.MaximumMessageSize, 1, 262144)
+	v.checkIntOverride("-shutdown-timeout", o.ShutdownTimeout, 0, 3600)
+	for _, name := range splitList(o.Queues, ",") {
+		v.checkInlineName("-queues", name, 80)
+	}
+	for _, topic := range splitList(o.Topics, ",") {
+		name, queues := splitTopic(topic)
+		v.checkInlineName("-topics", name, 256)
+		for _, queue := range queues {
+			v.checkInlineName("-topics", queue, 80)
+		}
+	}
+	for _, function := range splitList(o.LambdaFunctions, ",") {
+		name, endpoint, _ := strings.Cut(function, "=")
+		if u, err := url.Parse(strings.TrimSpace(endpoint)); strings.TrimSpace(name) == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.errorf("-lambda-functions", "expected Name=http://host:port, got %q", function)
+		}
+	}
+	for _, parameter := range splitList(o.ImportParameters, ",") {
+		if name, _, ok := strings.Cut(parameter, "="); !ok || name == "" {
+			v.errorf("-import-parameters", "expected Name=Value, got %q", parameter)
+		}
+	}
+	if len(v.problems) > 0 {
+		return &ValidationError{Source: "command line flags and GOAWS_* environment variables", Problems: v.problems}
+	}
+	return nil
+}
+
+func (v *validator) checkInlineName(path string, name string, maxLength int) {
+	if name == "" {
+		v.errorf(path, "names can't be empty")
+	} else if len(name) > maxLength || !resourceNameRegexp.MatchString(strings.TrimSuffix(name, ".fifo")) {
+		v.errorf(path, "%q must be at most %d alphanumeric characters, hyphens and underscores", name, maxLength)
+	}
+}
+
+func (v *validator) checkBoolOverride(path string, value string) {
+	if value == "" {
+		return
+	}
+	if _, err := strconv.ParseBool(value); err != nil {
+		v.errorf(path, "expected true or false, got %q", value)
+	}
+}
+
+func (v *validator) checkIntOverride(path string, value string, min int, max int) {
+	if value == "" {
+		return
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		v.errorf(path, "expected a whole number, got %q", value)
+		return
+	}
+	v.checkRange(path, i, min, max)
+}
+
+// apply returns env with the overrides applied. Inline queues and topics are
+// added to the ones of the config file, and inline subscriptions to the
+// subscriptions of topics the file defines too.
+func (o Overrides) apply(env app.Environment) app.Environment {
+	set := func(p *string, value string) {
+		if value != "" {
+			*p = value
+		}
+	}
+	set(&env.Host, o.Host)
+	set(&env.Port, o.Port)
+	set(&env.SqsPort, o.SqsPort)
+	set(&env.SnsPort, o.SnsPort)
+	set(&env.Region, o.Region)
+	set(&env.AccountID, o.AccountID)
+	set(&env.LogFile, o.LogFile)
+	if o.LogToFile != "" {
+		env.LogToFile, _ = strconv.ParseBool(o.LogToFile)
+	}
+	if o.TLS != "" {
+		env.TLS.Enabled, _ = strconv.ParseBool(o.TLS)
+	}
+	set(&env.TLS.CertFile, o.TLSCertFile)
+	set(&env.TLS.KeyFile, o.TLSKeyFile)
+	set(&env.TLS.CAFile, o.TLSCAFile)
+	set(&env.SnsSigning.CertFile, o.SnsSigningCertFile)
+	set(&env.SnsSigning.KeyFile, o.SnsSigningKeyFile)
+	set(&env.SnapshotFile, o.SnapshotFile)
+	if o.ShutdownTimeout != "" {
+		env.ShutdownTimeout, _ = strconv.Atoi(o.ShutdownTimeout)
+	}
+	if o.VisibilityTimeout != "" {
+		env.QueueAttributeDefaults.VisibilityTimeout, _ = strconv.Atoi(o.VisibilityTimeout)
+	}
+	if o.ReceiveMessageWaitTimeSeconds != "" {
+		env.QueueAttributeDefaults.ReceiveMessageWaitTimeSeconds, _ = strconv.Atoi(o.ReceiveMessageWaitTimeSeconds)
+	}
+	if o.MaximumMessageSize != "" {
+		env.QueueAttributeDefaults.MaximumMessageSize, _ = strconv.Atoi(o.MaximumMessageSize)
+	}
+
+	// copy the lists so the parsed config file isn't changed
+	env.Queues = append([]app.EnvQueue{}, env.Queues...)
+	env.Topics = append([]app.EnvTopic{}, env.Topics...)
+	for _, name := range splitList(o.Queues, ",") {
+		if !hasQueue(env.Queues, name) {
+			env.Queues = append(env.Queues, app.EnvQueue{Name: name})
+		}
+	}
+	for _, topic := range splitList(o.Topics, ",") {
+		name, queues := splitTopic(topic)
+		i := 0
+		for i < len(env.Topics) && env.Topics[i].Name != name {
+			i++
+		}
+		if i == len(env.Topics) {
+			env.Topics = append(env.Topics, app.EnvTopic{Name: name})
+		}
+		subscriptions := append([]app.EnvSubsciption{}, env.Topics[i].Subscriptions...)
+		for _, queue := range queues {
+			if !hasQueueSubscription(subscriptions, queue) {
+				subscriptions = append(subscriptions, app.EnvSubsciption{Protocol: "sqs", QueueName: queue})
+			}
+		}
+		env.Topics[i].Subscriptions = subscriptions
+	}
+	env.LambdaFunctions = append([]app.LambdaFunction{}, env.LambdaFunctions...)
+	for _, function := range splitList(o.LambdaFunctions, ",") {
+		name, endpoint, _ := strings.Cut(function, "=")
+		name, endpoint = strings.TrimSpace(name), strings.TrimSpace(endpoint)
+		i := 0
+		for i < len(env.LambdaFunctions) && env.LambdaFunctions[i].Name != name {
+			i++
+		}
+		if i == len(env.LambdaFunctions) {
+			env.LambdaFunctions = append(env.LambdaFunctions, app.LambdaFunction{Name: name})
+		}
+		env.LambdaFunctions[i].EndPoint = endpoint
+	}
+	return env
+}
+
+func hasQueue(queues []app.EnvQueue, name string) bool {
+	for _, queue := range queues {
+		if queue.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasQueueSubscription(subscriptions []app.EnvSubsciption, queueName string) bool {
+	for _, sub := range subscriptions {
+		if sub.QueueName == queueName {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseParameters parses comma separated Name=Value pairs.
+func ParseParameters(list string) map[string]string {
+	parameters := map[string]string{}
+	for _, parameter := range splitList(list, ",") {
+		name, value, _ := strings.Cut(parameter, "=")
+		parameters[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return parameters
+}
+
+// splitTopic splits an inline topic into its name and the queues to subscribe to it.
+func splitTopic(topic string) (string, []string) {
+	name, queues, _ := strings.Cut(topic, ":")
+	return strings.TrimSpace(name), splitList(queues, "+")
+}
+
+// splitList splits a list, ignoring blanks around and between the items.
+func splitList(list string, separator string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, separator) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,155 @@
+package conf
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+func TestBindOverrides_FlagsWinOverEnvironment(t *testing.T) {
+	env := map[string]string{
+		"GOAWS_HOST":       "env-host",
+		"GOAWS_REGION":     "eu-west-1",
+		"GOAWS_ACCOUNT_ID": "100010001000",
+		"GOAWS_QUEUES":     "env-queue",
+	}
+	fs := flag.NewFlagSet("goaws", flag.ContinueOnError)
+	o := Overrides{}
+	BindOverrides(fs, &o, func(name string) string { return env[name] })
+
+	err := fs.Parse([]string{"-host", "flag-host", "-queues", "flag-queue1,flag-queue2", "Local"})
+	assert.NoError(t, err)
+	assert.Equal(t, "flag-host", o.Host)
+	assert.Equal(t, "eu-west-1", o.Region)
+	assert.Equal(t, "100010001000", o.AccountID)
+	assert.Equal(t, "flag-queue1,flag-queue2", o.Queues)
+	assert.Equal(t, []string{"Local"}, fs.Args())
+}
+
+func TestConfig_Overrides(t *testing.T) {
+	defer SetOverrides(Overrides{})
+	filename := filepath.Join(t.TempDir(), "goaws.yaml")
+	writeConfig(t, filename, `
+Overrides:
+  Host: file-host
+  Port: 4100
+  Region: us-east-1
+  AccountId: "100010001000"
+  QueueAttributeDefaults:
+    VisibilityTimeout: 10
+  Queues:
+    - Name: overrides-queue1
+  Topics:
+    - Name: overrides-topic1
+      Subscriptions:
+        - QueueName: overrides-queue1
+  LambdaFunctions:
+    - Name: overrides-function1
+      EndPoint: http://localhost:3001
+`)
+	err := SetOverrides(Overrides{
+		Host:              "override-host",
+		Port:              "4200",
+		AccountID:         "200020002000",
+		VisibilityTimeout: "20",
+		LogToFile:         "false",
+		Queues:            "overrides-queue1, overrides-queue2,overrides-queue3.fifo",
+		Topics:            "overrides-topic1:overrides-queue1+overrides-queue2,overrides-topic2:overrides-queue4",
+		LambdaFunctions:   "overrides-function1=http://localhost:3002, overrides-function2=http://localhost:3003",
+	})
+	assert.NoError(t, err)
+
+	ports, err := LoadYamlConfig(filename, "Overrides")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"4200"}, ports)
+	assert.Equal(t, "override-host", app.CurrentEnvironment.Host)
+	assert.Equal(t, "us-east-1", app.CurrentEnvironment.Region)
+	assert.Equal(t, "200020002000", app.CurrentEnvironment.AccountID)
+	assert.Equal(t, 20, app.SyncQueues.Queues["overrides-queue1"].TimeoutSecs)
+	assert.Equal(t, "http://us-east-1.override-host:4200/200020002000/overrides-queue2", app.SyncQueues.Queues["overrides-queue2"].URL)
+	assert.True(t, app.SyncQueues.Queues["overrides-queue3.fifo"].IsFIFO)
+	assert.Contains(t, app.SyncQueues.Queues, "overrides-queue4")
+
+	// the inline subscription to overrides-queue1 is the one from the file
+	subscriptions := app.SyncTopics.Topics["overrides-topic1"].Subscriptions
+	if assert.Len(t, subscriptions, 2) {
+		assert.Equal(t, app.SyncQueues.Queues["overrides-queue1"].Arn, subscriptions[0].EndPoint)
+		assert.Equal(t, app.SyncQueues.Queues["overrides-queue2"].Arn, subscriptions[1].EndPoint)
+	}
+	assert.Len(t, app.SyncTopics.Topics["overrides-topic2"].Subscriptions, 1)
+	assert.Equal(t, []app.LambdaFunction{
+		{Name: "overrides-function1", EndPoint: "http://localhost:3002"},
+		{Name: "overrides-function2", EndPoint: "http://localhost:3003"},
+	}, app.CurrentEnvironment.LambdaFunctions)
+	// the parsed config file is left alone
+	assert.Len(t, envs["Overrides"].Queues, 1)
+
+	// overrides still apply after a reload
+	assert.NoError(t, ReloadYamlConfig())
+	assert.Equal(t, "override-host", app.CurrentEnvironment.Host)
+	assert.Contains(t, app.SyncQueues.Queues, "overrides-queue3.fifo")
+}
+
+func TestConfig_Overrides_WithoutConfigFile(t *testing.T) {
+	defer SetOverrides(Overrides{})
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(t.TempDir())
+
+	err := SetOverrides(Overrides{SqsPort: "4300", SnsPort: "4301", Queues: "no-file-queue", Topics: "no-file-topic"})
+	assert.NoError(t, err)
+
+	ports, err := LoadYamlConfig("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"4300", "4301"}, ports)
+	assert.Equal(t, "localhost", app.CurrentEnvironment.Host)
+	assert.Contains(t, app.SyncQueues.Queues, "no-file-queue")
+	assert.Contains(t, app.SyncTopics.Topics, "no-file-topic")
+}
+
+func TestConfig_Overrides_PortWithoutConfigFile(t *testing.T) {
+	defer SetOverrides(Overrides{})
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(t.TempDir())
+
+	assert.NoError(t, SetOverrides(Overrides{Port: "5000"}))
+
+	ports, err := LoadYamlConfig("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"5000"}, ports)
+	assert.Equal(t, "http://localhost:5000/queue/q1", app.QueueURL("q1"))
+}
+
+func TestSetOverrides_Validation(t *testing.T) {
+	defer SetOverrides(Overrides{})
+	err := SetOverrides(Overrides{
+		Port:              "http",
+		LogToFile:         "yes please",
+		VisibilityTimeout: "50000",
+		TLSCertFile:       "goaws.pem",
+		Queues:            "good-queue,bad queue",
+		Topics:            "good-topic:bad/queue,:orphan-queue",
+		LambdaFunctions:   "good-function=http://localhost:3001,bad-function=localhost:3001",
+	})
+	if assert.IsType(t, &ValidationError{}, err) {
+		assert.Equal(t, []string{
+			`-port: "http" is not a valid port`,
+			`-log-to-file: expected true or false, got "yes please"`,
+			"-tls-cert: -tls-cert and -tls-key must be set together",
+			"-visibility-timeout: must be between 0 and 43200, got 50000",
+			`-queues: "bad queue" must be at most 80 alphanumeric characters, hyphens and underscores`,
+			`-topics: "bad/queue" must be at most 80 alphanumeric characters, hyphens and underscores`,
+			"-topics: names can't be empty",
+			`-lambda-functions: expected Name=http://host:port, got "bad-function=localhost:3001"`,
+		}, err.(*ValidationError).Problems)
+		assert.Contains(t, err.Error(), "invalid command line flags and GOAWS_* environment variables:")
+	}
+	// invalid overrides are not kept
+	assert.Equal(t, Overrides{}, loaded.overrides)
+}
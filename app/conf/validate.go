@@ -0,0 +1,367 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	yamlv3 "gopkg.in/yaml.v3"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/common"
+	"github.com/Admiral-Piett/goaws/app/fault"
+)
+
+var (
+	resourceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	maxTags            = 50
+)
+
+// ValidationError lists every problem found in a config file, or in the settings
+// overriding it.
+type ValidationError struct {
+	Source   string // e.g. "config file goaws.yaml"
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s:\n  %s", e.Source, strings.Join(e.Problems, "\n  "))
+}
+
+// validator collects the problems of a config file, along with the line each
+// setting is on so they can be pointed at.
+type validator struct {
+	problems []string
+	lines    map[string]int
+}
+
+// validateConfig checks the whole config file, every environment in it, before
+// anything is applied. It returns the parsed environments if the file is valid
+// and a *ValidationError listing every problem otherwise.
+func validateConfig(filename string, data []byte) (map[string]app.Environment, error) {
+	invalid := func(problems ...string) error {
+		return &ValidationError{Source: "config file " + filename, Problems: problems}
+	}
+
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(data, &doc); err != nil {
+		return nil, invalid(strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	environments := map[string]app.Environment{}
+	if len(doc.Content) == 0 {
+		return environments, nil
+	}
+
+	v := &validator{lines: map[string]int{}}
+	root := doc.Content[0]
+	if root.Kind != yamlv3.MappingNode {
+		return nil, invalid(fmt.Sprintf("line %d: expected a mapping of environment names to environments", root.Line))
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		v.checkSchema(root.Content[i+1], reflect.TypeOf(app.Environment{}), root.Content[i].Value)
+	}
+	if len(v.problems) > 0 {
+		return nil, invalid(v.problems...)
+	}
+
+	if err := yaml.Unmarshal(data, &environments); err != nil {
+		return nil, invalid(err.Error())
+	}
+	names := make([]string, 0, len(environments))
+	for name := range environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v.checkEnvironment(name, environments[name])
+	}
+	if len(v.problems) > 0 {
+		return nil, invalid(v.problems...)
+	}
+	return environments, nil
+}
+
+func (v *validator) errorf(path string, format string, args ...interface{}) {
+	message := path + ": " + fmt.Sprintf(format, args...)
+	if line, ok := v.lines[path]; ok {
+		message = fmt.Sprintf("line %d: %s", line, message)
+	}
+	v.problems = append(v.problems, message)
+}
+
+// checkSchema makes sure the node can be decoded into a value of type t, reporting
+// unknown fields and values of the wrong type. Field names are matched case
+// insensitively, the way they are decoded.
+func (v *validator) checkSchema(node *yamlv3.Node, t reflect.Type, path string) {
+	v.lines[path] = node.Line
+	if node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	if node.Tag == "!!null" {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yamlv3.MappingNode {
+			v.errorf(path, "expected a mapping")
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := t.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, key.Value) })
+			if !ok {
+				v.lines[path+"."+key.Value] = key.Line
+				v.errorf(path+"."+key.Value, "unknown field")
+				continue
+			}
+			v.checkSchema(value, field.Type, path+"."+field.Name)
+		}
+	case reflect.Slice:
+		if node.Kind != yamlv3.SequenceNode {
+			v.errorf(path, "expected a list")
+			return
+		}
+		for i, item := range node.Content {
+			v.checkSchema(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Map:
+		if node.Kind != yamlv3.MappingNode {
+			v.errorf(path, "expected a mapping")
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.checkSchema(node.Content[i+1], t.Elem(), path+"."+node.Content[i].Value)
+		}
+	default:
+		if node.Kind != yamlv3.ScalarNode {
+			v.errorf(path, "expected a single value")
+			return
+		}
+		switch t.Kind() {
+		case reflect.Bool:
+			if node.Tag != "!!bool" {
+				v.errorf(path, "expected true or false, got %q", node.Value)
+			}
+		case reflect.Int, reflect.Int64:
+			if node.Tag != "!!int" {
+				v.errorf(path, "expected a whole number, got %q", node.Value)
+			}
+		case reflect.Float64:
+			if node.Tag != "!!int" && node.Tag != "!!float" {
+				v.errorf(path, "expected a number, got %q", node.Value)
+			}
+		}
+	}
+}
+
+// checkEnvironment checks the values of an environment that decoded fine.
+func (v *validator) checkEnvironment(name string, env app.Environment) {
+	v.checkPort(name+".Port", env.Port)
+	v.checkPort(name+".SqsPort", env.SqsPort)
+	v.checkPort(name+".SnsPort", env.SnsPort)
+
+	defaults := name + ".QueueAttributeDefaults"
+	v.checkRange(defaults+".VisibilityTimeout", env.QueueAttributeDefaults.VisibilityTimeout, 0, 43200)
+	v.checkRange(defaults+".ReceiveMessageWaitTimeSeconds", env.QueueAttributeDefaults.ReceiveMessageWaitTimeSeconds, 0, 20)
+	v.checkOptionalRange(defaults+".MaximumMessageSize", env.QueueAttributeDefaults.MaximumMessageSize, 1, 262144)
+	v.checkProbability(defaults+".DuplicateProbability", env.QueueAttributeDefaults.DuplicateProbability)
+	v.checkProbability(defaults+".ReorderProbability", env.QueueAttributeDefaults.ReorderProbability)
+
+	if env.RandomLatency.Min < 0 || env.RandomLatency.Max < env.RandomLatency.Min {
+		v.errorf(name+".RandomLatency", "Min must be >= 0 and <= Max")
+	}
+	v.checkRange(name+".InFlightLimits.Standard", env.InFlightLimits.Standard, 0, app.DefaultStandardInFlightLimit)
+	v.checkRange(name+".InFlightLimits.FIFO", env.InFlightLimits.FIFO, 0, app.DefaultFIFOInFlightLimit)
+
+	v.checkRange(name+".ShutdownTimeout", env.ShutdownTimeout, 0, 3600)
+	if (env.TLS.CertFile == "") != (env.TLS.KeyFile == "") {
+		v.errorf(name+".TLS", "CertFile and KeyFile must be set together")
+	}
+	if (env.SnsSigning.CertFile == "") != (env.SnsSigning.KeyFile == "") {
+		v.errorf(name+".SnsSigning", "CertFile and KeyFile must be set together")
+	}
+
+	for i, function := range env.LambdaFunctions {
+		path := fmt.Sprintf("%s.LambdaFunctions[%d]", name, i)
+		if function.Name == "" {
+			v.errorf(path, "Name is required")
+		}
+		if u, err := url.Parse(function.EndPoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.errorf(path+".EndPoint", "must be an http:// or https:// URL")
+		}
+	}
+
+	for i, rule := range env.Faults {
+		if err := fault.Validate(rule); err != nil {
+			v.errorf(fmt.Sprintf("%s.Faults[%d]", name, i), "%s", err)
+		}
+	}
+
+	// queues can be defined in the queue list and by sqs subscriptions
+	queues := map[string]bool{}
+	for i, queue := range env.Queues {
+		path := fmt.Sprintf("%s.Queues[%d]", name, i)
+		if queues[queue.Name] {
+			v.errorf(path+".Name", "queue %s is defined more than once", queue.Name)
+		}
+		queues[queue.Name] = true
+	}
+	for _, topic := range env.Topics {
+		for _, sub := range topic.Subscriptions {
+			if sub.QueueName != "" {
+				queues[sub.QueueName] = true
+			}
+		}
+	}
+	for i, queue := range env.Queues {
+		v.checkQueue(fmt.Sprintf("%s.Queues[%d]", name, i), queue, queues)
+	}
+
+	topics := map[string]bool{}
+	for i, topic := range env.Topics {
+		path := fmt.Sprintf("%s.Topics[%d]", name, i)
+		if topics[topic.Name] {
+			v.errorf(path+".Name", "topic %s is defined more than once", topic.Name)
+		}
+		topics[topic.Name] = true
+		v.checkTopic(path, topic)
+	}
+}
+
+func (v *validator) checkQueue(path string, queue app.EnvQueue, queues map[string]bool) {
+	if !v.checkName(path, queue.Name, 80) {
+		return
+	}
+	isFIFO := app.HasFIFOQueueName(queue.Name)
+	if queue.FifoQueue && !isFIFO {
+		v.errorf(path+".FifoQueue", "the name of a FIFO queue must end with .fifo")
+	}
+	if queue.ContentBasedDeduplication && !isFIFO {
+		v.errorf(path+".ContentBasedDeduplication", "only FIFO queues support content based deduplication")
+	}
+	v.checkRange(path+".VisibilityTimeout", queue.VisibilityTimeout, 0, 43200)
+	v.checkRange(path+".ReceiveMessageWaitTimeSeconds", queue.ReceiveMessageWaitTimeSeconds, 0, 20)
+	v.checkRange(path+".DelaySeconds", queue.DelaySeconds, 0, 900)
+	v.checkOptionalRange(path+".MaximumMessageSize", queue.MaximumMessageSize, 1, 262144)
+	v.checkOptionalRange(path+".MessageRetentionPeriod", queue.MessageRetentionPeriod, 60, 1209600)
+	v.checkOptionalRange(path+".KmsDataKeyReusePeriodSeconds", queue.KmsDataKeyReusePeriodSeconds, 60, 86400)
+	if queue.SqsManagedSseEnabled && queue.KmsMasterKeyId != "" {
+		v.errorf(path+".SqsManagedSseEnabled", "a queue can't use both SQS managed encryption and a KmsMasterKeyId")
+	}
+	v.checkProbability(path+".DuplicateProbability", queue.DuplicateProbability)
+	v.checkProbability(path+".ReorderProbability", queue.ReorderProbability)
+	v.checkPolicy(path+".Policy", queue.Policy)
+	v.checkTags(path+".Tags", queue.Tags)
+
+	if queue.RedrivePolicy != "" {
+		_, deadLetterQueueName, err := parseRedrivePolicy(queue.RedrivePolicy)
+		if err != nil {
+			v.errorf(path+".RedrivePolicy", "%s", err)
+		} else if !queues[deadLetterQueueName] {
+			v.errorf(path+".RedrivePolicy", "dead letter queue %s is not defined", deadLetterQueueName)
+		} else if app.HasFIFOQueueName(deadLetterQueueName) != isFIFO {
+			v.errorf(path+".RedrivePolicy", "the dead letter queue of a FIFO queue must be a FIFO queue, and vice versa")
+		}
+	}
+}
+
+func (v *validator) checkTopic(path string, topic app.EnvTopic) {
+	v.checkName(path, topic.Name, 256)
+	v.checkPolicy(path+".Policy", topic.Policy)
+	v.checkPolicy(path+".DeliveryPolicy", topic.DeliveryPolicy)
+	if topic.SignatureVersion != "" && topic.SignatureVersion != "1" && topic.SignatureVersion != "2" {
+		v.errorf(path+".SignatureVersion", "must be 1 or 2, got %q", topic.SignatureVersion)
+	}
+	v.checkTags(path+".Tags", topic.Tags)
+
+	for i, sub := range topic.Subscriptions {
+		subPath := fmt.Sprintf("%s.Subscriptions[%d]", path, i)
+		switch sub.Protocol {
+		case "", "sqs":
+			if sub.QueueName == "" {
+				v.errorf(subPath, "sqs subscriptions need a QueueName")
+			}
+		case "http", "https":
+			if u, err := url.Parse(sub.EndPoint); err != nil || u.Scheme != sub.Protocol || u.Host == "" {
+				v.errorf(subPath+".EndPoint", "%s subscriptions need a %s:// EndPoint", sub.Protocol, sub.Protocol)
+			}
+		case "email", "email-json", "sms", "application":
+			if sub.EndPoint == "" {
+				v.errorf(subPath+".EndPoint", "%s subscriptions need an EndPoint", sub.Protocol)
+			}
+		case "lambda":
+			// the function may be in LambdaFunctions of the overrides rather than of this file
+			if app.LambdaFunctionName(sub.EndPoint) == sub.EndPoint {
+				v.errorf(subPath+".EndPoint", "lambda subscriptions need a function ARN EndPoint")
+			}
+		default:
+			v.errorf(subPath+".Protocol", "unsupported protocol %q", sub.Protocol)
+		}
+		if sub.FilterPolicy != "" {
+			if err := json.Unmarshal([]byte(sub.FilterPolicy), &app.FilterPolicy{}); err != nil || !common.IsJSONObject(sub.FilterPolicy) {
+				v.errorf(subPath+".FilterPolicy", "must be a JSON object of attribute names to lists of values")
+			}
+		}
+		v.checkPolicy(subPath+".DeliveryPolicy", sub.DeliveryPolicy)
+	}
+}
+
+// checkName makes sure a queue or topic name is valid, returning false if it isn't.
+func (v *validator) checkName(path string, name string, maxLength int) bool {
+	if name == "" {
+		v.errorf(path, "Name is required")
+		return false
+	}
+	if len(name) > maxLength || !resourceNameRegexp.MatchString(strings.TrimSuffix(name, ".fifo")) {
+		v.errorf(path+".Name", "%q must be at most %d alphanumeric characters, hyphens and underscores", name, maxLength)
+		return false
+	}
+	return true
+}
+
+func (v *validator) checkPort(path string, port string) {
+	if port == "" {
+		return
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		v.errorf(path, "%q is not a valid port", port)
+	}
+}
+
+func (v *validator) checkRange(path string, value int, min int, max int) {
+	if value < min || value > max {
+		v.errorf(path, "must be between %d and %d, got %d", min, max, value)
+	}
+}
+
+// checkOptionalRange is checkRange for settings where 0 means the default.
+func (v *validator) checkOptionalRange(path string, value int, min int, max int) {
+	if value != 0 {
+		v.checkRange(path, value, min, max)
+	}
+}
+
+func (v *validator) checkProbability(path string, value float64) {
+	if value < 0 || value > 1 {
+		v.errorf(path, "must be between 0 and 1, got %v", value)
+	}
+}
+
+func (v *validator) checkPolicy(path string, policy string) {
+	if policy != "" && !common.IsJSONObject(policy) {
+		v.errorf(path, "must be a JSON object")
+	}
+}
+
+func (v *validator) checkTags(path string, tags map[string]string) {
+	if len(tags) > maxTags {
+		v.errorf(path, "at most %d tags are allowed, got %d", maxTags, len(tags))
+	}
+}
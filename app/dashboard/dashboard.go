@@ -0,0 +1,17 @@
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the web dashboard. It is a static page that polls the
+// /_goaws admin API and sends SQS and SNS actions to the regular endpoint.
+func Handler(prefix string) http.Handler {
+	files, _ := fs.Sub(static, "static")
+	return http.StripPrefix(prefix, http.FileServer(http.FS(files)))
+}
@@ -0,0 +1,219 @@
+"use strict";
+
+const refreshInterval = 2000;
+let selectedQueue = null;
+
+function el(tag, text, className) {
+  const e = document.createElement(tag);
+  if (text !== undefined && text !== null) {
+    e.textContent = text;
+  }
+  if (className) {
+    e.className = className;
+  }
+  return e;
+}
+
+function button(text, onClick) {
+  const b = el("button", text);
+  b.type = "button";
+  b.addEventListener("click", onClick);
+  return b;
+}
+
+function setStatus(text, isError) {
+  const status = document.getElementById("status");
+  status.textContent = text;
+  status.className = isError ? "error" : "";
+}
+
+async function getJSON(path) {
+  const resp = await fetch(path);
+  if (!resp.ok) {
+    throw new Error(path + ": " + resp.status);
+  }
+  return resp.json();
+}
+
+// action sends an SQS or SNS query API request to the regular endpoint.
+async function action(params) {
+  const resp = await fetch("/", {method: "POST", body: new URLSearchParams(params)});
+  if (!resp.ok) {
+    throw new Error(params.Action + " failed: " + (await resp.text()));
+  }
+}
+
+function renderQueues(queues) {
+  const tbody = document.querySelector("#queues tbody");
+  tbody.replaceChildren();
+  for (const q of queues) {
+    const tr = el("tr");
+    if (q.Name === selectedQueue) {
+      tr.className = "selected";
+    }
+    tr.append(el("td", q.Name), el("td", q.Visible), el("td", q.InFlight), el("td", q.Delayed));
+    const dlq = q.DeadLetterTargetArn ?
+      q.DeadLetterTargetArn.split(":").pop() + " (after " + q.MaxReceiveCount + " receives)" : "";
+    tr.append(el("td", dlq));
+
+    const actions = el("td");
+    actions.append(
+      button("Browse", () => selectQueue(q.Name)),
+      button("Purge", () => purgeQueue(q)),
+    );
+    tr.append(actions);
+    tbody.append(tr);
+  }
+}
+
+function renderMessages(messages) {
+  const tbody = document.querySelector("#messages tbody");
+  tbody.replaceChildren();
+  for (const m of messages) {
+    const tr = el("tr");
+    const attributes = Object.entries(m.MessageAttributes || {})
+      .map(([name, a]) => name + "=" + (a.StringValue || a.BinaryValue))
+      .join(", ");
+    tr.append(
+      el("td", m.MessageId),
+      el("td", m.State, "state-" + m.State),
+      el("td", m.ReceiveCount),
+      el("td", new Date(m.SentTimestamp).toLocaleString()),
+      el("td", m.Body, "body"),
+      el("td", attributes),
+    );
+    const actions = el("td");
+    actions.append(button("Delete", () => deleteMessage(m.MessageId)));
+    tr.append(actions);
+    tbody.append(tr);
+  }
+}
+
+function renderTopics(topics) {
+  const container = document.getElementById("topics");
+  container.replaceChildren();
+  for (const t of topics) {
+    const div = el("div", null, "topic");
+    div.append(el("h3", t.Name));
+
+    const form = el("form");
+    const message = el("textarea");
+    message.placeholder = "Message";
+    message.required = true;
+    const subject = el("input");
+    subject.placeholder = "Subject";
+    const publish = el("button", "Publish");
+    publish.type = "submit";
+    form.append(message, subject, publish);
+    form.addEventListener("submit", (e) => {
+      e.preventDefault();
+      run(async () => {
+        const params = {Action: "Publish", TopicArn: t.Arn, Message: message.value};
+        if (subject.value) {
+          params.Subject = subject.value;
+        }
+        await action(params);
+        message.value = "";
+        setStatus("Published to " + t.Name);
+      });
+    });
+    div.append(form);
+
+    const table = el("table");
+    const head = el("tr");
+    head.append(el("th", "Protocol"), el("th", "Endpoint"), el("th", "Raw"), el("th", "Filter policy"));
+    table.append(head);
+    for (const s of t.Subscriptions) {
+      const tr = el("tr");
+      tr.append(el("td", s.Protocol), el("td", s.Endpoint), el("td", s.RawMessageDelivery ? "yes" : "no"),
+        el("td", s.FilterPolicy || "", "body"));
+      table.append(tr);
+    }
+    div.append(table);
+    container.append(div);
+  }
+}
+
+async function refresh() {
+  const queues = await getJSON("/_goaws/queues");
+  renderQueues(queues.Queues);
+  if (selectedQueue !== null) {
+    if (queues.Queues.some((q) => q.Name === selectedQueue)) {
+      const messages = await getJSON("/_goaws/queues/" + encodeURIComponent(selectedQueue) + "/messages");
+      renderMessages(messages.Messages);
+    } else {
+      selectQueue(null);
+    }
+  }
+  // Topics hold a form each, so don't re-render them while somebody is typing
+  if (!document.getElementById("topics").contains(document.activeElement)) {
+    const topics = await getJSON("/_goaws/topics");
+    renderTopics(topics.Topics);
+  }
+}
+
+async function run(fn) {
+  try {
+    await fn();
+    await refresh();
+  } catch (e) {
+    setStatus(e.message, true);
+  }
+}
+
+function selectQueue(name) {
+  selectedQueue = name;
+  document.getElementById("queue").hidden = name === null;
+  document.getElementById("queue-name").textContent = name || "";
+  run(async () => {});
+}
+
+function purgeQueue(q) {
+  if (!confirm("Purge all messages from " + q.Name + "?")) {
+    return;
+  }
+  run(async () => {
+    await action({Action: "PurgeQueue", QueueUrl: q.Url});
+    setStatus("Purged " + q.Name);
+  });
+}
+
+function deleteMessage(messageId) {
+  run(async () => {
+    const path = "/_goaws/queues/" + encodeURIComponent(selectedQueue) + "/messages/" + encodeURIComponent(messageId);
+    const resp = await fetch(path, {method: "DELETE"});
+    if (!resp.ok) {
+      throw new Error("delete failed: " + resp.status);
+    }
+  });
+}
+
+document.getElementById("send").addEventListener("submit", (e) => {
+  e.preventDefault();
+  const form = e.target;
+  run(async () => {
+    const request = {
+      Body: form.Body.value,
+      DelaySeconds: parseInt(form.DelaySeconds.value, 10) || 0,
+      MessageGroupId: form.MessageGroupId.value,
+    };
+    const resp = await fetch("/_goaws/queues/" + encodeURIComponent(selectedQueue) + "/messages", {
+      method: "POST",
+      headers: {"Content-Type": "application/json"},
+      body: JSON.stringify(request),
+    });
+    if (!resp.ok) {
+      throw new Error("send failed: " + (await resp.text()));
+    }
+    form.Body.value = "";
+    setStatus("Sent to " + selectedQueue);
+  });
+});
+
+setInterval(() => {
+  if (document.getElementById("live").checked) {
+    refresh().catch((e) => setStatus(e.message, true));
+  }
+}, refreshInterval);
+
+run(async () => {});
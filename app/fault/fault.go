@@ -0,0 +1,248 @@
+package fault
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/common"
+)
+
+// Kinds of faults a rule can inject.
+const (
+	Throttle  = "throttle"
+	Error     = "error"
+	Reset     = "reset"
+	Latency   = "latency"
+	Duplicate = "duplicate"
+)
+
+// Services requests are routed to. They decide the format of injected errors.
+const (
+	ServiceSQS = "sqs"
+	ServiceSNS = "sns"
+	ServiceKMS = "kms"
+)
+
+// replayable are the actions a duplicate fault replays. Replaying anything else
+// would either lose its response, like the messages of a ReceiveMessage, or fail
+// because the request body has already been read, like KMS operations.
+var replayable = map[string]bool{
+	"SendMessage":      true,
+	"SendMessageBatch": true,
+	"Publish":          true,
+	"PublishBatch":     true,
+}
+
+// Outcome is what the faults matching a request want done with it.
+type Outcome struct {
+	Latency   time.Duration
+	Fault     string // Throttle, Error or Reset, or empty to handle the request normally
+	Code      string
+	Duplicate bool
+}
+
+var rules = struct {
+	sync.Mutex
+	rules []app.FaultRule
+}{}
+
+// SetRules replaces all the rules, e.g. with the ones from the config file.
+func SetRules(newRules []app.FaultRule) error {
+	validated := make([]app.FaultRule, 0, len(newRules))
+	for _, rule := range newRules {
+		rule, err := validate(rule)
+		if err != nil {
+			return err
+		}
+		validated = append(validated, rule)
+	}
+	rules.Lock()
+	defer rules.Unlock()
+	rules.rules = validated
+	return nil
+}
+
+// AddRule validates a rule, gives it an id if it has none and adds it after the existing rules.
+func AddRule(rule app.FaultRule) (app.FaultRule, error) {
+	rule, err := validate(rule)
+	if err != nil {
+		return rule, err
+	}
+	rules.Lock()
+	defer rules.Unlock()
+	rules.rules = append(rules.rules, rule)
+	return rule, nil
+}
+
+// DeleteRule removes the rule with the given id, returning false if there is none.
+func DeleteRule(id string) bool {
+	rules.Lock()
+	defer rules.Unlock()
+	for i, rule := range rules.rules {
+		if rule.Id == id {
+			rules.rules = append(rules.rules[:i], rules.rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns an error if the rule can't be used.
+func Validate(rule app.FaultRule) error {
+	_, err := validate(rule)
+	return err
+}
+
+// Rules returns the current rules. Count is the number of requests a rule has left to affect.
+func Rules() []app.FaultRule {
+	rules.Lock()
+	defer rules.Unlock()
+	return append([]app.FaultRule{}, rules.rules...)
+}
+
+// Decide works out which faults apply to a request. Latency faults add up, the
+// first matching throttle, error or reset fault wins.
+func Decide(action string, resource string) Outcome {
+	rules.Lock()
+	defer rules.Unlock()
+
+	outcome := Outcome{}
+	for i := 0; i < len(rules.rules); i++ {
+		rule := &rules.rules[i]
+		if !matches(rule.Action, action) || !matches(rule.Resource, resource) {
+			continue
+		}
+		if outcome.Fault != "" && (rule.Fault == Throttle || rule.Fault == Error || rule.Fault == Reset) {
+			continue
+		}
+		if rule.Fault == Duplicate && !replayable[action] {
+			continue
+		}
+		if app.RandomFloat64() >= *rule.Probability {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"rule":     rule.Id,
+			"fault":    rule.Fault,
+			"action":   action,
+			"resource": resource,
+		}).Info("Injecting fault")
+		switch rule.Fault {
+		case Latency:
+			outcome.Latency += time.Duration(rule.LatencyMs) * time.Millisecond
+		case Duplicate:
+			outcome.Duplicate = true
+		default:
+			outcome.Fault = rule.Fault
+			outcome.Code = rule.Code
+		}
+
+		if rule.Count > 0 {
+			rule.Count--
+			if rule.Count == 0 {
+				rules.rules = append(rules.rules[:i], rules.rules[i+1:]...)
+				i--
+			}
+		}
+	}
+	return outcome
+}
+
+// WriteError responds to a request with the error of a throttle or error fault,
+// in the format of the service it was sent to.
+func WriteError(w http.ResponseWriter, service string, outcome Outcome) {
+	status, code, message := http.StatusBadRequest, "", "Rate exceeded"
+	if outcome.Fault == Throttle {
+		switch service {
+		case ServiceKMS:
+			code = "ThrottlingException"
+		case ServiceSNS:
+			code = "Throttling"
+		default:
+			code = "RequestThrottled"
+		}
+	} else {
+		status, message = http.StatusInternalServerError, "We encountered an internal error. Please try again."
+		if service == ServiceKMS {
+			code = "KMSInternalException"
+		} else {
+			code = "InternalError"
+		}
+	}
+	if outcome.Code != "" {
+		code = outcome.Code
+	}
+
+	if service == ServiceKMS {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(app.KmsErrorResponse{Type: code, Message: message}); err != nil {
+			log.Printf("error: %v\n", err)
+		}
+		return
+	}
+
+	requestId, _ := common.NewUUID()
+	respStruct := app.ErrorResponse{
+		Result:    app.ErrorResult{Type: "Sender", Code: code, Message: message},
+		RequestId: requestId,
+	}
+	if status >= 500 {
+		respStruct.Result.Type = "Receiver"
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	enc := xml.NewEncoder(w)
+	enc.Indent("  ", "    ")
+	if err := enc.Encode(respStruct); err != nil {
+		log.Printf("error: %v\n", err)
+	}
+}
+
+func validate(rule app.FaultRule) (app.FaultRule, error) {
+	switch rule.Fault {
+	case Throttle, Error, Reset, Duplicate:
+	case Latency:
+		if rule.LatencyMs <= 0 {
+			return rule, errors.New("latency faults need a LatencyMs greater than 0")
+		}
+	default:
+		return rule, fmt.Errorf("unknown fault %q, must be one of throttle, error, reset, latency or duplicate", rule.Fault)
+	}
+	for _, pattern := range []string{rule.Action, rule.Resource} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return rule, fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	if rule.Probability == nil {
+		probability := 1.0
+		rule.Probability = &probability
+	} else if *rule.Probability < 0 || *rule.Probability > 1 {
+		return rule, errors.New("Probability must be between 0 and 1")
+	}
+	if rule.Count < 0 {
+		return rule, errors.New("Count must be >= 0")
+	}
+	if rule.Id == "" {
+		rule.Id, _ = common.NewUUID()
+	}
+	return rule, nil
+}
+
+func matches(pattern string, value string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
@@ -0,0 +1,101 @@
+package fault
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+func TestDecide(t *testing.T) {
+	defer SetRules(nil)
+	err := SetRules([]app.FaultRule{
+		{Action: "Send*", Resource: "orders-*", Fault: Throttle, Count: 2},
+		{Action: "ReceiveMessage", Fault: Latency, LatencyMs: 50},
+		{Fault: Latency, LatencyMs: 10},
+		{Action: "Publish", Fault: Duplicate},
+		{Action: "DeleteMessage", Fault: Error, Probability: probability(0.000001)},
+		{Action: "ChangeMessageVisibility", Fault: Error, Probability: probability(0)},
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, Outcome{Fault: Throttle, Latency: 10 * time.Millisecond}, Decide("SendMessage", "orders-1"))
+	assert.Equal(t, Outcome{Latency: 10 * time.Millisecond}, Decide("SendMessage", "payments"))
+	assert.Equal(t, Outcome{Latency: 60 * time.Millisecond}, Decide("ReceiveMessage", "orders-1"))
+	assert.Equal(t, Outcome{Latency: 10 * time.Millisecond, Duplicate: true}, Decide("Publish", "topic"))
+	assert.Equal(t, Outcome{Latency: 10 * time.Millisecond}, Decide("DeleteMessage", "orders-1"))
+	// an explicit Probability of 0 never fires
+	assert.Equal(t, Outcome{Latency: 10 * time.Millisecond}, Decide("ChangeMessageVisibility", "orders-1"))
+
+	// the throttle rule only applies to the next 2 calls
+	assert.Equal(t, Throttle, Decide("SendMessageBatch", "orders-2").Fault)
+	assert.Equal(t, "", Decide("SendMessage", "orders-1").Fault)
+	assert.Len(t, Rules(), 5)
+}
+
+func TestDecide_DuplicatesOnlySendsAndPublishes(t *testing.T) {
+	defer SetRules(nil)
+	assert.Nil(t, SetRules([]app.FaultRule{{Fault: Duplicate, Count: 4}}))
+
+	for _, action := range []string{"ReceiveMessage", "DeleteMessage", "Encrypt", "Subscribe"} {
+		assert.False(t, Decide(action, "").Duplicate, action)
+	}
+	for _, action := range []string{"SendMessage", "SendMessageBatch", "Publish", "PublishBatch"} {
+		assert.True(t, Decide(action, "").Duplicate, action)
+	}
+	// the other actions didn't use up the rule's count
+	assert.Empty(t, Rules())
+}
+
+func probability(p float64) *float64 {
+	return &p
+}
+
+func TestAddAndDeleteRule(t *testing.T) {
+	defer SetRules(nil)
+
+	rule, err := AddRule(app.FaultRule{Action: "SendMessage", Fault: Reset})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, rule.Id)
+	assert.Equal(t, 1.0, *rule.Probability)
+	assert.Equal(t, Reset, Decide("SendMessage", "").Fault)
+
+	assert.True(t, DeleteRule(rule.Id))
+	assert.False(t, DeleteRule(rule.Id))
+	assert.Equal(t, "", Decide("SendMessage", "").Fault)
+
+	for _, invalid := range []app.FaultRule{
+		{Fault: "explode"},
+		{Fault: Latency},
+		{Fault: Throttle, Probability: probability(2)},
+		{Fault: Throttle, Probability: probability(-0.5)},
+		{Fault: Throttle, Count: -1},
+		{Fault: Throttle, Action: "[Send"},
+	} {
+		_, err := AddRule(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+	assert.NotNil(t, SetRules([]app.FaultRule{{Fault: Error}, {Fault: "explode"}}))
+}
+
+func TestWriteError(t *testing.T) {
+	rr := httptest.NewRecorder()
+	WriteError(rr, ServiceSQS, Outcome{Fault: Throttle})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.True(t, strings.Contains(rr.Body.String(), "<Code>RequestThrottled</Code>"))
+
+	rr = httptest.NewRecorder()
+	WriteError(rr, ServiceSNS, Outcome{Fault: Error, Code: "ServiceUnavailable"})
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.True(t, strings.Contains(rr.Body.String(), "<Code>ServiceUnavailable</Code>"))
+
+	rr = httptest.NewRecorder()
+	WriteError(rr, ServiceKMS, Outcome{Fault: Throttle})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, `{"__type":"ThrottlingException","message":"Rate exceeded"}`+"\n", rr.Body.String())
+}
@@ -0,0 +1,507 @@
+package goadmin
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/certs"
+	"github.com/Admiral-Piett/goaws/app/common"
+	"github.com/Admiral-Piett/goaws/app/fault"
+	sns "github.com/Admiral-Piett/goaws/app/gosns"
+)
+
+// Message states reported when peeking at a queue.
+const (
+	MessageStateVisible  = "visible"
+	MessageStateInFlight = "inflight"
+	MessageStateDelayed  = "delayed"
+)
+
+// ListQueues returns every queue with the number of messages in each state.
+func ListQueues(w http.ResponseWriter, req *http.Request) {
+	respStruct := app.AdminListQueuesResponse{Queues: []app.AdminQueue{}}
+
+	app.SyncQueues.RLock()
+	for _, q := range app.SyncQueues.Queues {
+		respStruct.Queues = append(respStruct.Queues, queueStats(q))
+	}
+	app.SyncQueues.RUnlock()
+
+	sort.Slice(respStruct.Queues, func(i, j int) bool {
+		return respStruct.Queues[i].Name < respStruct.Queues[j].Name
+	})
+	sendResponseBack(w, http.StatusOK, respStruct)
+}
+
+// ListMessages returns the messages of a queue without receiving them, so
+// neither their visibility nor their receive count changes.
+func ListMessages(w http.ResponseWriter, req *http.Request) {
+	queueName := mux.Vars(req)["queueName"]
+	respStruct := app.AdminListMessagesResponse{Messages: []app.AdminMessage{}}
+
+	app.SyncQueues.RLock()
+	q, ok := app.SyncQueues.Queues[queueName]
+	if !ok {
+		app.SyncQueues.RUnlock()
+		createErrorResponse(w, http.StatusNotFound, "queue not found: "+queueName)
+		return
+	}
+	for i := range q.Messages {
+		respStruct.Messages = append(respStruct.Messages, adminMessage(&q.Messages[i]))
+	}
+	app.SyncQueues.RUnlock()
+
+	sendResponseBack(w, http.StatusOK, respStruct)
+}
+
+// SendMessage puts a message straight onto a queue. Unlike the SQS action it
+// lets the caller pick the sent timestamp, e.g. to test retention or ordering.
+func SendMessage(w http.ResponseWriter, req *http.Request) {
+	queueName := mux.Vars(req)["queueName"]
+	request := app.AdminSendMessageRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil && err != io.EOF {
+		createErrorResponse(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if request.DelaySeconds < 0 {
+		createErrorResponse(w, http.StatusBadRequest, "DelaySeconds must be >= 0")
+		return
+	}
+
+	msg := app.Message{MessageBody: []byte(request.Body)}
+	msg.MD5OfMessageBody = common.GetMD5Hash(request.Body)
+	if len(request.MessageAttributes) > 0 {
+		msg.MessageAttributes = make(map[string]app.MessageAttributeValue)
+		for name, attr := range request.MessageAttributes {
+			if attr.BinaryValue != "" {
+				msg.MessageAttributes[name] = app.MessageAttributeValue{Name: name, DataType: attr.DataType, Value: attr.BinaryValue, ValueKey: "BinaryValue"}
+			} else {
+				msg.MessageAttributes[name] = app.MessageAttributeValue{Name: name, DataType: attr.DataType, Value: attr.StringValue, ValueKey: "StringValue"}
+			}
+		}
+		msg.MD5OfMessageAttributes = common.HashAttributes(msg.MessageAttributes)
+	}
+	msg.Uuid, _ = common.NewUUID()
+	msg.GroupID = request.MessageGroupId
+	msg.DeduplicationID = request.MessageDeduplicationId
+	msg.SentTime = app.Now()
+	if request.SentTimestamp > 0 {
+		msg.SentTime = time.Unix(0, request.SentTimestamp*int64(time.Millisecond))
+	}
+	msg.DelaySecs = request.DelaySeconds
+
+	app.SyncQueues.Lock()
+	q, ok := app.SyncQueues.Queues[queueName]
+	if !ok {
+		app.SyncQueues.Unlock()
+		createErrorResponse(w, http.StatusNotFound, "queue not found: "+queueName)
+		return
+	}
+	respStruct := app.AdminSendMessageResponse{
+		MessageId:              msg.Uuid,
+		MD5OfMessageBody:       msg.MD5OfMessageBody,
+		MD5OfMessageAttributes: msg.MD5OfMessageAttributes,
+	}
+	if q.IsFIFO {
+		respStruct.SequenceNumber = q.NextSequenceNumber(msg.GroupID)
+	}
+	q.Messages = append(q.Messages, msg)
+	app.SyncQueues.Unlock()
+
+	log.Println("Admin: Putting Message in Queue:", queueName)
+	sendResponseBack(w, http.StatusOK, respStruct)
+}
+
+// DeleteMessage removes a message by its id, whether or not it is in flight.
+func DeleteMessage(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	queueName := vars["queueName"]
+	messageId := vars["messageId"]
+
+	app.SyncQueues.Lock()
+	defer app.SyncQueues.Unlock()
+	q, ok := app.SyncQueues.Queues[queueName]
+	if !ok {
+		createErrorResponse(w, http.StatusNotFound, "queue not found: "+queueName)
+		return
+	}
+	for i, msg := range q.Messages {
+		if msg.Uuid != messageId {
+			continue
+		}
+		if msg.ReceiptHandle != "" {
+			q.UnlockGroup(msg.GroupID)
+		}
+		q.Messages = append(q.Messages[:i], q.Messages[i+1:]...)
+		delete(q.Duplicates, msg.DeduplicationID)
+		log.Println("Admin: Deleted Message", messageId, "from Queue:", queueName)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	createErrorResponse(w, http.StatusNotFound, "message not found: "+messageId)
+}
+
+// ListTopics returns every topic with its subscriptions.
+func ListTopics(w http.ResponseWriter, req *http.Request) {
+	sendResponseBack(w, http.StatusOK, app.AdminListTopicsResponse{Topics: adminTopics()})
+}
+
+// ListOutbox returns the messages delivered to email, email-json, sms and
+// application endpoints, oldest first, optionally only those with the given
+// protocol, endpoint or topicArn query parameters.
+func ListOutbox(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	respStruct := app.AdminListOutboxResponse{Messages: []app.AdminOutboxMessage{}}
+	for _, m := range sns.OutboxMessages() {
+		if (query.Get("protocol") != "" && m.Protocol != query.Get("protocol")) ||
+			(query.Get("endpoint") != "" && m.EndPoint != query.Get("endpoint")) ||
+			(query.Get("topicArn") != "" && m.TopicArn != query.Get("topicArn")) {
+			continue
+		}
+		respStruct.Messages = append(respStruct.Messages, adminOutboxMessage(m))
+	}
+	sendResponseBack(w, http.StatusOK, respStruct)
+}
+
+// ClearOutbox deletes the messages in the outbox.
+func ClearOutbox(w http.ResponseWriter, req *http.Request) {
+	sns.ClearOutbox()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListPublished returns the audit trail of the messages published, oldest
+// first, optionally only those with the given topicArn query parameter.
+func ListPublished(w http.ResponseWriter, req *http.Request) {
+	topicArn := req.URL.Query().Get("topicArn")
+	respStruct := app.AdminListPublishedResponse{Messages: []app.AdminPublishedMessage{}}
+	for _, m := range sns.PublishedMessages() {
+		if topicArn != "" && m.TopicArn != topicArn {
+			continue
+		}
+		respStruct.Messages = append(respStruct.Messages, adminPublishedMessage(m))
+	}
+	sendResponseBack(w, http.StatusOK, respStruct)
+}
+
+// GetPublished returns a published message, with its deliveries, by the
+// MessageId Publish returned.
+func GetPublished(w http.ResponseWriter, req *http.Request) {
+	messageId := mux.Vars(req)["messageId"]
+	m, ok := sns.FindPublishedMessage(messageId)
+	if !ok {
+		createErrorResponse(w, http.StatusNotFound, "published message not found: "+messageId)
+		return
+	}
+	sendResponseBack(w, http.StatusOK, adminPublishedMessage(m))
+}
+
+// ClearPublished deletes the audit trail of the messages published.
+func ClearPublished(w http.ResponseWriter, req *http.Request) {
+	sns.ClearPublishedMessages()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSnapshot returns every queue with its messages and every topic with its subscriptions.
+func GetSnapshot(w http.ResponseWriter, req *http.Request) {
+	sendResponseBack(w, http.StatusOK, snapshot())
+}
+
+// WriteSnapshot writes the state GetSnapshot returns to a file, e.g. on shutdown.
+func WriteSnapshot(filename string) error {
+	data, err := json.MarshalIndent(snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, data, 0644)
+}
+
+func snapshot() app.AdminSnapshot {
+	respStruct := app.AdminSnapshot{
+		Time:   app.Now().UnixNano() / int64(time.Millisecond),
+		Queues: []app.AdminSnapshotQueue{},
+		Topics: adminTopics(),
+	}
+
+	app.SyncQueues.RLock()
+	for _, q := range app.SyncQueues.Queues {
+		queue := app.AdminSnapshotQueue{AdminQueue: queueStats(q), Messages: []app.AdminMessage{}}
+		for i := range q.Messages {
+			queue.Messages = append(queue.Messages, adminMessage(&q.Messages[i]))
+		}
+		respStruct.Queues = append(respStruct.Queues, queue)
+	}
+	app.SyncQueues.RUnlock()
+
+	sort.Slice(respStruct.Queues, func(i, j int) bool {
+		return respStruct.Queues[i].Name < respStruct.Queues[j].Name
+	})
+	return respStruct
+}
+
+func adminTopics() []app.AdminTopic {
+	topics := []app.AdminTopic{}
+
+	app.SyncTopics.RLock()
+	for _, topic := range app.SyncTopics.Topics {
+		t := app.AdminTopic{Name: topic.Name, Arn: topic.Arn, Subscriptions: []app.AdminSubscription{}}
+		for _, sub := range topic.Subscriptions {
+			s := app.AdminSubscription{
+				SubscriptionArn: sub.SubscriptionArn,
+				Protocol:        sub.Protocol,
+				Endpoint:        sub.EndPoint,
+				Raw:             sub.Raw,
+				Pending:         sub.PendingConfirmation,
+			}
+			if sub.FilterPolicy != nil {
+				filterPolicy, _ := json.Marshal(sub.FilterPolicy)
+				s.FilterPolicy = string(filterPolicy)
+			}
+			t.Subscriptions = append(t.Subscriptions, s)
+		}
+		topics = append(topics, t)
+	}
+	app.SyncTopics.RUnlock()
+
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Name < topics[j].Name
+	})
+	return topics
+}
+
+// Reset deletes all queues, topics, subscriptions, outbox and published messages
+// and KMS keys, removes every fault rule and switches back to the system clock.
+func Reset(w http.ResponseWriter, req *http.Request) {
+	log.Println("Admin: Resetting all state")
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues = make(map[string]*app.Queue)
+	app.SyncQueues.Unlock()
+
+	app.SyncTopics.Lock()
+	app.SyncTopics.Topics = make(map[string]*app.Topic)
+	sns.ResetPendingConfirmations()
+	app.SyncTopics.Unlock()
+	sns.ClearOutbox()
+	sns.ClearPublishedMessages()
+
+	app.SyncKeys.Lock()
+	app.SyncKeys.Keys = make(map[string]*app.KmsKey)
+	app.SyncKeys.Unlock()
+
+	fault.SetRules(nil)
+	app.SetClock(nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetCACertificate returns the PEM encoded certificate of the CA generated for HTTPS,
+// to add to trust stores.
+func GetCACertificate(w http.ResponseWriter, req *http.Request) {
+	pem := certs.CAPEM()
+	if pem == nil {
+		createErrorResponse(w, http.StatusNotFound, "no CA certificate was generated, TLS is disabled or uses a given certificate")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.WriteHeader(http.StatusOK)
+	w.Write(pem)
+}
+
+// GetClock returns the current time of the emulator clock, in milliseconds since the epoch.
+func GetClock(w http.ResponseWriter, req *http.Request) {
+	sendResponseBack(w, http.StatusOK, app.AdminClockResponse{Now: app.Now().UnixNano() / int64(time.Millisecond)})
+}
+
+// AdvanceClock moves the emulator clock forward by a duration such as "30s" or "1h".
+func AdvanceClock(w http.ResponseWriter, req *http.Request) {
+	request := app.AdminAdvanceClockRequest{}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		createErrorResponse(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	d, err := time.ParseDuration(request.Duration)
+	if err != nil || d < 0 {
+		createErrorResponse(w, http.StatusBadRequest, "invalid duration: "+request.Duration)
+		return
+	}
+
+	log.Println("Admin: Advancing clock by", d)
+	app.AdvanceClock(d)
+	GetClock(w, req)
+}
+
+// ListFaults returns the fault injection rules.
+func ListFaults(w http.ResponseWriter, req *http.Request) {
+	sendResponseBack(w, http.StatusOK, app.AdminListFaultsResponse{Faults: fault.Rules()})
+}
+
+// AddFault adds a fault injection rule.
+func AddFault(w http.ResponseWriter, req *http.Request) {
+	rule := app.FaultRule{}
+	if err := json.NewDecoder(req.Body).Decode(&rule); err != nil {
+		createErrorResponse(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	rule, err := fault.AddRule(rule)
+	if err != nil {
+		createErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.Println("Admin: Added fault rule", rule.Id)
+	sendResponseBack(w, http.StatusOK, rule)
+}
+
+// DeleteFault removes a fault injection rule.
+func DeleteFault(w http.ResponseWriter, req *http.Request) {
+	id := mux.Vars(req)["id"]
+	if !fault.DeleteRule(id) {
+		createErrorResponse(w, http.StatusNotFound, "fault rule not found: "+id)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ClearFaults removes all the fault injection rules.
+func ClearFaults(w http.ResponseWriter, req *http.Request) {
+	fault.SetRules(nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func queueStats(q *app.Queue) app.AdminQueue {
+	stats := app.AdminQueue{
+		Name:   q.Name,
+		Url:    q.URL,
+		Arn:    q.Arn,
+		IsFIFO: q.IsFIFO,
+		Total:  len(q.Messages),
+	}
+	for i := range q.Messages {
+		switch messageState(&q.Messages[i]) {
+		case MessageStateInFlight:
+			stats.InFlight++
+		case MessageStateDelayed:
+			stats.Delayed++
+		default:
+			stats.Visible++
+		}
+	}
+	if q.DeadLetterQueue != nil {
+		stats.DeadLetterTargetArn = q.DeadLetterQueue.Arn
+		stats.MaxReceiveCount = q.MaxReceiveCount
+	}
+	return stats
+}
+
+func messageState(m *app.Message) string {
+	if m.ReceiptHandle != "" {
+		return MessageStateInFlight
+	}
+	if app.Now().Before(m.DelayedUntil()) {
+		return MessageStateDelayed
+	}
+	return MessageStateVisible
+}
+
+func adminMessage(m *app.Message) app.AdminMessage {
+	msg := app.AdminMessage{
+		MessageId:              m.Uuid,
+		Body:                   string(m.MessageBody),
+		MD5OfBody:              m.MD5OfMessageBody,
+		State:                  messageState(m),
+		ReceiptHandle:          m.ReceiptHandle,
+		ReceiveCount:           m.NumberOfReceives,
+		SentTimestamp:          m.SentTime.UnixNano() / int64(time.Millisecond),
+		MessageGroupId:         m.GroupID,
+		MessageDeduplicationId: m.DeduplicationID,
+	}
+	switch msg.State {
+	case MessageStateInFlight:
+		msg.VisibleTimestamp = m.VisibilityTimeout.UnixNano() / int64(time.Millisecond)
+	case MessageStateDelayed:
+		msg.VisibleTimestamp = m.DelayedUntil().UnixNano() / int64(time.Millisecond)
+	}
+	if len(m.MessageAttributes) > 0 {
+		msg.MessageAttributes = adminMessageAttributes(m.MessageAttributes)
+	}
+	return msg
+}
+
+func adminMessageAttributes(attributes map[string]app.MessageAttributeValue) map[string]app.AdminMessageAttribute {
+	adminAttributes := make(map[string]app.AdminMessageAttribute)
+	for name, attr := range attributes {
+		a := app.AdminMessageAttribute{DataType: attr.DataType}
+		if attr.ValueKey == "BinaryValue" {
+			a.BinaryValue = attr.Value
+		} else {
+			a.StringValue = attr.Value
+		}
+		adminAttributes[name] = a
+	}
+	return adminAttributes
+}
+
+func adminOutboxMessage(m app.OutboxMessage) app.AdminOutboxMessage {
+	msg := app.AdminOutboxMessage{
+		MessageId:       m.MessageId,
+		Type:            m.Type,
+		Protocol:        m.Protocol,
+		Endpoint:        m.EndPoint,
+		TopicArn:        m.TopicArn,
+		SubscriptionArn: m.SubscriptionArn,
+		Subject:         m.Subject,
+		Message:         m.Message,
+		SubscribeURL:    m.SubscribeURL,
+		SentTimestamp:   m.SentTime.UnixNano() / int64(time.Millisecond),
+	}
+	if len(m.MessageAttributes) > 0 {
+		msg.MessageAttributes = adminMessageAttributes(m.MessageAttributes)
+	}
+	return msg
+}
+
+func adminPublishedMessage(m app.PublishedMessage) app.AdminPublishedMessage {
+	msg := app.AdminPublishedMessage{
+		MessageId:        m.MessageId,
+		TopicArn:         m.TopicArn,
+		Subject:          m.Subject,
+		Message:          m.Message,
+		MessageStructure: m.MessageStructure,
+		MessageGroupId:   m.MessageGroupId,
+		PublishTimestamp: m.PublishTime.UnixNano() / int64(time.Millisecond),
+		Deliveries:       []app.AdminDelivery{},
+	}
+	if len(m.MessageAttributes) > 0 {
+		msg.MessageAttributes = adminMessageAttributes(m.MessageAttributes)
+	}
+	for _, d := range m.Deliveries {
+		msg.Deliveries = append(msg.Deliveries, app.AdminDelivery{
+			SubscriptionArn: d.SubscriptionArn,
+			Protocol:        d.Protocol,
+			Endpoint:        d.EndPoint,
+			Status:          string(d.Status),
+			Error:           d.Error,
+		})
+	}
+	return msg
+}
+
+func createErrorResponse(w http.ResponseWriter, status int, message string) {
+	sendResponseBack(w, status, app.AdminErrorResponse{Error: message})
+}
+
+func sendResponseBack(w http.ResponseWriter, status int, respStruct interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(respStruct); err != nil {
+		log.Printf("error: %v\n", err)
+	}
+}
@@ -0,0 +1,278 @@
+package goadmin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/certs"
+	"github.com/Admiral-Piett/goaws/app/fault"
+	sns "github.com/Admiral-Piett/goaws/app/gosns"
+	"github.com/Admiral-Piett/goaws/app/gosqs"
+)
+
+func callAdmin(t *testing.T, handler http.HandlerFunc, method string, body string, vars map[string]string, response interface{}) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, "/", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, vars)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if response != nil {
+		if err := json.Unmarshal(rr.Body.Bytes(), response); err != nil {
+			t.Fatalf("unexpected unmarshal error: %s", err)
+		}
+	}
+	return rr
+}
+
+func TestListQueuesAndMessages(t *testing.T) {
+	defer func() {
+		app.SyncQueues.Queues = make(map[string]*app.Queue)
+	}()
+	dlq := &app.Queue{Name: "admin-dlq", Arn: "arn:aws:sqs:local:queue:admin-dlq"}
+	app.SyncQueues.Queues["admin-dlq"] = dlq
+	app.SyncQueues.Queues["admin-queue"] = &app.Queue{
+		Name:            "admin-queue",
+		TimeoutSecs:     60,
+		DeadLetterQueue: dlq,
+		MaxReceiveCount: 3,
+		Messages: []app.Message{
+			{Uuid: "1", MessageBody: []byte("inflight"), SentTime: time.Now()},
+			{Uuid: "2", MessageBody: []byte("visible"), SentTime: time.Now()},
+			{Uuid: "3", MessageBody: []byte("delayed"), SentTime: time.Now(), DelaySecs: 60},
+		},
+	}
+	// receive the first message through SQS
+	req, _ := http.NewRequest("POST", "/", nil)
+	req.PostForm = url.Values{"Action": {"ReceiveMessage"}, "QueueUrl": {"http://localhost:4100/queue/admin-queue"}, "MaxNumberOfMessages": {"1"}}
+	rr := httptest.NewRecorder()
+	gosqs.ReceiveMessage(rr, req)
+	assert.Contains(t, rr.Body.String(), "<Body>inflight</Body>")
+
+	queues := app.AdminListQueuesResponse{}
+	rr = callAdmin(t, ListQueues, "GET", "", nil, &queues)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, queues.Queues, 2)
+	assert.Equal(t, app.AdminQueue{
+		Name:                "admin-queue",
+		Visible:             1,
+		InFlight:            1,
+		Delayed:             1,
+		Total:               3,
+		DeadLetterTargetArn: "arn:aws:sqs:local:queue:admin-dlq",
+		MaxReceiveCount:     3,
+	}, queues.Queues[1])
+
+	messages := app.AdminListMessagesResponse{}
+	rr = callAdmin(t, ListMessages, "GET", "", map[string]string{"queueName": "admin-queue"}, &messages)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, messages.Messages, 3)
+	assert.Equal(t, MessageStateInFlight, messages.Messages[0].State)
+	assert.Equal(t, app.SyncQueues.Queues["admin-queue"].Messages[0].ReceiptHandle, messages.Messages[0].ReceiptHandle)
+	assert.Equal(t, 1, messages.Messages[0].ReceiveCount)
+	assert.Equal(t, MessageStateVisible, messages.Messages[1].State)
+	assert.Equal(t, 0, messages.Messages[1].ReceiveCount)
+	assert.Equal(t, MessageStateDelayed, messages.Messages[2].State)
+
+	// peeking doesn't receive anything
+	assert.Equal(t, "", app.SyncQueues.Queues["admin-queue"].Messages[1].ReceiptHandle)
+
+	rr = callAdmin(t, ListMessages, "GET", "", map[string]string{"queueName": "missing"}, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestSendAndDeleteMessage(t *testing.T) {
+	defer func() {
+		app.SyncQueues.Queues = make(map[string]*app.Queue)
+	}()
+	app.SyncQueues.Queues["admin-queue"] = &app.Queue{Name: "admin-queue"}
+	vars := map[string]string{"queueName": "admin-queue"}
+
+	sent := app.AdminSendMessageResponse{}
+	body := `{"Body": "hello", "SentTimestamp": 1500000000000, "MessageAttributes": {"color": {"DataType": "String", "StringValue": "red"}}}`
+	rr := callAdmin(t, SendMessage, "POST", body, vars, &sent)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", sent.MD5OfMessageBody)
+	assert.NotEmpty(t, sent.MD5OfMessageAttributes)
+
+	q := app.SyncQueues.Queues["admin-queue"]
+	assert.Len(t, q.Messages, 1)
+	assert.Equal(t, int64(1500000000000), q.Messages[0].SentTime.UnixNano()/int64(time.Millisecond))
+	assert.Equal(t, "red", q.Messages[0].MessageAttributes["color"].Value)
+
+	rr = callAdmin(t, SendMessage, "POST", "{not json", vars, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = callAdmin(t, DeleteMessage, "DELETE", "", map[string]string{"queueName": "admin-queue", "messageId": "missing"}, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = callAdmin(t, DeleteMessage, "DELETE", "", map[string]string{"queueName": "admin-queue", "messageId": sent.MessageId}, nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Len(t, q.Messages, 0)
+}
+
+func TestListTopicsAndReset(t *testing.T) {
+	app.SyncQueues.Queues["admin-queue"] = &app.Queue{Name: "admin-queue"}
+	app.SyncTopics.Topics["admin-topic"] = &app.Topic{
+		Name: "admin-topic",
+		Arn:  "arn:aws:sns:local:queue:admin-topic",
+		Subscriptions: []*app.Subscription{
+			{SubscriptionArn: "arn:aws:sns:local:queue:admin-topic:1", Protocol: "sqs", EndPoint: "arn:aws:sqs:local:queue:admin-queue", FilterPolicy: &app.FilterPolicy{"color": {"red"}}},
+		},
+	}
+
+	topics := app.AdminListTopicsResponse{}
+	rr := callAdmin(t, ListTopics, "GET", "", nil, &topics)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, topics.Topics, 1)
+	assert.Len(t, topics.Topics[0].Subscriptions, 1)
+	assert.Equal(t, `{"color":["red"]}`, topics.Topics[0].Subscriptions[0].FilterPolicy)
+
+	assert.NoError(t, fault.SetRules([]app.FaultRule{{Action: "SendMessage", Fault: "throttle"}}))
+	app.SetClock(app.NewManualClock(time.Unix(0, 0)))
+
+	rr = callAdmin(t, Reset, "POST", "", nil, nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Len(t, app.SyncQueues.Queues, 0)
+	assert.Len(t, app.SyncTopics.Topics, 0)
+	assert.Empty(t, fault.Rules())
+	assert.WithinDuration(t, time.Now(), app.Now(), time.Minute)
+}
+
+func TestListAndClearOutbox(t *testing.T) {
+	defer sns.ClearOutbox()
+	for _, phoneNumber := range []string{"+15555550100", "+15555550101"} {
+		req, _ := http.NewRequest("POST", "/", nil)
+		req.PostForm = url.Values{"PhoneNumber": {phoneNumber}, "Message": {"hello"},
+			"MessageAttributes.entry.1.Name": {"kind"}, "MessageAttributes.entry.1.Value.DataType": {"String"}, "MessageAttributes.entry.1.Value.StringValue": {"otp"}}
+		http.HandlerFunc(sns.Publish).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, _ := http.NewRequest("GET", "/_goaws/outbox?protocol=sms&endpoint=%2B15555550101", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ListOutbox).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	outbox := app.AdminListOutboxResponse{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &outbox))
+	if assert.Len(t, outbox.Messages, 1) {
+		msg := outbox.Messages[0]
+		assert.Equal(t, "+15555550101", msg.Endpoint)
+		assert.Equal(t, "hello", msg.Message)
+		assert.Equal(t, map[string]app.AdminMessageAttribute{"kind": {DataType: "String", StringValue: "otp"}}, msg.MessageAttributes)
+	}
+
+	rr = callAdmin(t, ClearOutbox, "DELETE", "", nil, nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	rr = callAdmin(t, ListOutbox, "GET", "", nil, &outbox)
+	assert.Empty(t, outbox.Messages)
+}
+
+func TestListAndGetPublished(t *testing.T) {
+	sns.ClearPublishedMessages()
+	defer sns.ClearPublishedMessages()
+	for _, phoneNumber := range []string{"+15555550100", "+15555550101"} {
+		req, _ := http.NewRequest("POST", "/", nil)
+		req.PostForm = url.Values{"PhoneNumber": {phoneNumber}, "Message": {"hello"}}
+		http.HandlerFunc(sns.Publish).ServeHTTP(httptest.NewRecorder(), req)
+	}
+	sns.ClearOutbox()
+
+	published := app.AdminListPublishedResponse{}
+	rr := callAdmin(t, ListPublished, "GET", "", nil, &published)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	if !assert.Len(t, published.Messages, 2) {
+		return
+	}
+	assert.Equal(t, []app.AdminDelivery{{Protocol: "sms", Endpoint: "+15555550101", Status: "delivered"}}, published.Messages[1].Deliveries)
+
+	msg := app.AdminPublishedMessage{}
+	rr = callAdmin(t, GetPublished, "GET", "", map[string]string{"messageId": published.Messages[1].MessageId}, &msg)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, published.Messages[1], msg)
+	rr = callAdmin(t, GetPublished, "GET", "", map[string]string{"messageId": "unknown"}, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	req, _ := http.NewRequest("GET", "/_goaws/published?topicArn=arn:aws:sns:local:000000000000:other", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(ListPublished).ServeHTTP(rr, req)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &published))
+	assert.Empty(t, published.Messages)
+
+	rr = callAdmin(t, ClearPublished, "DELETE", "", nil, nil)
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Empty(t, sns.PublishedMessages())
+}
+
+func TestAdvanceClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	app.SetClock(app.NewManualClock(start))
+	defer app.SetClock(nil)
+
+	clock := app.AdminClockResponse{}
+	rr := callAdmin(t, AdvanceClock, "POST", `{"Duration": "90s"}`, nil, &clock)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, start.Add(90*time.Second).UnixNano()/int64(time.Millisecond), clock.Now)
+	assert.Equal(t, start.Add(90*time.Second), app.Now())
+
+	rr = callAdmin(t, AdvanceClock, "POST", `{"Duration": "soon"}`, nil, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = callAdmin(t, AdvanceClock, "POST", `{"Duration": "-1s"}`, nil, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestGetCACertificate(t *testing.T) {
+	rr := callAdmin(t, GetCACertificate, "GET", "", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	_, err := certs.ServerConfig(app.Environment{Host: "localhost", TLS: app.TLS{Enabled: true}})
+	assert.NoError(t, err)
+	rr = callAdmin(t, GetCACertificate, "GET", "", nil, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, string(certs.CAPEM()), rr.Body.String())
+	assert.Contains(t, rr.Body.String(), "-----BEGIN CERTIFICATE-----")
+}
+
+func TestSnapshot(t *testing.T) {
+	defer func() {
+		app.SyncQueues.Queues = make(map[string]*app.Queue)
+		app.SyncTopics.Topics = make(map[string]*app.Topic)
+	}()
+	app.SyncQueues.Queues["snapshot-queue"] = &app.Queue{
+		Name:     "snapshot-queue",
+		Messages: []app.Message{{Uuid: "1", MessageBody: []byte("hello"), SentTime: time.Now()}},
+	}
+	app.SyncTopics.Topics["snapshot-topic"] = &app.Topic{Name: "snapshot-topic", Arn: "arn:aws:sns:local:queue:snapshot-topic"}
+
+	snapshot := app.AdminSnapshot{}
+	rr := callAdmin(t, GetSnapshot, "GET", "", nil, &snapshot)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	if assert.Len(t, snapshot.Queues, 1) && assert.Len(t, snapshot.Queues[0].Messages, 1) {
+		assert.Equal(t, "snapshot-queue", snapshot.Queues[0].Name)
+		assert.Equal(t, 1, snapshot.Queues[0].Visible)
+		assert.Equal(t, "hello", snapshot.Queues[0].Messages[0].Body)
+	}
+	assert.Len(t, snapshot.Topics, 1)
+
+	filename := filepath.Join(t.TempDir(), "snapshot.json")
+	assert.NoError(t, WriteSnapshot(filename))
+	data, err := os.ReadFile(filename)
+	assert.NoError(t, err)
+	written := app.AdminSnapshot{}
+	assert.NoError(t, json.Unmarshal(data, &written))
+	assert.GreaterOrEqual(t, written.Time, snapshot.Time)
+	written.Time = snapshot.Time
+	assert.Equal(t, snapshot, written)
+}
@@ -0,0 +1,346 @@
+package gokms
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/common"
+)
+
+var (
+	ErrValidation = &app.KmsErrorType{
+		HttpError: http.StatusBadRequest,
+		Type:      "ValidationException",
+		Message:   "The request failed to satisfy a constraint.",
+	}
+	ErrMalformedPolicyDocument = &app.KmsErrorType{
+		HttpError: http.StatusBadRequest,
+		Type:      "MalformedPolicyDocumentException",
+		Message:   "The key policy is not a valid policy document.",
+	}
+	ErrInvalidCiphertext = &app.KmsErrorType{
+		HttpError: http.StatusBadRequest,
+		Type:      "InvalidCiphertextException",
+		Message:   "The ciphertext is invalid or was encrypted under a different key or encryption context.",
+	}
+	ErrUnknownOperation = &app.KmsErrorType{
+		HttpError: http.StatusBadRequest,
+		Type:      "UnknownOperationException",
+		Message:   "The requested operation is not supported.",
+	}
+)
+
+// ciphertextVersion prefixes every ciphertext blob produced by the local KMS.
+const ciphertextVersion = byte(1)
+
+func init() {
+	app.SyncKeys.Keys = make(map[string]*app.KmsKey)
+}
+
+func CreateKey(w http.ResponseWriter, req *http.Request) {
+	request := app.CreateKeyRequest{}
+	if err := decodeRequest(req, &request); err != nil {
+		createErrorResponse(w, ErrValidation)
+		return
+	}
+	if request.KeyUsage == "" {
+		request.KeyUsage = "ENCRYPT_DECRYPT"
+	}
+	if request.KeyUsage != "ENCRYPT_DECRYPT" {
+		createErrorResponse(w, ErrValidation)
+		return
+	}
+	if err := app.ValidateKmsPolicy(request.Policy); err != nil {
+		createErrorResponse(w, ErrMalformedPolicyDocument)
+		return
+	}
+
+	keyId, _ := common.NewUUID()
+	material := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, material); err != nil {
+		log.Errorf("error generating key material: %v", err)
+		createErrorResponse(w, ErrValidation)
+		return
+	}
+	env := app.GetCurrentEnvironment()
+	key := &app.KmsKey{
+		KeyId:        keyId,
+		Arn:          "arn:aws:kms:" + env.Region + ":" + env.AccountID + ":key/" + keyId,
+		Description:  request.Description,
+		KeyUsage:     request.KeyUsage,
+		KeyState:     "Enabled",
+		CreationDate: app.Now(),
+		Policy:       request.Policy,
+		Material:     material,
+	}
+
+	log.Println("Creating KMS Key:", keyId)
+	app.SyncKeys.Lock()
+	app.SyncKeys.Keys[keyId] = key
+	app.SyncKeys.Unlock()
+
+	sendResponseBack(w, app.CreateKeyResponse{KeyMetadata: keyMetadata(key)})
+}
+
+func DescribeKey(w http.ResponseWriter, req *http.Request) {
+	request := app.DescribeKeyRequest{}
+	if err := decodeRequest(req, &request); err != nil {
+		createErrorResponse(w, ErrValidation)
+		return
+	}
+
+	key, err := findKey(request.KeyId, "kms:DescribeKey")
+	if err != nil {
+		createErrorResponse(w, err)
+		return
+	}
+	sendResponseBack(w, app.DescribeKeyResponse{KeyMetadata: keyMetadata(key)})
+}
+
+func Encrypt(w http.ResponseWriter, req *http.Request) {
+	request := app.EncryptRequest{}
+	if err := decodeRequest(req, &request); err != nil || len(request.Plaintext) == 0 || len(request.Plaintext) > 4096 {
+		createErrorResponse(w, ErrValidation)
+		return
+	}
+
+	key, err := findKey(request.KeyId, "kms:Encrypt")
+	if err != nil {
+		createErrorResponse(w, err)
+		return
+	}
+	ciphertext, err := encrypt(key, request.Plaintext, request.EncryptionContext)
+	if err != nil {
+		log.Errorf("error encrypting with key %s: %v", key.KeyId, err)
+		createErrorResponse(w, ErrValidation)
+		return
+	}
+	sendResponseBack(w, app.EncryptResponse{
+		CiphertextBlob:      ciphertext,
+		KeyId:               key.Arn,
+		EncryptionAlgorithm: "SYMMETRIC_DEFAULT",
+	})
+}
+
+func Decrypt(w http.ResponseWriter, req *http.Request) {
+	request := app.DecryptRequest{}
+	if err := decodeRequest(req, &request); err != nil || len(request.CiphertextBlob) == 0 {
+		createErrorResponse(w, ErrValidation)
+		return
+	}
+
+	keyId, err := ciphertextKeyId(request.CiphertextBlob)
+	if err != nil {
+		createErrorResponse(w, ErrInvalidCiphertext)
+		return
+	}
+	if request.KeyId != "" {
+		requested, err := findKey(request.KeyId, "kms:Decrypt")
+		if err != nil {
+			createErrorResponse(w, err)
+			return
+		}
+		if requested.KeyId != keyId {
+			createErrorResponse(w, app.ErrKmsIncorrectKey)
+			return
+		}
+	}
+	key, err := findKey(keyId, "kms:Decrypt")
+	if err != nil {
+		createErrorResponse(w, err)
+		return
+	}
+	plaintext, err := decrypt(key, request.CiphertextBlob, request.EncryptionContext)
+	if err != nil {
+		createErrorResponse(w, ErrInvalidCiphertext)
+		return
+	}
+	sendResponseBack(w, app.DecryptResponse{
+		KeyId:               key.Arn,
+		Plaintext:           plaintext,
+		EncryptionAlgorithm: "SYMMETRIC_DEFAULT",
+	})
+}
+
+func GenerateDataKey(w http.ResponseWriter, req *http.Request) {
+	request := app.GenerateDataKeyRequest{}
+	if err := decodeRequest(req, &request); err != nil {
+		createErrorResponse(w, ErrValidation)
+		return
+	}
+
+	numberOfBytes := request.NumberOfBytes
+	switch {
+	case request.KeySpec == "AES_256" && numberOfBytes == 0:
+		numberOfBytes = 32
+	case request.KeySpec == "AES_128" && numberOfBytes == 0:
+		numberOfBytes = 16
+	case request.KeySpec == "" && numberOfBytes > 0 && numberOfBytes <= 1024:
+	default:
+		createErrorResponse(w, ErrValidation)
+		return
+	}
+
+	key, err := findKey(request.KeyId, "kms:GenerateDataKey")
+	if err != nil {
+		createErrorResponse(w, err)
+		return
+	}
+	plaintext := make([]byte, numberOfBytes)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		log.Errorf("error generating data key: %v", err)
+		createErrorResponse(w, ErrValidation)
+		return
+	}
+	ciphertext, err := encrypt(key, plaintext, request.EncryptionContext)
+	if err != nil {
+		log.Errorf("error encrypting with key %s: %v", key.KeyId, err)
+		createErrorResponse(w, ErrValidation)
+		return
+	}
+	sendResponseBack(w, app.GenerateDataKeyResponse{
+		CiphertextBlob: ciphertext,
+		KeyId:          key.Arn,
+		Plaintext:      plaintext,
+	})
+}
+
+// findKey resolves a customer managed key and checks its policy allows the action.
+func findKey(keyId string, action string) (*app.KmsKey, error) {
+	app.SyncKeys.RLock()
+	defer app.SyncKeys.RUnlock()
+	key, err := app.FindKmsKey(keyId)
+	if err != nil {
+		return nil, err
+	}
+	if key.Material == nil {
+		// AWS managed keys can only be used by the services that own them
+		return nil, app.ErrKmsAccessDenied
+	}
+	if !key.Allows(action) {
+		return nil, app.ErrKmsAccessDenied
+	}
+	return key, nil
+}
+
+func keyMetadata(key *app.KmsKey) app.KeyMetadata {
+	return app.KeyMetadata{
+		AWSAccountId: app.GetCurrentEnvironment().AccountID,
+		Arn:          key.Arn,
+		CreationDate: float64(key.CreationDate.UnixNano()) / float64(time.Second),
+		Description:  key.Description,
+		Enabled:      key.KeyState == "Enabled",
+		KeyId:        key.KeyId,
+		KeyManager:   "CUSTOMER",
+		KeySpec:      "SYMMETRIC_DEFAULT",
+		KeyState:     key.KeyState,
+		KeyUsage:     key.KeyUsage,
+		Origin:       "AWS_KMS",
+	}
+}
+
+// encrypt seals the plaintext with AES-GCM, using the encryption context as additional
+// data. The blob is laid out as version | key id length | key id | nonce | sealed data.
+func encrypt(key *app.KmsKey, plaintext []byte, encryptionContext map[string]string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	blob := bytes.NewBuffer([]byte{ciphertextVersion})
+	binary.Write(blob, binary.BigEndian, uint16(len(key.KeyId)))
+	blob.WriteString(key.KeyId)
+	blob.Write(nonce)
+	blob.Write(gcm.Seal(nil, nonce, plaintext, contextAdditionalData(encryptionContext)))
+	return blob.Bytes(), nil
+}
+
+func decrypt(key *app.KmsKey, blob []byte, encryptionContext map[string]string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	offset := 3 + len(key.KeyId)
+	if len(blob) < offset+gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce := blob[offset : offset+gcm.NonceSize()]
+	return gcm.Open(nil, nonce, blob[offset+gcm.NonceSize():], contextAdditionalData(encryptionContext))
+}
+
+func ciphertextKeyId(blob []byte) (string, error) {
+	if len(blob) < 3 || blob[0] != ciphertextVersion {
+		return "", errors.New("unknown ciphertext format")
+	}
+	length := int(binary.BigEndian.Uint16(blob[1:3]))
+	if len(blob) < 3+length {
+		return "", errors.New("ciphertext too short")
+	}
+	return string(blob[3 : 3+length]), nil
+}
+
+func newGCM(key *app.KmsKey) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key.Material)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func contextAdditionalData(encryptionContext map[string]string) []byte {
+	keys := make([]string, 0, len(encryptionContext))
+	for k := range encryptionContext {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		buf.WriteString(k + "=" + encryptionContext[k] + "\n")
+	}
+	return buf.Bytes()
+}
+
+func decodeRequest(req *http.Request, v interface{}) error {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(body, v)
+}
+
+func createErrorResponse(w http.ResponseWriter, err error) {
+	er, ok := err.(*app.KmsErrorType)
+	if !ok {
+		er = ErrValidation
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(er.HttpError)
+	if err := json.NewEncoder(w).Encode(app.KmsErrorResponse{Type: er.Type, Message: er.Message}); err != nil {
+		log.Printf("error: %v\n", err)
+	}
+}
+
+func sendResponseBack(w http.ResponseWriter, respStruct interface{}) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	if err := json.NewEncoder(w).Encode(respStruct); err != nil {
+		log.Printf("error: %v\n", err)
+	}
+}
@@ -0,0 +1,126 @@
+package gokms
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+func callKms(t *testing.T, handler http.HandlerFunc, request interface{}, response interface{}) *httptest.ResponseRecorder {
+	body, err := json.Marshal(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if response != nil && rr.Code == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), response); err != nil {
+			t.Fatalf("unexpected unmarshal error: %s", err)
+		}
+	}
+	return rr
+}
+
+func createKey(t *testing.T, policy string) app.KeyMetadata {
+	resp := app.CreateKeyResponse{}
+	rr := callKms(t, CreateKey, app.CreateKeyRequest{Description: "test", Policy: policy}, &resp)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	return resp.KeyMetadata
+}
+
+func TestCreateKeyAndDescribeKey(t *testing.T) {
+	key := createKey(t, "")
+	assert.Equal(t, "Enabled", key.KeyState)
+	assert.Equal(t, "ENCRYPT_DECRYPT", key.KeyUsage)
+
+	for _, keyId := range []string{key.KeyId, key.Arn} {
+		resp := app.DescribeKeyResponse{}
+		rr := callKms(t, DescribeKey, app.DescribeKeyRequest{KeyId: keyId}, &resp)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, key.KeyId, resp.KeyMetadata.KeyId)
+	}
+
+	rr := callKms(t, DescribeKey, app.DescribeKeyRequest{KeyId: "does-not-exist"}, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "NotFoundException")
+}
+
+func TestCreateKey_MalformedPolicy(t *testing.T) {
+	rr := callKms(t, CreateKey, app.CreateKeyRequest{Policy: "{not json"}, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "MalformedPolicyDocumentException")
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := createKey(t, "")
+	context := map[string]string{"queue": "test"}
+
+	encrypted := app.EncryptResponse{}
+	rr := callKms(t, Encrypt, app.EncryptRequest{KeyId: key.KeyId, Plaintext: []byte("secret"), EncryptionContext: context}, &encrypted)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, string(encrypted.CiphertextBlob), "secret")
+
+	decrypted := app.DecryptResponse{}
+	rr = callKms(t, Decrypt, app.DecryptRequest{CiphertextBlob: encrypted.CiphertextBlob, EncryptionContext: context}, &decrypted)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "secret", string(decrypted.Plaintext))
+	assert.Equal(t, key.Arn, decrypted.KeyId)
+
+	// the encryption context has to match
+	rr = callKms(t, Decrypt, app.DecryptRequest{CiphertextBlob: encrypted.CiphertextBlob}, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "InvalidCiphertextException")
+
+	// and so does the key, if given
+	other := createKey(t, "")
+	rr = callKms(t, Decrypt, app.DecryptRequest{CiphertextBlob: encrypted.CiphertextBlob, KeyId: other.KeyId, EncryptionContext: context}, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "IncorrectKeyException")
+}
+
+func TestGenerateDataKey(t *testing.T) {
+	key := createKey(t, "")
+
+	dataKey := app.GenerateDataKeyResponse{}
+	rr := callKms(t, GenerateDataKey, app.GenerateDataKeyRequest{KeyId: key.KeyId, KeySpec: "AES_256"}, &dataKey)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, dataKey.Plaintext, 32)
+
+	decrypted := app.DecryptResponse{}
+	rr = callKms(t, Decrypt, app.DecryptRequest{CiphertextBlob: dataKey.CiphertextBlob}, &decrypted)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, dataKey.Plaintext, decrypted.Plaintext)
+
+	rr = callKms(t, GenerateDataKey, app.GenerateDataKeyRequest{KeyId: key.KeyId, KeySpec: "AES_512"}, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "ValidationException")
+}
+
+func TestKeyPolicy_AccessDenied(t *testing.T) {
+	policy := `{"Statement": [
+		{"Effect": "Allow", "Action": "kms:*"},
+		{"Effect": "Deny", "Action": ["kms:GenerateDataKey", "kms:Decrypt"]}
+	]}`
+	key := createKey(t, policy)
+
+	rr := callKms(t, Encrypt, app.EncryptRequest{KeyId: key.KeyId, Plaintext: []byte("secret")}, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = callKms(t, GenerateDataKey, app.GenerateDataKeyRequest{KeyId: key.KeyId, KeySpec: "AES_256"}, nil)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "AccessDeniedException")
+}
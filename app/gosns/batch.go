@@ -0,0 +1,122 @@
+package gosns
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// batchEntry is an entry of a PublishBatch request.
+type batchEntry struct {
+	publishEntry
+	Id string
+}
+
+// aws --endpoint-url http://localhost:4100 sns publish-batch --topic-arn arn:aws:sns:us-east-1:100010001000:local-topic1 --publish-batch-request-entries Id=1,Message=hello Id=2,Message=world
+func PublishBatch(w http.ResponseWriter, req *http.Request) {
+	content := req.FormValue("ContentType")
+	topicArn := req.FormValue("TopicArn")
+
+	arnSegments := strings.Split(topicArn, ":")
+	topicName := arnSegments[len(arnSegments)-1]
+	app.SyncTopics.RLock()
+	topic, ok := app.SyncTopics.Topics[topicName]
+	var kmsMasterKeyId string
+	if ok {
+		kmsMasterKeyId = topic.KmsMasterKeyId
+	}
+	app.SyncTopics.RUnlock()
+	if !ok {
+		createErrorResponse(w, req, "TopicNotFound")
+		return
+	}
+
+	entries := getBatchEntriesFromRequest(req)
+	ids := make([]string, 0, len(entries))
+	totalSize := 0
+	for _, entry := range entries {
+		ids = append(ids, entry.Id)
+		totalSize += common.MessageSize(entry.Message, entry.MessageAttributes)
+	}
+	if errName := common.ValidateBatchEntryIds(ids); errName != "" {
+		createErrorResponse(w, req, errName)
+		return
+	}
+	if totalSize > common.MaxBatchPayloadSize {
+		createErrorResponse(w, req, "BatchRequestTooLong")
+		return
+	}
+	if errName := checkTopicKmsKey(kmsMasterKeyId); errName != "" {
+		createErrorResponse(w, req, errName)
+		return
+	}
+
+	result := app.PublishBatchResult{
+		Successful: app.PublishBatchSuccessful{Member: []app.PublishBatchResultEntry{}},
+		Failed:     app.PublishBatchFailed{Member: []app.BatchResultErrorEntry{}},
+	}
+	for _, entry := range entries {
+		if errName := validatePublish(entry.publishEntry); errName != "" {
+			er := app.SnsErrors[errName]
+			result.Failed.Member = append(result.Failed.Member, common.NewBatchResultErrorEntry(entry.Id, er.Code, er.Message))
+			continue
+		}
+		entry.MessageId, _ = common.NewUUID()
+		publishToTopic(req.Context(), topicArn, topicName, entry.publishEntry)
+		result.Successful.Member = append(result.Successful.Member, app.PublishBatchResultEntry{Id: entry.Id, MessageId: entry.MessageId})
+	}
+	log.WithFields(log.Fields{
+		"topicArn":   topicArn,
+		"successful": len(result.Successful.Member),
+		"failed":     len(result.Failed.Member),
+	}).Debug("Published batch to Topic")
+
+	uuid, _ := common.NewUUID()
+	respStruct := app.PublishBatchResponse{Xmlns: "http://sns.amazonaws.com/doc/2010-03-31/", Result: result, Metadata: app.ResponseMetadata{RequestId: uuid}}
+	SendResponseBack(w, req, respStruct, content)
+}
+
+// getBatchEntriesFromRequest returns the PublishBatchRequestEntries.member.N
+// entries of a request, in order.
+func getBatchEntriesFromRequest(req *http.Request) []batchEntry {
+	req.ParseForm()
+	// collect the indexes present rather than counting up to the highest one, which
+	// can be arbitrarily large
+	present := map[int]bool{}
+	for key := range req.Form {
+		keySegments := strings.Split(key, ".")
+		if len(keySegments) > 3 && keySegments[0] == "PublishBatchRequestEntries" && keySegments[1] == "member" {
+			if i, err := strconv.Atoi(keySegments[2]); err == nil && i > 0 {
+				present[i] = true
+			}
+		}
+	}
+	indexes := make([]int, 0, len(present))
+	for i := range present {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	entries := make([]batchEntry, 0, len(indexes))
+	for _, i := range indexes {
+		prefix := fmt.Sprintf("PublishBatchRequestEntries.member.%d.", i)
+		entries = append(entries, batchEntry{
+			Id: req.FormValue(prefix + "Id"),
+			publishEntry: publishEntry{
+				Message:                req.FormValue(prefix + "Message"),
+				Subject:                req.FormValue(prefix + "Subject"),
+				MessageStructure:       req.FormValue(prefix + "MessageStructure"),
+				MessageAttributes:      getMessageAttributesFromRequest(req, prefix+"MessageAttributes"),
+				MessageGroupId:         req.FormValue(prefix + "MessageGroupId"),
+				MessageDeduplicationId: req.FormValue(prefix + "MessageDeduplicationId"),
+			},
+		})
+	}
+	return entries
+}
@@ -0,0 +1,139 @@
+package gosns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/common"
+)
+
+func TestPublishBatch_FansOutEntries(t *testing.T) {
+	topicArn := addConfirmationTopic(t, "BatchTopic")
+	queueArn := "arn:aws:sqs:local:000000000000:batch-queue.fifo"
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["batch-queue.fifo"] = &app.Queue{Name: "batch-queue.fifo", Arn: queueArn, IsFIFO: true}
+	app.SyncQueues.Unlock()
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "batch-queue.fifo")
+		app.SyncQueues.Unlock()
+	}()
+	app.SyncTopics.Topics["BatchTopic"].Subscriptions = []*app.Subscription{
+		{TopicArn: topicArn, Protocol: "sqs", SubscriptionArn: topicArn + ":sqs", EndPoint: queueArn, FilterPolicy: &app.FilterPolicy{"kind": {"order"}}},
+	}
+
+	rr := callSNS(PublishBatch, url.Values{
+		"TopicArn":                                                                        {topicArn},
+		"PublishBatchRequestEntries.member.1.Id":                                          {"first"},
+		"PublishBatchRequestEntries.member.1.Message":                                     {"order 1"},
+		"PublishBatchRequestEntries.member.1.Subject":                                     {"orders"},
+		"PublishBatchRequestEntries.member.1.MessageGroupId":                              {"group-1"},
+		"PublishBatchRequestEntries.member.1.MessageDeduplicationId":                      {"dedup-1"},
+		"PublishBatchRequestEntries.member.1.MessageAttributes.entry.1.Name":              {"kind"},
+		"PublishBatchRequestEntries.member.1.MessageAttributes.entry.1.Value.DataType":    {"String"},
+		"PublishBatchRequestEntries.member.1.MessageAttributes.entry.1.Value.StringValue": {"order"},
+		"PublishBatchRequestEntries.member.2.Id":                                          {"filtered"},
+		"PublishBatchRequestEntries.member.2.Message":                                     {"refund 1"},
+		"PublishBatchRequestEntries.member.3.Id":                                          {"empty"},
+		"PublishBatchRequestEntries.member.4.Id":                                          {"invalid"},
+		"PublishBatchRequestEntries.member.4.Message":                                     {`{"sqs": "no default"}`},
+		"PublishBatchRequestEntries.member.4.MessageStructure":                            {"json"},
+		"PublishBatchRequestEntries.member.4.MessageAttributes.entry.1.Name":              {"kind"},
+		"PublishBatchRequestEntries.member.4.MessageAttributes.entry.1.Value.DataType":    {"String"},
+		"PublishBatchRequestEntries.member.4.MessageAttributes.entry.1.Value.StringValue": {"order"},
+	}, true)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Regexp(t, `<Successful>\s*<member>\s*<Id>first</Id>\s*<MessageId>[^<]+</MessageId>\s*</member>\s*<member>\s*<Id>filtered</Id>`, body)
+	assert.Regexp(t, `<member>\s*<Code>InvalidParameter</Code>\s*<Id>empty</Id>`, body)
+	assert.Regexp(t, `<member>\s*<Code>InvalidParameter</Code>\s*<Id>invalid</Id>\s*<Message>`+app.ErrNoDefaultElementInJSON+`</Message>`, body)
+
+	// only the first entry matches the filter policy of the subscription
+	messages := app.SyncQueues.Queues["batch-queue.fifo"].Messages
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, "group-1", messages[0].GroupID)
+		assert.Equal(t, "dedup-1", messages[0].DeduplicationID)
+		var notification app.SNSMessage
+		assert.NoError(t, json.Unmarshal(messages[0].MessageBody, &notification))
+		assert.Equal(t, "order 1", notification.Message)
+		assert.Equal(t, "orders", notification.Subject)
+	}
+}
+
+func TestPublishBatch_Errors(t *testing.T) {
+	topicArn := addConfirmationTopic(t, "BatchErrorsTopic")
+	entries := func(ids ...string) url.Values {
+		form := url.Values{"TopicArn": {topicArn}}
+		for i, id := range ids {
+			form.Set(fmt.Sprintf("PublishBatchRequestEntries.member.%d.Id", i+1), id)
+			form.Set(fmt.Sprintf("PublishBatchRequestEntries.member.%d.Message", i+1), "hello")
+		}
+		return form
+	}
+
+	for _, test := range []struct {
+		form url.Values
+		code string
+	}{
+		{entries(), "EmptyBatchRequest"},
+		{entries("1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"), "TooManyEntriesInBatchRequest"},
+		{entries("1", "2", "1"), "BatchEntryIdsNotDistinct"},
+		{entries("not valid"), "InvalidBatchEntryId"},
+	} {
+		rr := callSNS(PublishBatch, test.form, true)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "<Code>"+test.code+"</Code>")
+	}
+
+	form := entries("1", "2")
+	form.Set("PublishBatchRequestEntries.member.2.Message", strings.Repeat("x", common.MaxBatchPayloadSize))
+	rr := callSNS(PublishBatch, form, true)
+	assert.Contains(t, rr.Body.String(), "<Code>BatchRequestTooLong</Code>")
+
+	// entries are read from the indexes present, however large
+	form = url.Values{"TopicArn": {topicArn},
+		"PublishBatchRequestEntries.member.9000000000000.Id":      {"huge"},
+		"PublishBatchRequestEntries.member.9000000000000.Message": {"hello"}}
+	rr = callSNS(PublishBatch, form, true)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "<Id>huge</Id>")
+
+	form = entries("1")
+	form.Set("TopicArn", topicArn+"-missing")
+	rr = callSNS(PublishBatch, form, true)
+	assert.Contains(t, rr.Body.String(), "<Code>AWS.SimpleNotificationService.NonExistentTopic</Code>")
+}
+
+func TestPublishBatch_WhileTopicsChange(t *testing.T) {
+	topicArn := addConfirmationTopic(t, "BatchChangingTopic")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		name := "batch-changing-" + strconv.Itoa(i)
+		go func() {
+			defer wg.Done()
+			addConfirmationTopic(t, name)
+		}()
+		go func() {
+			defer wg.Done()
+			rr := callSNS(PublishBatch, url.Values{"TopicArn": {topicArn},
+				"PublishBatchRequestEntries.member.1.Id": {"1"}, "PublishBatchRequestEntries.member.1.Message": {"hello"}}, true)
+			assert.Equal(t, http.StatusOK, rr.Code)
+		}()
+		go func() {
+			defer wg.Done()
+			rr := callSNS(Publish, url.Values{"TopicArn": {topicArn}, "Message": {"hello"}}, true)
+			assert.Equal(t, http.StatusOK, rr.Code)
+		}()
+	}
+	wg.Wait()
+}
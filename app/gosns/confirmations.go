@@ -0,0 +1,109 @@
+package gosns
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// ConfirmationTTL is how long the token of a confirmation message can be used. SNS
+// deletes subscriptions that aren't confirmed by then.
+const ConfirmationTTL = 3 * 24 * time.Hour
+
+type pendingConfirm struct {
+	subscription *app.Subscription
+	expires      time.Time
+}
+
+// confirmations maps the tokens of the confirmation messages sent to the
+// subscriptions they confirm, or restore after an unsubscribe. It is guarded by
+// app.SyncTopics.
+var confirmations = map[string]*pendingConfirm{}
+
+// ResetPendingConfirmations forgets the subscriptions awaiting confirmation. The
+// caller must hold the app.SyncTopics lock.
+func ResetPendingConfirmations() {
+	confirmations = map[string]*pendingConfirm{}
+}
+
+// addConfirmation returns a new token confirming the subscription. The caller
+// must hold the app.SyncTopics lock.
+func addConfirmation(sub *app.Subscription) string {
+	token, _ := common.NewUUID()
+	confirmations[token] = &pendingConfirm{subscription: sub, expires: app.Now().Add(ConfirmationTTL)}
+	return token
+}
+
+// sendConfirmation posts a SubscriptionConfirmation or UnsubscribeConfirmation
+// message with the token to the endpoint of the subscription, or records it in
+// the outbox for email endpoints.
+func sendConfirmation(msgType string, sub *app.Subscription, token string) {
+	message := "You have chosen to subscribe to the topic " + sub.TopicArn + ".\nTo confirm the subscription, visit the SubscribeURL included in this message."
+	if msgType == "UnsubscribeConfirmation" {
+		message = "You have chosen to deactivate subscription " + sub.SubscriptionArn + ".\nTo cancel this operation and restore the subscription, visit the SubscribeURL included in this message."
+	}
+	snsMSG := confirmationMessage(msgType, sub.TopicArn, token, message)
+	if app.IsOutboxProtocol(app.Protocol(sub.Protocol)) {
+		recordConfirmation(sub, snsMSG)
+		return
+	}
+	// confirmations are JSON documents even with raw message delivery
+	if err := callEndpoint(sub.EndPoint, sub.SubscriptionArn, snsMSG, false); err != nil {
+		log.Error("Error posting to url ", err)
+	}
+}
+
+// authenticated tells whether a request is a signed API call rather than a visit
+// to the SubscribeURL or UnsubscribeURL of a message.
+func authenticated(req *http.Request) bool {
+	return req.Header.Get("Authorization") != ""
+}
+
+// PeriodicTasks expires confirmation tokens every d until quit is closed.
+func PeriodicTasks(d time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(d)
+	for {
+		select {
+		case <-ticker.C:
+			expireConfirmations()
+		case <-quit:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// expireConfirmations forgets the expired tokens and deletes the subscriptions
+// that weren't confirmed before their last token expired.
+func expireConfirmations() {
+	app.SyncTopics.Lock()
+	defer app.SyncTopics.Unlock()
+	now := app.Now()
+	valid := map[*app.Subscription]bool{}
+	for token, pending := range confirmations {
+		if now.After(pending.expires) {
+			delete(confirmations, token)
+		} else {
+			valid[pending.subscription] = true
+		}
+	}
+	for _, topic := range app.SyncTopics.Topics {
+		subscriptions := make([]*app.Subscription, 0, len(topic.Subscriptions))
+		for _, sub := range topic.Subscriptions {
+			if sub.PendingConfirmation && !valid[sub] {
+				log.WithFields(log.Fields{
+					"topic":    topic.Name,
+					"endpoint": sub.EndPoint,
+				}).Info("Deleting unconfirmed subscription")
+				continue
+			}
+			subscriptions = append(subscriptions, sub)
+		}
+		if len(subscriptions) < len(topic.Subscriptions) {
+			topic.Subscriptions = subscriptions
+		}
+	}
+}
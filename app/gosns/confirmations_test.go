@@ -0,0 +1,204 @@
+package gosns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+// callSNS calls an SNS handler with the form values, signed like an SDK request
+// if authenticated is set.
+func callSNS(handler http.HandlerFunc, form url.Values, authenticated bool) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/", nil)
+	req.PostForm = form
+	if authenticated {
+		req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=x/20240101/us-east-1/sns/aws4_request")
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+// confirmationEndpoint starts an HTTP endpoint recording the messages SNS posts to it.
+func confirmationEndpoint(t *testing.T) (*httptest.Server, chan app.SNSMessage) {
+	received := make(chan app.SNSMessage, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var msg app.SNSMessage
+		json.NewDecoder(req.Body).Decode(&msg)
+		received <- msg
+	}))
+	t.Cleanup(ts.Close)
+	return ts, received
+}
+
+func receive(t *testing.T, received chan app.SNSMessage, msgType string) app.SNSMessage {
+	t.Helper()
+	select {
+	case msg := <-received:
+		assert.Equal(t, msgType, msg.Type)
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatalf("no %s message was received", msgType)
+	}
+	return app.SNSMessage{}
+}
+
+func subscribeHTTP(t *testing.T, topicArn string, endpoint string) *app.Subscription {
+	t.Helper()
+	rr := callSNS(Subscribe, url.Values{"TopicArn": {topicArn}, "Protocol": {"http"}, "Endpoint": {endpoint}}, true)
+	assert.Contains(t, rr.Body.String(), "<SubscriptionArn>pending confirmation</SubscriptionArn>")
+	app.SyncTopics.RLock()
+	defer app.SyncTopics.RUnlock()
+	for _, topic := range app.SyncTopics.Topics {
+		for _, sub := range topic.Subscriptions {
+			if sub.EndPoint == endpoint {
+				return sub
+			}
+		}
+	}
+	t.Fatal("subscription not found")
+	return nil
+}
+
+func addConfirmationTopic(t *testing.T, name string) string {
+	arn := "arn:aws:sns:local:000000000000:" + name
+	app.SyncTopics.Lock()
+	app.SyncTopics.Topics[name] = &app.Topic{Name: name, Arn: arn}
+	app.SyncTopics.Unlock()
+	t.Cleanup(func() {
+		app.SyncTopics.Lock()
+		delete(app.SyncTopics.Topics, name)
+		app.SyncTopics.Unlock()
+	})
+	return arn
+}
+
+func TestConfirmSubscription_Lifecycle(t *testing.T) {
+	topicArn := addConfirmationTopic(t, "ConfirmTopic")
+	ts, received := confirmationEndpoint(t)
+
+	sub := subscribeHTTP(t, topicArn, ts.URL)
+	confirmation := receive(t, received, "SubscriptionConfirmation")
+	assert.Equal(t, topicArn, confirmation.TopicArn)
+	assert.Contains(t, confirmation.SubscribeURL, "Token="+confirmation.Token)
+
+	// pending subscriptions are listed without their ARN and get no messages
+	rr := callSNS(ListSubscriptionsByTopic, url.Values{"TopicArn": {topicArn}}, true)
+	assert.Contains(t, rr.Body.String(), "<SubscriptionArn>PendingConfirmation</SubscriptionArn>")
+	rr = callSNS(GetTopicAttributes, url.Values{"TopicArn": {topicArn}}, true)
+	assert.Regexp(t, `<key>SubscriptionsPending</key>\s*<value>1</value>`, rr.Body.String())
+	callSNS(Publish, url.Values{"TopicArn": {topicArn}, "Message": {"too early"}}, true)
+	select {
+	case msg := <-received:
+		t.Fatalf("a pending subscription got %s", msg.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	rr = callSNS(ConfirmSubscription, url.Values{"TopicArn": {topicArn}, "Token": {"wrong"}}, true)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "<Message>Invalid token</Message>")
+
+	rr = callSNS(ConfirmSubscription, url.Values{"TopicArn": {topicArn}, "Token": {confirmation.Token}, "AuthenticateOnUnsubscribe": {"true"}}, true)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "<SubscriptionArn>"+sub.SubscriptionArn+"</SubscriptionArn>")
+	rr = callSNS(GetSubscriptionAttributes, url.Values{"SubscriptionArn": {sub.SubscriptionArn}}, true)
+	assert.Regexp(t, `<key>PendingConfirmation</key>\s*<value>false</value>`, rr.Body.String())
+	assert.Regexp(t, `<key>ConfirmationWasAuthenticated</key>\s*<value>true</value>`, rr.Body.String())
+
+	callSNS(Publish, url.Values{"TopicArn": {topicArn}, "Message": {"hello"}}, true)
+	assert.Equal(t, "hello", receive(t, received, "Notification").Message)
+
+	// AuthenticateOnUnsubscribe makes the UnsubscribeURL of messages useless
+	rr = callSNS(Unsubscribe, url.Values{"SubscriptionArn": {sub.SubscriptionArn}}, false)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = callSNS(Unsubscribe, url.Values{"SubscriptionArn": {sub.SubscriptionArn}}, true)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	unsubscribed := receive(t, received, "UnsubscribeConfirmation")
+
+	// the token of the UnsubscribeConfirmation restores the subscription
+	rr = callSNS(ConfirmSubscription, url.Values{"TopicArn": {topicArn}, "Token": {unsubscribed.Token}}, false)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = callSNS(ListSubscriptionsByTopic, url.Values{"TopicArn": {topicArn}}, true)
+	assert.Contains(t, rr.Body.String(), "<SubscriptionArn>"+sub.SubscriptionArn+"</SubscriptionArn>")
+}
+
+func TestConfirmSubscription_ConcurrentSubscriptions(t *testing.T) {
+	topicArn := addConfirmationTopic(t, "ConcurrentTopic")
+	ts1, received1 := confirmationEndpoint(t)
+	ts2, received2 := confirmationEndpoint(t)
+
+	sub1 := subscribeHTTP(t, topicArn, ts1.URL)
+	sub2 := subscribeHTTP(t, topicArn, ts2.URL)
+	token1 := receive(t, received1, "SubscriptionConfirmation").Token
+	receive(t, received2, "SubscriptionConfirmation")
+
+	// visiting the SubscribeURL isn't authenticated
+	rr := callSNS(ConfirmSubscription, url.Values{"TopicArn": {topicArn}, "Token": {token1}}, false)
+	assert.Contains(t, rr.Body.String(), "<SubscriptionArn>"+sub1.SubscriptionArn+"</SubscriptionArn>")
+	assert.False(t, sub1.PendingConfirmation)
+	assert.False(t, sub1.ConfirmationWasAuthenticated)
+	assert.True(t, sub2.PendingConfirmation)
+}
+
+func TestConfirmSubscription_TokensExpire(t *testing.T) {
+	app.SetClock(app.NewManualClock(time.Now()))
+	defer app.SetClock(nil)
+	topicArn := addConfirmationTopic(t, "ExpiringTopic")
+	ts, received := confirmationEndpoint(t)
+
+	subscribeHTTP(t, topicArn, ts.URL)
+	token := receive(t, received, "SubscriptionConfirmation").Token
+
+	app.AdvanceClock(ConfirmationTTL + time.Second)
+	rr := callSNS(ConfirmSubscription, url.Values{"TopicArn": {topicArn}, "Token": {token}}, true)
+	assert.Contains(t, rr.Body.String(), "<Message>Invalid token</Message>")
+	assert.Empty(t, app.SyncTopics.Topics["ExpiringTopic"].Subscriptions)
+}
+
+func TestConfirmSubscription_ExpiringKeepsUnchangedSubscriptions(t *testing.T) {
+	topicArn := addConfirmationTopic(t, "UnchangedTopic")
+	app.SyncTopics.Lock()
+	app.SyncTopics.Topics["UnchangedTopic"].Subscriptions = []*app.Subscription{
+		{TopicArn: topicArn, Protocol: "sqs", SubscriptionArn: topicArn + ":sqs", EndPoint: "arn:aws:sqs:local:000000000000:unchanged"},
+	}
+	before := app.SyncTopics.Topics["UnchangedTopic"].Subscriptions
+	app.SyncTopics.Unlock()
+
+	expireConfirmations()
+	assert.Same(t, &before[0], &app.SyncTopics.Topics["UnchangedTopic"].Subscriptions[0])
+}
+
+func TestConfirmSubscription_PublishWhileSubscriptionsChange(t *testing.T) {
+	topicArn := addConfirmationTopic(t, "ChangingTopic")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		endpoint := "arn:aws:sqs:local:000000000000:changing-" + strconv.Itoa(i)
+		go func() {
+			defer wg.Done()
+			rr := callSNS(Subscribe, url.Values{"TopicArn": {topicArn}, "Protocol": {"sqs"}, "Endpoint": {endpoint}}, true)
+			assert.Equal(t, http.StatusOK, rr.Code)
+			callSNS(Unsubscribe, url.Values{"SubscriptionArn": {topicArn + ":unknown"}}, true)
+		}()
+		go func() {
+			defer wg.Done()
+			rr := callSNS(Publish, url.Values{"TopicArn": {topicArn}, "Message": {"hello"}}, true)
+			assert.Equal(t, http.StatusOK, rr.Code)
+		}()
+		go func() {
+			defer wg.Done()
+			expireConfirmations()
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, app.SyncTopics.Topics["ChangingTopic"].Subscriptions, 4)
+}
@@ -1,6 +1,7 @@
 package gosns
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
@@ -11,34 +12,16 @@ import (
 	"time"
 
 	"bytes"
-	"crypto"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha1"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/base64"
-	"encoding/pem"
 	"io/ioutil"
-	"math/big"
 
 	"github.com/Admiral-Piett/goaws/app"
 	"github.com/Admiral-Piett/goaws/app/common"
+	"github.com/Admiral-Piett/goaws/app/metrics"
 	log "github.com/sirupsen/logrus"
 )
 
-type pendingConfirm struct {
-	subArn string
-	token  string
-}
-
-var PemKEY []byte
-var PrivateKEY *rsa.PrivateKey
-var TOPIC_DATA map[string]*pendingConfirm
-
 func init() {
 	app.SyncTopics.Topics = make(map[string]*app.Topic)
-	TOPIC_DATA = make(map[string]*pendingConfirm)
 
 	app.SnsErrors = make(map[string]app.SnsErrorType)
 	err1 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "Not Found", Code: "AWS.SimpleNotificationService.NonExistentTopic", Message: "The specified topic does not exist for this wsdl version."}
@@ -49,45 +32,52 @@ func init() {
 	app.SnsErrors["TopicExists"] = err3
 	err4 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameter", Code: "AWS.SimpleNotificationService.ValidationError", Message: "The input fails to satisfy the constraints specified by an AWS service."}
 	app.SnsErrors["ValidationError"] = err4
-	PrivateKEY, PemKEY, _ = createPemFile()
-}
-
-func createPemFile() (privkey *rsa.PrivateKey, pemkey []byte, err error) {
-	template := &x509.Certificate{
-		IsCA:                  true,
-		BasicConstraintsValid: true,
-		SubjectKeyId:          []byte{11, 22, 33},
-		SerialNumber:          big.NewInt(1111),
-		Subject: pkix.Name{
-			Country:      []string{"USA"},
-			Organization: []string{"Amazon"},
-		},
-		NotBefore:   time.Now(),
-		NotAfter:    time.Now().Add(time.Duration(5) * time.Second),
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
-	}
-
-	// generate private key
-	privkey, err = rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return
-	}
-
-	// create a self-signed certificate
-	parent := template
-	cert, err := x509.CreateCertificate(rand.Reader, template, parent, &privkey.PublicKey, privkey)
-	if err != nil {
-		return
-	}
-
-	pemkey = pem.EncodeToMemory(
-		&pem.Block{
-			Type:  "CERTIFICATE",
-			Bytes: cert,
-		},
-	)
-	return
+	err5 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameter", Code: "InvalidParameter", Message: "Invalid parameter: Attributes."}
+	app.SnsErrors["InvalidParameter"] = err5
+	err6 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "KMSNotFound", Code: "KMSNotFound", Message: "The KMS key used to encrypt the topic does not exist."}
+	app.SnsErrors["KMSNotFound"] = err6
+	err7 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "KMSAccessDenied", Code: "KMSAccessDenied", Message: "The KMS key policy does not allow SNS to use the key."}
+	app.SnsErrors["KMSAccessDenied"] = err7
+	err8 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameter", Code: "InvalidParameter", Message: "Invalid token"}
+	app.SnsErrors["InvalidToken"] = err8
+	err9 := app.SnsErrorType{HttpError: http.StatusForbidden, Type: "AuthorizationError", Code: "AuthorizationError", Message: "The subscription can only be deleted by an authenticated request."}
+	app.SnsErrors["AuthorizationError"] = err9
+	err10 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameter", Code: "InvalidParameter", Message: "Invalid parameter: Endpoint"}
+	app.SnsErrors["InvalidEndpoint"] = err10
+	err11 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameter", Code: "InvalidParameter", Message: "Invalid parameter: PhoneNumber Reason: input incorrectly formatted"}
+	app.SnsErrors["InvalidPhoneNumber"] = err11
+	err12 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameter", Code: "InvalidParameter", Message: "Invalid parameter: TargetArn"}
+	app.SnsErrors["InvalidTargetArn"] = err12
+	err13 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameter", Code: "InvalidParameter", Message: app.ErrNoDefaultElementInJSON}
+	app.SnsErrors["InvalidMessageStructure"] = err13
+	err14 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "EmptyBatchRequest", Code: "EmptyBatchRequest", Message: "The batch request doesn't contain any entries."}
+	app.SnsErrors["EmptyBatchRequest"] = err14
+	err15 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "TooManyEntriesInBatchRequest", Code: "TooManyEntriesInBatchRequest", Message: "The batch request contains more entries than permissible."}
+	app.SnsErrors["TooManyEntriesInBatchRequest"] = err15
+	err16 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "BatchEntryIdsNotDistinct", Code: "BatchEntryIdsNotDistinct", Message: "Two or more batch entries in the request have the same Id."}
+	app.SnsErrors["BatchEntryIdsNotDistinct"] = err16
+	err17 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidBatchEntryId", Code: "InvalidBatchEntryId", Message: "The Id of a batch entry in a batch request doesn't abide by the specification."}
+	app.SnsErrors["InvalidBatchEntryId"] = err17
+	err18 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "BatchRequestTooLong", Code: "BatchRequestTooLong", Message: "The length of all the batch messages put together is more than the limit."}
+	app.SnsErrors["BatchRequestTooLong"] = err18
+	err19 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameter", Code: "InvalidParameter", Message: "Invalid parameter: Empty message"}
+	app.SnsErrors["EmptyMessage"] = err19
+	err20 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameter", Code: "InvalidParameter", Message: "Invalid parameter: Message too long"}
+	app.SnsErrors["MessageTooLong"] = err20
+	err21 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameter", Code: "InvalidParameter", Message: "Invalid parameter: Subject"}
+	app.SnsErrors["InvalidSubject"] = err21
+	err22 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameterValue", Code: "InvalidParameterValue", Message: "Number of message attributes exceeds the allowed maximum of 10."}
+	app.SnsErrors["TooManyMessageAttributes"] = err22
+	err23 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameterValue", Code: "InvalidParameterValue", Message: "The message attribute has an invalid message attribute type, the set of supported type prefixes is Binary, Number, and String."}
+	app.SnsErrors["InvalidMessageAttributeType"] = err23
+	err24 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameterValue", Code: "InvalidParameterValue", Message: "The message attribute with type 'Number' must contain a numeric value."}
+	app.SnsErrors["InvalidMessageAttributeValue"] = err24
+	err25 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameter", Code: "InvalidParameter", Message: "Invalid parameter: Message Structure - JSON message body failed to parse"}
+	app.SnsErrors["InvalidMessageStructureJSON"] = err25
+	err26 := app.SnsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidParameter", Code: "InvalidParameter", Message: "Invalid parameter: MessageStructure"}
+	app.SnsErrors["InvalidMessageStructureValue"] = err26
+	LoadSigningCertificate("", "")
+	app.OnClockAdvance(expireConfirmations)
 }
 
 func ListTopics(w http.ResponseWriter, req *http.Request) {
@@ -115,11 +105,18 @@ func CreateTopic(w http.ResponseWriter, req *http.Request) {
 	if _, ok := app.SyncTopics.Topics[topicName]; ok {
 		topicArn = app.SyncTopics.Topics[topicName].Arn
 	} else {
-		topicArn = "arn:aws:sns:" + app.CurrentEnvironment.Region + ":" + app.CurrentEnvironment.AccountID + ":" + topicName
+		env := app.GetCurrentEnvironment()
+		topicArn = "arn:aws:sns:" + env.Region + ":" + env.AccountID + ":" + topicName
 
 		log.Println("Creating Topic:", topicName)
 		topic := &app.Topic{Name: topicName, Arn: topicArn}
 		topic.Subscriptions = make([]*app.Subscription, 0, 0)
+		for name, value := range extractTopicAttributes(req) {
+			if errName := setTopicAttribute(topic, name, value); errName != "" {
+				createErrorResponse(w, req, errName)
+				return
+			}
+		}
 		app.SyncTopics.Lock()
 		app.SyncTopics.Topics[topicName] = topic
 		app.SyncTopics.Unlock()
@@ -129,6 +126,60 @@ func CreateTopic(w http.ResponseWriter, req *http.Request) {
 	SendResponseBack(w, req, respStruct, content)
 }
 
+func GetTopicAttributes(w http.ResponseWriter, req *http.Request) {
+	content := req.FormValue("ContentType")
+	topicArn := req.FormValue("TopicArn")
+
+	uriSegments := strings.Split(topicArn, ":")
+	topicName := uriSegments[len(uriSegments)-1]
+
+	app.SyncTopics.RLock()
+	topic, ok := app.SyncTopics.Topics[topicName]
+	if !ok {
+		app.SyncTopics.RUnlock()
+		createErrorResponse(w, req, "TopicNotFound")
+		return
+	}
+	entries := getTopicAttributes(topic)
+	app.SyncTopics.RUnlock()
+
+	uuid, _ := common.NewUUID()
+	respStruct := app.GetTopicAttributesResponse{
+		Xmlns:    "http://sns.amazonaws.com/doc/2010-03-31/",
+		Result:   app.GetTopicAttributesResult{Attributes: app.TopicAttributes{Entries: entries}},
+		Metadata: app.ResponseMetadata{RequestId: uuid},
+	}
+	SendResponseBack(w, req, respStruct, content)
+}
+
+func SetTopicAttributes(w http.ResponseWriter, req *http.Request) {
+	content := req.FormValue("ContentType")
+	topicArn := req.FormValue("TopicArn")
+	attributeName := req.FormValue("AttributeName")
+	attributeValue := req.FormValue("AttributeValue")
+
+	uriSegments := strings.Split(topicArn, ":")
+	topicName := uriSegments[len(uriSegments)-1]
+
+	app.SyncTopics.Lock()
+	topic, ok := app.SyncTopics.Topics[topicName]
+	if !ok {
+		app.SyncTopics.Unlock()
+		createErrorResponse(w, req, "TopicNotFound")
+		return
+	}
+	errName := setTopicAttribute(topic, attributeName, attributeValue)
+	app.SyncTopics.Unlock()
+	if errName != "" {
+		createErrorResponse(w, req, errName)
+		return
+	}
+
+	uuid, _ := common.NewUUID()
+	respStruct := app.SetTopicAttributesResponse{Xmlns: "http://sns.amazonaws.com/doc/2010-03-31/", Metadata: app.ResponseMetadata{RequestId: uuid}}
+	SendResponseBack(w, req, respStruct, content)
+}
+
 // aws --endpoint-url http://localhost:47194 sns subscribe --topic-arn arn:aws:sns:us-west-2:0123456789012:my-topic --protocol email --notification-endpoint my-email@example.com
 func Subscribe(w http.ResponseWriter, req *http.Request) {
 	content := req.FormValue("ContentType")
@@ -148,6 +199,11 @@ func Subscribe(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	if !validEndpoint(app.Protocol(protocol), endpoint) {
+		createErrorResponse(w, req, "InvalidEndpoint")
+		return
+	}
+
 	uriSegments := strings.Split(topicArn, ":")
 	topicName := uriSegments[len(uriSegments)-1]
 	log.WithFields(log.Fields{
@@ -165,83 +221,78 @@ func Subscribe(w http.ResponseWriter, req *http.Request) {
 	subArn = topicArn + ":" + subArn
 	subscription.SubscriptionArn = subArn
 
-	if app.SyncTopics.Topics[topicName] != nil {
-		app.SyncTopics.Lock()
+	// http, https and email subscriptions wait for their endpoint to confirm them
+	switch app.Protocol(protocol) {
+	case app.ProtocolHTTP, app.ProtocolHTTPS, app.ProtocolEmail, app.ProtocolEmailJSON:
+		subscription.PendingConfirmation = true
+	default:
+		subscription.ConfirmationWasAuthenticated = true
+	}
+
+	app.SyncTopics.Lock()
+	if topic := app.SyncTopics.Topics[topicName]; topic != nil {
 		isDuplicate := false
 		// Duplicate check
-		for _, subscription := range app.SyncTopics.Topics[topicName].Subscriptions {
-			if subscription.EndPoint == endpoint && subscription.TopicArn == topicArn {
+		for _, existing := range topic.Subscriptions {
+			if existing.EndPoint == endpoint && existing.TopicArn == topicArn {
 				isDuplicate = true
-				subArn = subscription.SubscriptionArn
+				subscription = existing
+				subArn = existing.SubscriptionArn
 			}
 		}
 		if !isDuplicate {
-			app.SyncTopics.Topics[topicName].Subscriptions = append(app.SyncTopics.Topics[topicName].Subscriptions, subscription)
+			topic.Subscriptions = append(topic.Subscriptions, subscription)
 			log.WithFields(log.Fields{
 				"topic":    topicName,
 				"endpoint": endpoint,
 				"topicArn": topicArn,
 			}).Debug("Created subscription")
 		}
+		pending := subscription.PendingConfirmation
+		token := ""
+		if pending {
+			token = addConfirmation(subscription)
+		}
 		app.SyncTopics.Unlock()
 
 		//Create the response
 		uuid, _ := common.NewUUID()
-		if app.Protocol(subscription.Protocol) == app.ProtocolHTTP || app.Protocol(subscription.Protocol) == app.ProtocolHTTPS {
-			id, _ := common.NewUUID()
-			token, _ := common.NewUUID()
-
-			TOPIC_DATA[topicArn] = &pendingConfirm{
-				subArn: subArn,
-				token:  token,
-			}
+		if pending && req.FormValue("ReturnSubscriptionArn") != "true" {
+			subArn = "pending confirmation"
+		}
+		respStruct := app.SubscribeResponse{"http://queue.amazonaws.com/doc/2012-11-05/", app.SubscribeResult{SubscriptionArn: subArn}, app.ResponseMetadata{RequestId: uuid}}
+		SendResponseBack(w, req, respStruct, content)
 
-			respStruct := app.SubscribeResponse{"http://queue.amazonaws.com/doc/2012-11-05/", app.SubscribeResult{SubscriptionArn: subArn}, app.ResponseMetadata{RequestId: uuid}}
-			SendResponseBack(w, req, respStruct, content)
-			time.Sleep(time.Second)
-
-			snsMSG := &app.SNSMessage{
-				Type:             "SubscriptionConfirmation",
-				MessageId:        id,
-				Token:            token,
-				TopicArn:         topicArn,
-				Message:          "You have chosen to subscribe to the topic " + topicArn + ".\nTo confirm the subscription, visit the SubscribeURL included in this message.",
-				SigningCertURL:   "http://" + app.CurrentEnvironment.Host + ":" + app.CurrentEnvironment.Port + "/SimpleNotificationService/" + uuid + ".pem",
-				SignatureVersion: "1",
-				SubscribeURL:     "http://" + app.CurrentEnvironment.Host + ":" + app.CurrentEnvironment.Port + "/?Action=ConfirmSubscription&TopicArn=" + topicArn + "&Token=" + token,
-				Timestamp:        time.Now().UTC().Format(time.RFC3339),
-			}
-			signature, err := signMessage(PrivateKEY, snsMSG)
-			if err != nil {
-				log.Error("Error signing message")
-			} else {
-				snsMSG.Signature = signature
-			}
-			err = callEndpoint(subscription.EndPoint, uuid, *snsMSG, subscription.Raw)
-			if err != nil {
-				log.Error("Error posting to url ", err)
+		if pending {
+			if !app.IsOutboxProtocol(app.Protocol(protocol)) {
+				time.Sleep(time.Second)
 			}
-		} else {
-			respStruct := app.SubscribeResponse{"http://queue.amazonaws.com/doc/2012-11-05/", app.SubscribeResult{SubscriptionArn: subArn}, app.ResponseMetadata{RequestId: uuid}}
-
-			SendResponseBack(w, req, respStruct, content)
+			sendConfirmation("SubscriptionConfirmation", subscription, token)
 		}
-
 	} else {
+		app.SyncTopics.Unlock()
 		createErrorResponse(w, req, "TopicNotFound")
 	}
 }
 
-func signMessage(privkey *rsa.PrivateKey, snsMsg *app.SNSMessage) (string, error) {
-	fs, err := formatSignature(snsMsg)
-	if err != nil {
-		return "", nil
+// confirmationMessage returns a signed SubscriptionConfirmation or
+// UnsubscribeConfirmation message whose SubscribeURL confirms a subscription.
+func confirmationMessage(msgType string, topicArn string, token string, message string) app.SNSMessage {
+	id, _ := common.NewUUID()
+	msg := app.SNSMessage{
+		Type:             msgType,
+		MessageId:        id,
+		Token:            token,
+		TopicArn:         topicArn,
+		Message:          message,
+		SignatureVersion: signatureVersion(topicArn),
+		SubscribeURL:     app.BaseURL() + "/?Action=ConfirmSubscription&TopicArn=" + topicArn + "&Token=" + token,
+		Timestamp:        app.Now().UTC().Format(time.RFC3339),
 	}
-
-	h := sha1.Sum([]byte(fs))
-	signature_b, err := rsa.SignPKCS1v15(rand.Reader, privkey, crypto.SHA1, h[:])
-
-	return base64.StdEncoding.EncodeToString(signature_b), err
+	if err := signMessage(&msg); err != nil {
+		log.Error("Error signing message ", err)
+	}
+	return msg
 }
 
 func formatSignature(msg *app.SNSMessage) (formated string, err error) {
@@ -279,19 +330,55 @@ func formatSignature(msg *app.SNSMessage) (formated string, err error) {
 	return
 }
 
+// ConfirmSubscription confirms a subscription with the token of its
+// SubscriptionConfirmation message, or restores it with the token of its
+// UnsubscribeConfirmation message.
 func ConfirmSubscription(w http.ResponseWriter, req *http.Request) {
-	topicArn := req.Form.Get("TopicArn")
-	confirmToken := req.Form.Get("Token")
-	pendingConfirm := TOPIC_DATA[topicArn]
-	if pendingConfirm.token == confirmToken {
-		uuid, _ := common.NewUUID()
-		respStruct := app.ConfirmSubscriptionResponse{"http://queue.amazonaws.com/doc/2012-11-05/", app.SubscribeResult{SubscriptionArn: pendingConfirm.subArn}, app.ResponseMetadata{RequestId: uuid}}
+	topicArn := req.FormValue("TopicArn")
+	confirmToken := req.FormValue("Token")
 
-		SendResponseBack(w, req, respStruct, "application/xml")
-	} else {
-		createErrorResponse(w, req, "SubArnNotFound")
+	uriSegments := strings.Split(topicArn, ":")
+	topicName := uriSegments[len(uriSegments)-1]
+
+	app.SyncTopics.Lock()
+	topic, ok := app.SyncTopics.Topics[topicName]
+	if !ok {
+		app.SyncTopics.Unlock()
+		createErrorResponse(w, req, "TopicNotFound")
+		return
+	}
+	pending, ok := confirmations[confirmToken]
+	if !ok || pending.subscription.TopicArn != topic.Arn || app.Now().After(pending.expires) {
+		app.SyncTopics.Unlock()
+		createErrorResponse(w, req, "InvalidToken")
+		return
+	}
+	sub := pending.subscription
+	restored := true
+	for _, existing := range topic.Subscriptions {
+		if existing == sub {
+			restored = false
+		}
 	}
+	if restored {
+		topic.Subscriptions = append(topic.Subscriptions, sub)
+	}
+	if sub.PendingConfirmation {
+		sub.PendingConfirmation = false
+		sub.ConfirmationWasAuthenticated = authenticated(req)
+		sub.AuthenticateOnUnsubscribe = authenticated(req) && req.FormValue("AuthenticateOnUnsubscribe") == "true"
+	}
+	subArn := sub.SubscriptionArn
+	app.SyncTopics.Unlock()
 
+	log.WithFields(log.Fields{
+		"topicArn": topicArn,
+		"endpoint": sub.EndPoint,
+		"restored": restored,
+	}).Info("Confirmed Subscription")
+	uuid, _ := common.NewUUID()
+	respStruct := app.ConfirmSubscriptionResponse{"http://queue.amazonaws.com/doc/2012-11-05/", app.SubscribeResult{SubscriptionArn: subArn}, app.ResponseMetadata{RequestId: uuid}}
+	SendResponseBack(w, req, respStruct, "application/xml")
 }
 
 func ListSubscriptions(w http.ResponseWriter, req *http.Request) {
@@ -306,7 +393,7 @@ func ListSubscriptions(w http.ResponseWriter, req *http.Request) {
 	for _, topic := range app.SyncTopics.Topics {
 		for _, sub := range topic.Subscriptions {
 			tar := app.TopicMemberResult{TopicArn: topic.Arn, Protocol: sub.Protocol,
-				SubscriptionArn: sub.SubscriptionArn, Endpoint: sub.EndPoint, Owner: app.CurrentEnvironment.AccountID}
+				SubscriptionArn: listedSubscriptionArn(sub), Endpoint: sub.EndPoint, Owner: app.GetCurrentEnvironment().AccountID}
 			respStruct.Result.Subscriptions.Member = append(respStruct.Result.Subscriptions.Member, tar)
 		}
 	}
@@ -330,7 +417,7 @@ func ListSubscriptionsByTopic(w http.ResponseWriter, req *http.Request) {
 
 		for _, sub := range topic.Subscriptions {
 			tar := app.TopicMemberResult{TopicArn: topic.Arn, Protocol: sub.Protocol,
-				SubscriptionArn: sub.SubscriptionArn, Endpoint: sub.EndPoint, Owner: app.CurrentEnvironment.AccountID}
+				SubscriptionArn: listedSubscriptionArn(sub), Endpoint: sub.EndPoint, Owner: app.GetCurrentEnvironment().AccountID}
 			respStruct.Result.Subscriptions.Member = append(respStruct.Result.Subscriptions.Member, tar)
 		}
 		SendResponseBack(w, req, respStruct, content)
@@ -339,6 +426,15 @@ func ListSubscriptionsByTopic(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// listedSubscriptionArn returns the SubscriptionArn the list actions show, which
+// is "PendingConfirmation" until the subscription is confirmed.
+func listedSubscriptionArn(sub *app.Subscription) string {
+	if sub.PendingConfirmation {
+		return "PendingConfirmation"
+	}
+	return sub.SubscriptionArn
+}
+
 func SetSubscriptionAttributes(w http.ResponseWriter, req *http.Request) {
 	content := req.FormValue("ContentType")
 	subsArn := req.FormValue("SubscriptionArn")
@@ -382,6 +478,23 @@ func SetSubscriptionAttributes(w http.ResponseWriter, req *http.Request) {
 					return
 				}
 
+				if Attribute == "DeliveryPolicy" {
+					if Value != "" && !common.IsJSONObject(Value) {
+						createErrorResponse(w, req, "ValidationError")
+						return
+					}
+
+					app.SyncTopics.Lock()
+					sub.DeliveryPolicy = Value
+					app.SyncTopics.Unlock()
+
+					//Good Response == return
+					uuid, _ := common.NewUUID()
+					respStruct := app.SetSubscriptionAttributesResponse{Xmlns: "http://queue.amazonaws.com/doc/2012-11-05/", Metadata: app.ResponseMetadata{RequestId: uuid}}
+					SendResponseBack(w, req, respStruct, content)
+					return
+				}
+
 			}
 		}
 	}
@@ -398,7 +511,7 @@ func GetSubscriptionAttributes(w http.ResponseWriter, req *http.Request) {
 			if sub.SubscriptionArn == subsArn {
 
 				entries := make([]app.SubscriptionAttributeEntry, 0, 0)
-				entry := app.SubscriptionAttributeEntry{Key: "Owner", Value: app.CurrentEnvironment.AccountID}
+				entry := app.SubscriptionAttributeEntry{Key: "Owner", Value: app.GetCurrentEnvironment().AccountID}
 				entries = append(entries, entry)
 				entry = app.SubscriptionAttributeEntry{Key: "RawMessageDelivery", Value: strconv.FormatBool(sub.Raw)}
 				entries = append(entries, entry)
@@ -406,9 +519,9 @@ func GetSubscriptionAttributes(w http.ResponseWriter, req *http.Request) {
 				entries = append(entries, entry)
 				entry = app.SubscriptionAttributeEntry{Key: "Endpoint", Value: sub.EndPoint}
 				entries = append(entries, entry)
-				entry = app.SubscriptionAttributeEntry{Key: "PendingConfirmation", Value: "false"}
+				entry = app.SubscriptionAttributeEntry{Key: "PendingConfirmation", Value: strconv.FormatBool(sub.PendingConfirmation)}
 				entries = append(entries, entry)
-				entry = app.SubscriptionAttributeEntry{Key: "ConfirmationWasAuthenticated", Value: "true"}
+				entry = app.SubscriptionAttributeEntry{Key: "ConfirmationWasAuthenticated", Value: strconv.FormatBool(sub.ConfirmationWasAuthenticated)}
 				entries = append(entries, entry)
 				entry = app.SubscriptionAttributeEntry{Key: "SubscriptionArn", Value: sub.SubscriptionArn}
 				entries = append(entries, entry)
@@ -423,6 +536,11 @@ func GetSubscriptionAttributes(w http.ResponseWriter, req *http.Request) {
 					entries = append(entries, entry)
 				}
 
+				if sub.DeliveryPolicy != "" {
+					entry = app.SubscriptionAttributeEntry{Key: "DeliveryPolicy", Value: sub.DeliveryPolicy}
+					entries = append(entries, entry)
+				}
+
 				result := app.GetSubscriptionAttributesResult{SubscriptionAttributes: app.SubscriptionAttributes{Entries: entries}}
 				uuid, _ := common.NewUUID()
 				respStruct := app.GetSubscriptionAttributesResponse{"http://sns.amazonaws.com/doc/2010-03-31", result, app.ResponseMetadata{RequestId: uuid}}
@@ -441,24 +559,40 @@ func Unsubscribe(w http.ResponseWriter, req *http.Request) {
 	subArn := req.FormValue("SubscriptionArn")
 
 	log.Println("Unsubscribe:", subArn)
+	app.SyncTopics.Lock()
 	for _, topic := range app.SyncTopics.Topics {
 		for i, sub := range topic.Subscriptions {
 			if sub.SubscriptionArn == subArn {
-				app.SyncTopics.Lock()
+				if sub.AuthenticateOnUnsubscribe && !authenticated(req) {
+					app.SyncTopics.Unlock()
+					createErrorResponse(w, req, "AuthorizationError")
+					return
+				}
 
 				copy(topic.Subscriptions[i:], topic.Subscriptions[i+1:])
 				topic.Subscriptions[len(topic.Subscriptions)-1] = nil
 				topic.Subscriptions = topic.Subscriptions[:len(topic.Subscriptions)-1]
 
+				// confirmed http and https subscriptions can be restored with the
+				// token of their UnsubscribeConfirmation message
+				token := ""
+				if !sub.PendingConfirmation && (app.Protocol(sub.Protocol) == app.ProtocolHTTP || app.Protocol(sub.Protocol) == app.ProtocolHTTPS) {
+					token = addConfirmation(sub)
+				}
 				app.SyncTopics.Unlock()
 
 				uuid, _ := common.NewUUID()
 				respStruct := app.UnsubscribeResponse{"http://queue.amazonaws.com/doc/2012-11-05/", app.ResponseMetadata{RequestId: uuid}}
 				SendResponseBack(w, req, respStruct, content)
+
+				if token != "" {
+					sendConfirmation("UnsubscribeConfirmation", sub, token)
+				}
 				return
 			}
 		}
 	}
+	app.SyncTopics.Unlock()
 	createErrorResponse(w, req, "SubscriptionNotFound")
 }
 
@@ -489,50 +623,233 @@ func DeleteTopic(w http.ResponseWriter, req *http.Request) {
 func Publish(w http.ResponseWriter, req *http.Request) {
 	content := req.FormValue("ContentType")
 	topicArn := req.FormValue("TopicArn")
-	subject := req.FormValue("Subject")
-	messageBody := req.FormValue("Message")
-	messageStructure := req.FormValue("MessageStructure")
-	messageAttributes := getMessageAttributesFromRequest(req)
-
-	arnSegments := strings.Split(topicArn, ":")
-	topicName := arnSegments[len(arnSegments)-1]
+	entry := publishEntry{
+		Message:                req.FormValue("Message"),
+		Subject:                req.FormValue("Subject"),
+		MessageStructure:       req.FormValue("MessageStructure"),
+		MessageAttributes:      getMessageAttributesFromRequest(req, "MessageAttributes"),
+		MessageGroupId:         req.FormValue("MessageGroupId"),
+		MessageDeduplicationId: req.FormValue("MessageDeduplicationId"),
+	}
+	entry.MessageId, _ = common.NewUUID()
+
+	// messages can be published to a phone number or a mobile application
+	// endpoint directly, and TargetArn can be a topic too
+	targetArn := req.FormValue("TargetArn")
+	phoneNumber := req.FormValue("PhoneNumber")
+	if phoneNumber != "" && !validEndpoint(app.ProtocolSMS, phoneNumber) {
+		createErrorResponse(w, req, "InvalidPhoneNumber")
+		return
+	}
+	if targetArn != "" && !isEndpointArn(targetArn) && !strings.HasPrefix(targetArn, "arn:aws:sns:") {
+		createErrorResponse(w, req, "InvalidTargetArn")
+		return
+	}
+	if errName := validatePublish(entry); errName != "" {
+		createErrorResponse(w, req, errName)
+		return
+	}
 
-	_, ok := app.SyncTopics.Topics[topicName]
-	if ok {
-		log.WithFields(log.Fields{
-			"topic":    topicName,
-			"topicArn": topicArn,
-			"subject":  subject,
-		}).Debug("Publish to Topic")
-		for _, subs := range app.SyncTopics.Topics[topicName].Subscriptions {
-			switch app.Protocol(subs.Protocol) {
-			case app.ProtocolSQS:
-				publishSQS(w, req, subs, messageBody, messageAttributes, subject, topicArn, topicName, messageStructure)
-			case app.ProtocolHTTP:
-				fallthrough
-			case app.ProtocolHTTPS:
-				publishHTTP(subs, messageBody, messageAttributes, subject, topicArn)
-			}
-		}
+	if phoneNumber != "" {
+		publishDirect(app.ProtocolSMS, phoneNumber, entry)
+	} else if isEndpointArn(targetArn) {
+		publishDirect(app.ProtocolApplication, targetArn, entry)
 	} else {
-		createErrorResponse(w, req, "TopicNotFound")
-		return
+		if targetArn != "" {
+			topicArn = targetArn
+		}
+		arnSegments := strings.Split(topicArn, ":")
+		topicName := arnSegments[len(arnSegments)-1]
+
+		app.SyncTopics.RLock()
+		topic, ok := app.SyncTopics.Topics[topicName]
+		var kmsMasterKeyId string
+		if ok {
+			kmsMasterKeyId = topic.KmsMasterKeyId
+		}
+		app.SyncTopics.RUnlock()
+		if !ok {
+			createErrorResponse(w, req, "TopicNotFound")
+			return
+		}
+		if errName := checkTopicKmsKey(kmsMasterKeyId); errName != "" {
+			createErrorResponse(w, req, errName)
+			return
+		}
+		publishToTopic(req.Context(), topicArn, topicName, entry)
 	}
 
 	//Create the response
-	msgId, _ := common.NewUUID()
 	uuid, _ := common.NewUUID()
-	respStruct := app.PublishResponse{"http://queue.amazonaws.com/doc/2012-11-05/", app.PublishResult{MessageId: msgId}, app.ResponseMetadata{RequestId: uuid}}
+	respStruct := app.PublishResponse{"http://queue.amazonaws.com/doc/2012-11-05/", app.PublishResult{MessageId: entry.MessageId}, app.ResponseMetadata{RequestId: uuid}}
 	SendResponseBack(w, req, respStruct, content)
 }
 
-func publishSQS(w http.ResponseWriter, req *http.Request,
-	subs *app.Subscription, messageBody string, messageAttributes map[string]app.MessageAttributeValue,
-	subject string, topicArn string, topicName string, messageStructure string) {
-	if subs.FilterPolicy != nil && !subs.FilterPolicy.IsSatisfiedBy(messageAttributes) {
-		return
+// publishDirect records a message published to a phone number or mobile
+// application endpoint rather than a topic in the outbox.
+func publishDirect(protocol app.Protocol, endpoint string, entry publishEntry) {
+	published := newPublishedMessage(entry, "")
+	delivery := app.Delivery{Protocol: string(protocol), EndPoint: endpoint, Status: app.DeliveryDelivered}
+	if msg, err := outboxMessage(protocol, endpoint, "", entry, ""); err != nil {
+		log.Error(err)
+		delivery.Status, delivery.Error = app.DeliveryFailed, err.Error()
+	} else {
+		recordOutboxMessage(msg)
 	}
+	published.Deliveries = append(published.Deliveries, delivery)
+	recordPublishedMessage(published)
+}
+
+// publishEntry is a message published by Publish or PublishBatch.
+type publishEntry struct {
+	MessageId              string // the MessageId every subscriber gets
+	Message                string
+	Subject                string
+	MessageStructure       string
+	MessageAttributes      map[string]app.MessageAttributeValue
+	MessageGroupId         string
+	MessageDeduplicationId string
+}
+
+const (
+	// maxMessageSize is the maximum size of a message, attributes included.
+	maxMessageSize = 262144 // 256K
+	// maxMessageAttributes is the maximum number of attributes of a message.
+	maxMessageAttributes = 10
+)
 
+// validatePublish checks a message before it is delivered to any subscriber, and
+// returns the name of the SnsErrors entry describing the first problem found, or
+// "" if it is valid.
+func validatePublish(entry publishEntry) string {
+	if entry.Message == "" {
+		return "EmptyMessage"
+	}
+	if common.MessageSize(entry.Message, entry.MessageAttributes) > maxMessageSize {
+		return "MessageTooLong"
+	}
+	if entry.Subject != "" && !validSubject(entry.Subject) {
+		return "InvalidSubject"
+	}
+	if len(entry.MessageAttributes) > maxMessageAttributes {
+		return "TooManyMessageAttributes"
+	}
+	for _, attr := range entry.MessageAttributes {
+		dataType, _, _ := strings.Cut(attr.DataType, ".")
+		switch {
+		case dataType != "String" && dataType != "Number" && dataType != "Binary":
+			return "InvalidMessageAttributeType"
+		case dataType == "Number":
+			if _, err := strconv.ParseFloat(attr.Value, 64); err != nil {
+				return "InvalidMessageAttributeValue"
+			}
+		}
+	}
+	switch app.MessageStructure(entry.MessageStructure) {
+	case "":
+	case app.MessageStructureJSON:
+		var messages map[string]string
+		if err := json.Unmarshal([]byte(entry.Message), &messages); err != nil {
+			return "InvalidMessageStructureJSON"
+		}
+		if _, ok := messages[string(app.ProtocolDefault)]; !ok {
+			return "InvalidMessageStructure"
+		}
+	default:
+		return "InvalidMessageStructureValue"
+	}
+	return ""
+}
+
+// validSubject tells whether a subject is ASCII text of at most 100 characters
+// that starts with a letter, number or punctuation mark and has no line breaks or
+// control characters.
+func validSubject(subject string) bool {
+	if len(subject) > 100 || subject[0] == ' ' {
+		return false
+	}
+	for i := 0; i < len(subject); i++ {
+		if subject[i] < ' ' || subject[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// publishToTopic delivers a valid message to the confirmed subscriptions of a topic.
+func publishToTopic(ctx context.Context, topicArn string, topicName string, entry publishEntry) {
+	metrics.IncPublishes(topicArn)
+	log.WithFields(log.Fields{
+		"topic":     topicName,
+		"topicArn":  topicArn,
+		"subject":   entry.Subject,
+		"messageId": entry.MessageId,
+	}).Debug("Publish to Topic")
+	published := newPublishedMessage(entry, topicArn)
+	for _, subs := range topicSubscriptions(topicName) {
+		if subs.PendingConfirmation {
+			continue
+		}
+		if subs.FilterPolicy != nil && !subs.FilterPolicy.IsSatisfiedBy(entry.MessageAttributes) {
+			published.Deliveries = append(published.Deliveries, newDelivery(subs, app.DeliveryFiltered, nil))
+			continue
+		}
+		var err error
+		switch app.Protocol(subs.Protocol) {
+		case app.ProtocolSQS:
+			err = publishSQS(subs, entry, topicArn, topicName)
+		case app.ProtocolHTTP:
+			fallthrough
+		case app.ProtocolHTTPS:
+			err = publishHTTP(subs, entry, topicArn)
+		case app.ProtocolLambda:
+			err = publishLambda(ctx, subs, entry, topicArn)
+		case app.ProtocolEmail, app.ProtocolEmailJSON, app.ProtocolSMS, app.ProtocolApplication:
+			err = publishOutbox(subs, entry, topicArn)
+		default:
+			continue
+		}
+		if err != nil {
+			published.Deliveries = append(published.Deliveries, newDelivery(subs, app.DeliveryFailed, err))
+		} else {
+			published.Deliveries = append(published.Deliveries, newDelivery(subs, app.DeliveryDelivered, nil))
+		}
+	}
+	recordPublishedMessage(published)
+}
+
+// topicSubscriptions returns copies of the subscriptions of a topic, which can be
+// delivered to while the topic's subscriptions change.
+func topicSubscriptions(topicName string) []*app.Subscription {
+	app.SyncTopics.RLock()
+	defer app.SyncTopics.RUnlock()
+	topic, ok := app.SyncTopics.Topics[topicName]
+	if !ok {
+		return nil
+	}
+	subscriptions := make([]*app.Subscription, 0, len(topic.Subscriptions))
+	for _, sub := range topic.Subscriptions {
+		copied := *sub
+		subscriptions = append(subscriptions, &copied)
+	}
+	return subscriptions
+}
+
+// protocolMessage returns the message of an entry for a protocol, which with
+// MessageStructure=json is the one for key or else the default one.
+func protocolMessage(entry publishEntry, key string) string {
+	if app.MessageStructure(entry.MessageStructure) != app.MessageStructureJSON {
+		return entry.Message
+	}
+	m, err := extractMessageFromJSON(entry.Message, key)
+	if err != nil {
+		// validatePublish makes sure there is a default message
+		log.Error(err)
+	}
+	return m
+}
+
+func publishSQS(subs *app.Subscription, entry publishEntry, topicArn string, topicName string) error {
 	endPoint := subs.EndPoint
 	uriSegments := strings.Split(endPoint, "/")
 	queueName := uriSegments[len(uriSegments)-1]
@@ -543,59 +860,55 @@ func publishSQS(w http.ResponseWriter, req *http.Request,
 		msg := app.Message{}
 
 		if subs.Raw == false {
-			m, err := CreateMessageBody(subs, messageBody, subject, messageStructure, messageAttributes)
+			m, err := createMessageBody(subs, entry.MessageId, entry.Message, entry.Subject, entry.MessageStructure, entry.MessageAttributes)
 			if err != nil {
-				createErrorResponse(w, req, err.Error())
-				return
+				log.Error(err)
+				return err
 			}
 
 			msg.MessageBody = m
 		} else {
-			msg.MessageAttributes = messageAttributes
-			msg.MD5OfMessageAttributes = common.HashAttributes(messageAttributes)
-			m, err := extractMessageFromJSON(messageBody, subs.Protocol)
-			if err == nil {
-				msg.MessageBody = []byte(m)
-			} else {
-				msg.MessageBody = []byte(messageBody)
+			// raw messages carry the SNS message attributes as SQS message attributes
+			msg.MessageBody = []byte(protocolMessage(entry, subs.Protocol))
+			if len(entry.MessageAttributes) > 0 {
+				msg.MessageAttributes = entry.MessageAttributes
+				msg.MD5OfMessageAttributes = common.HashAttributes(entry.MessageAttributes)
 			}
 		}
 
-		msg.MD5OfMessageBody = common.GetMD5Hash(messageBody)
+		msg.MD5OfMessageBody = common.GetMD5Hash(string(msg.MessageBody))
+		msg.GroupID = entry.MessageGroupId
+		msg.DeduplicationID = entry.MessageDeduplicationId
 		msg.Uuid, _ = common.NewUUID()
+		msg.SentTime = app.Now()
 		app.SyncQueues.Lock()
 		app.SyncQueues.Queues[queueName].Messages = append(app.SyncQueues.Queues[queueName].Messages, msg)
 		app.SyncQueues.Unlock()
 
 		log.Infof("%s: Topic: %s(%s), Message: %s\n", time.Now().Format("2006-01-02 15:04:05"), topicName, queueName, msg.MessageBody)
-	} else {
-		log.Infof("%s: Queue %s does not exist, message discarded\n", time.Now().Format("2006-01-02 15:04:05"), queueName)
+		return nil
 	}
+	log.Infof("%s: Queue %s does not exist, message discarded\n", time.Now().Format("2006-01-02 15:04:05"), queueName)
+	return fmt.Errorf("queue %s does not exist", queueName)
 }
 
-func publishHTTP(subs *app.Subscription, messageBody string, messageAttributes map[string]app.MessageAttributeValue,
-	subject string, topicArn string) {
-	id, _ := common.NewUUID()
+func publishHTTP(subs *app.Subscription, entry publishEntry, topicArn string) error {
 	msg := app.SNSMessage{
 		Type:              "Notification",
-		MessageId:         id,
+		MessageId:         entry.MessageId,
 		TopicArn:          topicArn,
-		Subject:           subject,
-		Message:           messageBody,
-		Timestamp:         time.Now().UTC().Format(time.RFC3339),
-		SignatureVersion:  "1",
-		SigningCertURL:    "http://" + app.CurrentEnvironment.Host + ":" + app.CurrentEnvironment.Port + "/SimpleNotificationService/" + id + ".pem",
-		UnsubscribeURL:    "http://" + app.CurrentEnvironment.Host + ":" + app.CurrentEnvironment.Port + "/?Action=Unsubscribe&SubscriptionArn=" + subs.SubscriptionArn,
-		MessageAttributes: formatAttributes(messageAttributes),
+		Subject:           entry.Subject,
+		Message:           protocolMessage(entry, subs.Protocol),
+		Timestamp:         app.Now().UTC().Format(time.RFC3339),
+		SignatureVersion:  signatureVersion(topicArn),
+		UnsubscribeURL:    app.BaseURL() + "/?Action=Unsubscribe&SubscriptionArn=" + subs.SubscriptionArn,
+		MessageAttributes: formatAttributes(entry.MessageAttributes),
 	}
 
-	signature, err := signMessage(PrivateKEY, &msg)
-	if err != nil {
+	if err := signMessage(&msg); err != nil {
 		log.Error(err)
-	} else {
-		msg.Signature = signature
 	}
-	err = callEndpoint(subs.EndPoint, subs.SubscriptionArn, msg, subs.Raw)
+	err := callEndpoint(subs.EndPoint, subs.SubscriptionArn, msg, subs.Raw)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"EndPoint": subs.EndPoint,
@@ -603,6 +916,7 @@ func publishHTTP(subs *app.Subscription, messageBody string, messageAttributes m
 			"error":    err.Error(),
 		}).Error("Error calling endpoint")
 	}
+	return err
 }
 
 func formatAttributes(values map[string]app.MessageAttributeValue) map[string]app.MsgAttr {
@@ -616,17 +930,25 @@ func formatAttributes(values map[string]app.MessageAttributeValue) map[string]ap
 	return attr
 }
 
-func callEndpoint(endpoint string, subArn string, msg app.SNSMessage, raw bool) error {
+func callEndpoint(endpoint string, subArn string, msg app.SNSMessage, raw bool) (err error) {
 	log.WithFields(log.Fields{
 		"sns":      msg,
 		"subArn":   subArn,
 		"endpoint": endpoint,
 	}).Debug("Calling endpoint")
-	var err error
+	if msg.Type == "Notification" {
+		defer func() {
+			metrics.ObserveDelivery(msg.TopicArn, subArn, err)
+		}()
+	}
 	var byteData []byte
 
+	// raw messages are posted as they were published, without the JSON document
+	// and its message attributes
+	contentType := "application/json"
 	if raw {
-		byteData, err = json.Marshal(msg.Message)
+		byteData = []byte(msg.Message)
+		contentType = "text/plain; charset=UTF-8"
 	} else {
 		byteData, err = json.Marshal(msg)
 	}
@@ -639,7 +961,10 @@ func callEndpoint(endpoint string, subArn string, msg app.SNSMessage, raw bool)
 	}
 
 	//req.Header.Add("Authorization", "Basic YXV0aEhlYWRlcg==")
-	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Content-Type", contentType)
+	if raw {
+		req.Header.Add("x-amz-sns-rawdelivery", "true")
+	}
 	req.Header.Add("x-amz-sns-message-type", msg.Type)
 	req.Header.Add("x-amz-sns-message-id", msg.MessageId)
 	req.Header.Add("x-amz-sns-topic-arn", msg.TopicArn)
@@ -680,16 +1005,18 @@ func callEndpoint(endpoint string, subArn string, msg app.SNSMessage, raw bool)
 	return nil
 }
 
-func getMessageAttributesFromRequest(req *http.Request) map[string]app.MessageAttributeValue {
+// getMessageAttributesFromRequest returns the message attributes of a request,
+// or of a batch entry, whose form keys start with prefix.
+func getMessageAttributesFromRequest(req *http.Request, prefix string) map[string]app.MessageAttributeValue {
 	attributes := make(map[string]app.MessageAttributeValue)
 
 	for i := 1; true; i++ {
-		name := req.FormValue(fmt.Sprintf("MessageAttributes.entry.%d.Name", i))
+		name := req.FormValue(fmt.Sprintf("%s.entry.%d.Name", prefix, i))
 		if name == "" {
 			break
 		}
 
-		dataType := req.FormValue(fmt.Sprintf("MessageAttributes.entry.%d.Value.DataType", i))
+		dataType := req.FormValue(fmt.Sprintf("%s.entry.%d.Value.DataType", prefix, i))
 		if dataType == "" {
 			log.Warnf("DataType of MessageAttribute %s is missing, MD5 checksum will most probably be wrong!\n", name)
 			continue
@@ -697,7 +1024,7 @@ func getMessageAttributesFromRequest(req *http.Request) map[string]app.MessageAt
 
 		// StringListValue and BinaryListValue is currently not implemented
 		for _, valueKey := range [...]string{"StringValue", "BinaryValue"} {
-			value := req.FormValue(fmt.Sprintf("MessageAttributes.entry.%d.Value.%s", i, valueKey))
+			value := req.FormValue(fmt.Sprintf("%s.entry.%d.Value.%s", prefix, i, valueKey))
 			if value != "" {
 				attributes[name] = app.MessageAttributeValue{name, dataType, value, valueKey}
 			}
@@ -713,18 +1040,22 @@ func getMessageAttributesFromRequest(req *http.Request) map[string]app.MessageAt
 
 func CreateMessageBody(subs *app.Subscription, msg string, subject string, messageStructure string,
 	messageAttributes map[string]app.MessageAttributeValue) ([]byte, error) {
-
 	msgId, _ := common.NewUUID()
+	return createMessageBody(subs, msgId, msg, subject, messageStructure, messageAttributes)
+}
 
+// createMessageBody returns the JSON document SQS subscriptions get for a message
+// with the given MessageId.
+func createMessageBody(subs *app.Subscription, msgId string, msg string, subject string, messageStructure string,
+	messageAttributes map[string]app.MessageAttributeValue) ([]byte, error) {
 	message := app.SNSMessage{
 		Type:              "Notification",
 		MessageId:         msgId,
 		TopicArn:          subs.TopicArn,
 		Subject:           subject,
-		Timestamp:         time.Now().UTC().Format(time.RFC3339),
-		SignatureVersion:  "1",
-		SigningCertURL:    "http://" + app.CurrentEnvironment.Host + ":" + app.CurrentEnvironment.Port + "/SimpleNotificationService/" + msgId + ".pem",
-		UnsubscribeURL:    "http://" + app.CurrentEnvironment.Host + ":" + app.CurrentEnvironment.Port + "/?Action=Unsubscribe&SubscriptionArn=" + subs.SubscriptionArn,
+		Timestamp:         app.Now().UTC().Format(time.RFC3339),
+		SignatureVersion:  signatureVersion(subs.TopicArn),
+		UnsubscribeURL:    app.BaseURL() + "/?Action=Unsubscribe&SubscriptionArn=" + subs.SubscriptionArn,
 		MessageAttributes: formatAttributes(messageAttributes),
 	}
 
@@ -738,11 +1069,8 @@ func CreateMessageBody(subs *app.Subscription, msg string, subject string, messa
 		message.Message = msg
 	}
 
-	signature, err := signMessage(PrivateKEY, &message)
-	if err != nil {
+	if err := signMessage(&message); err != nil {
 		log.Error(err)
-	} else {
-		message.Signature = signature
 	}
 
 	byteMsg, _ := json.Marshal(message)
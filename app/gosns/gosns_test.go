@@ -616,3 +616,124 @@ func TestSetSubscriptionAttributesHandler_FilterPolicy_POST_Success(t *testing.T
 		t.Errorf("filter policy has not need applied")
 	}
 }
+
+func TestTopicAttributes_KmsMasterKeyId(t *testing.T) {
+	defer func() {
+		app.SyncTopics.Lock()
+		delete(app.SyncTopics.Topics, "EncryptedTopic")
+		app.SyncTopics.Unlock()
+	}()
+
+	req, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{}
+	form.Add("Action", "CreateTopic")
+	form.Add("Name", "EncryptedTopic")
+	form.Add("Attributes.entry.1.key", "KmsMasterKeyId")
+	form.Add("Attributes.entry.1.value", "alias/aws/sns")
+	req.PostForm = form
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(CreateTopic).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	topicArn := "arn:aws:sns:" + app.CurrentEnvironment.Region + ":" + app.CurrentEnvironment.AccountID + ":EncryptedTopic"
+	req, err = http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form = url.Values{}
+	form.Add("Action", "GetTopicAttributes")
+	form.Add("TopicArn", topicArn)
+	req.PostForm = form
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(GetTopicAttributes).ServeHTTP(rr, req)
+	expected := "<value>alias/aws/sns</value>"
+	if !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	req, err = http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form = url.Values{}
+	form.Add("Action", "SetTopicAttributes")
+	form.Add("TopicArn", topicArn)
+	form.Add("AttributeName", "KmsMasterKeyId")
+	form.Add("AttributeValue", "alias/does-not-exist")
+	req.PostForm = form
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(SetTopicAttributes).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	expected = "<Code>InvalidParameter</Code>"
+	if !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+}
+
+func TestTopicAttributes_Policies(t *testing.T) {
+	app.SyncTopics.Lock()
+	app.SyncTopics.Topics["PolicyTopic"] = &app.Topic{Name: "PolicyTopic", Arn: "arn:aws:sns:local:000000000000:PolicyTopic"}
+	app.SyncTopics.Unlock()
+	defer func() {
+		app.SyncTopics.Lock()
+		delete(app.SyncTopics.Topics, "PolicyTopic")
+		app.SyncTopics.Unlock()
+	}()
+
+	setAttribute := func(name string, value string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := url.Values{}
+		form.Add("Action", "SetTopicAttributes")
+		form.Add("TopicArn", "arn:aws:sns:local:000000000000:PolicyTopic")
+		form.Add("AttributeName", name)
+		form.Add("AttributeValue", value)
+		req.PostForm = form
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(SetTopicAttributes).ServeHTTP(rr, req)
+		return rr
+	}
+
+	if status := setAttribute("Policy", `{"Version":"2012-10-17"}`).Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if status := setAttribute("DeliveryPolicy", `{"http":{}}`).Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if status := setAttribute("Policy", "not json").Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	if status := setAttribute("DisplayNmae", "typo").Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	req, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{}
+	form.Add("Action", "GetTopicAttributes")
+	form.Add("TopicArn", "arn:aws:sns:local:000000000000:PolicyTopic")
+	req.PostForm = form
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(GetTopicAttributes).ServeHTTP(rr, req)
+	for _, expected := range []string{`<value>{&#34;Version&#34;:&#34;2012-10-17&#34;}</value>`, `<key>DeliveryPolicy</key>`} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package gosns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// lambdaRetries is how many times a failed invocation is retried, like the
+// immediate retries SNS makes when delivering to Lambda.
+const lambdaRetries = 3
+
+var (
+	// lambdaTimeout bounds an invocation and its retries together, so a function
+	// that never responds can't hold up the publish delivering to it for long.
+	lambdaTimeout = 30 * time.Second
+	// lambdaBackoff is the delay before the first retry, doubled for each one after.
+	lambdaBackoff = 100 * time.Millisecond
+)
+
+// lambdaEvent is the event SNS invokes functions with.
+type lambdaEvent struct {
+	Records []lambdaRecord `json:"Records"`
+}
+
+type lambdaRecord struct {
+	EventSource          string        `json:"EventSource"`
+	EventVersion         string        `json:"EventVersion"`
+	EventSubscriptionArn string        `json:"EventSubscriptionArn"`
+	Sns                  lambdaMessage `json:"Sns"`
+}
+
+type lambdaMessage struct {
+	Type              string                 `json:"Type"`
+	MessageId         string                 `json:"MessageId"`
+	TopicArn          string                 `json:"TopicArn"`
+	Subject           *string                `json:"Subject"` // null without a subject
+	Message           string                 `json:"Message"`
+	Timestamp         string                 `json:"Timestamp"`
+	SignatureVersion  string                 `json:"SignatureVersion"`
+	Signature         string                 `json:"Signature"`
+	SigningCertUrl    string                 `json:"SigningCertUrl"`
+	UnsubscribeUrl    string                 `json:"UnsubscribeUrl"`
+	MessageAttributes map[string]app.MsgAttr `json:"MessageAttributes"`
+}
+
+func publishLambda(ctx context.Context, subs *app.Subscription, entry publishEntry, topicArn string) error {
+	msg := app.SNSMessage{
+		Type:              "Notification",
+		MessageId:         entry.MessageId,
+		TopicArn:          topicArn,
+		Subject:           entry.Subject,
+		Message:           protocolMessage(entry, subs.Protocol),
+		Timestamp:         app.Now().UTC().Format(time.RFC3339),
+		SignatureVersion:  signatureVersion(topicArn),
+		UnsubscribeURL:    app.BaseURL() + "/?Action=Unsubscribe&SubscriptionArn=" + subs.SubscriptionArn,
+		MessageAttributes: formatAttributes(entry.MessageAttributes),
+	}
+	if err := signMessage(&msg); err != nil {
+		log.Error(err)
+	}
+
+	err := invokeLambda(ctx, subs, msg)
+	metrics.ObserveDelivery(topicArn, subs.SubscriptionArn, err)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"function": subs.EndPoint,
+			"ARN":      subs.SubscriptionArn,
+			"error":    err.Error(),
+		}).Error("Error invoking lambda function")
+	}
+	return err
+}
+
+// invokeLambda invokes the function of a lambda subscription with the SNS event
+// of a message, retrying with a backoff if it fails until ctx is done or
+// lambdaTimeout has passed.
+func invokeLambda(ctx context.Context, subs *app.Subscription, msg app.SNSMessage) error {
+	function, ok := app.FindLambdaFunction(app.GetCurrentEnvironment().LambdaFunctions, subs.EndPoint)
+	if !ok {
+		return fmt.Errorf("no LambdaFunctions entry for %s", subs.EndPoint)
+	}
+
+	var subject *string
+	if msg.Subject != "" {
+		subject = &msg.Subject
+	}
+	event := lambdaEvent{Records: []lambdaRecord{{
+		EventSource:          "aws:sns",
+		EventVersion:         "1.0",
+		EventSubscriptionArn: subs.SubscriptionArn,
+		Sns: lambdaMessage{
+			Type:              msg.Type,
+			MessageId:         msg.MessageId,
+			TopicArn:          msg.TopicArn,
+			Subject:           subject,
+			Message:           msg.Message,
+			Timestamp:         msg.Timestamp,
+			SignatureVersion:  msg.SignatureVersion,
+			Signature:         msg.Signature,
+			SigningCertUrl:    msg.SigningCertURL,
+			UnsubscribeUrl:    msg.UnsubscribeURL,
+			MessageAttributes: msg.MessageAttributes,
+		},
+	}}}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, lambdaTimeout)
+	defer cancel()
+	invocationURL := lambdaInvocationURL(function, subs.EndPoint)
+	backoff := lambdaBackoff
+	for attempt := 0; ; attempt++ {
+		err = invoke(ctx, invocationURL, body)
+		if err == nil || attempt == lambdaRetries || ctx.Err() != nil {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"function": subs.EndPoint,
+			"attempt":  attempt + 1,
+			"error":    err.Error(),
+		}).Warn("Retrying lambda invocation")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// lambdaInvocationURL returns the Invoke API URL of the function a lambda
+// subscription's endpoint refers to, with its version or alias as the Qualifier.
+func lambdaInvocationURL(function app.LambdaFunction, endpoint string) string {
+	if strings.HasSuffix(strings.TrimSuffix(function.EndPoint, "/"), "/invocations") {
+		return function.EndPoint
+	}
+	invocationURL := strings.TrimSuffix(function.EndPoint, "/") + "/2015-03-31/functions/" +
+		url.PathEscape(app.LambdaFunctionName(endpoint)) + "/invocations"
+	if parts := strings.Split(endpoint, ":"); len(parts) == 8 && parts[2] == "lambda" {
+		invocationURL += "?Qualifier=" + url.QueryEscape(parts[7])
+	}
+	return invocationURL
+}
+
+// invoke posts an event to the Invoke API, failing if the function does.
+func invoke(ctx context.Context, invocationURL string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", invocationURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("the Invoke API responded with %s", res.Status)
+	}
+	if functionError := res.Header.Get("X-Amz-Function-Error"); functionError != "" {
+		return fmt.Errorf("the function failed with a %s error", functionError)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package gosns
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+func TestPublish_InvokesLambdaFunctions(t *testing.T) {
+	var paths []string
+	var events []lambdaEvent
+	failures := 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var event lambdaEvent
+		json.NewDecoder(req.Body).Decode(&event)
+		paths = append(paths, req.URL.RequestURI())
+		events = append(events, event)
+		if failures > 0 {
+			failures--
+			w.Header().Set("X-Amz-Function-Error", "Unhandled")
+		}
+	}))
+	defer ts.Close()
+	functions := app.CurrentEnvironment.LambdaFunctions
+	app.CurrentEnvironment.LambdaFunctions = []app.LambdaFunction{{Name: "orders-handler", EndPoint: ts.URL}}
+	defer func() { app.CurrentEnvironment.LambdaFunctions = functions }()
+
+	topicArn := addConfirmationTopic(t, "LambdaTopic")
+	functionArn := "arn:aws:lambda:us-east-1:000000000000:function:orders-handler"
+	rr := callSNS(Subscribe, url.Values{"TopicArn": {topicArn}, "Protocol": {"lambda"}, "Endpoint": {functionArn + ":live"},
+		"Attributes.entry.1.key": {"FilterPolicy"}, "Attributes.entry.1.value": {`{"type":["created"]}`}}, true)
+	// lambda subscriptions don't need to be confirmed
+	assert.NotContains(t, rr.Body.String(), "pending confirmation")
+	subArn := app.SyncTopics.Topics["LambdaTopic"].Subscriptions[0].SubscriptionArn
+
+	callSNS(Publish, url.Values{"TopicArn": {topicArn}, "Message": {"filtered"},
+		"MessageAttributes.entry.1.Name": {"type"}, "MessageAttributes.entry.1.Value.DataType": {"String"}, "MessageAttributes.entry.1.Value.StringValue": {"deleted"}}, true)
+	assert.Empty(t, events)
+
+	callSNS(Publish, url.Values{"TopicArn": {topicArn}, "Message": {"hello"},
+		"MessageAttributes.entry.1.Name": {"type"}, "MessageAttributes.entry.1.Value.DataType": {"String"}, "MessageAttributes.entry.1.Value.StringValue": {"created"}}, true)
+	// the function error is retried
+	if !assert.Len(t, events, 2) {
+		return
+	}
+	assert.Equal(t, "/2015-03-31/functions/orders-handler/invocations?Qualifier=live", paths[1])
+	assert.Equal(t, events[0], events[1])
+
+	record := events[1].Records[0]
+	assert.Equal(t, "aws:sns", record.EventSource)
+	assert.Equal(t, subArn, record.EventSubscriptionArn)
+	assert.Equal(t, "Notification", record.Sns.Type)
+	assert.Equal(t, topicArn, record.Sns.TopicArn)
+	assert.Equal(t, "hello", record.Sns.Message)
+	assert.Nil(t, record.Sns.Subject)
+	assert.Equal(t, app.MsgAttr{Type: "String", Value: "created"}, record.Sns.MessageAttributes["type"])
+	verifySignature(t, app.SNSMessage{
+		Type:             record.Sns.Type,
+		MessageId:        record.Sns.MessageId,
+		TopicArn:         record.Sns.TopicArn,
+		Message:          record.Sns.Message,
+		Timestamp:        record.Sns.Timestamp,
+		SignatureVersion: record.Sns.SignatureVersion,
+		Signature:        record.Sns.Signature,
+	})
+}
+
+func TestLambdaInvocationURL(t *testing.T) {
+	function := app.LambdaFunction{Name: "orders-handler", EndPoint: "http://localhost:3001/"}
+	assert.Equal(t, "http://localhost:3001/2015-03-31/functions/orders-handler/invocations",
+		lambdaInvocationURL(function, "arn:aws:lambda:us-east-1:000000000000:function:orders-handler"))
+	assert.Equal(t, "http://localhost:3001/2015-03-31/functions/orders-handler/invocations",
+		lambdaInvocationURL(function, "orders-handler"))
+
+	// the runtime interface emulator serves a single function at a fixed URL
+	function.EndPoint = "http://localhost:9000/2015-03-31/functions/function/invocations"
+	assert.Equal(t, function.EndPoint, lambdaInvocationURL(function, "arn:aws:lambda:us-east-1:000000000000:function:orders-handler"))
+}
+
+func TestInvokeLambda_GivesUpOnFunctionsThatDontRespond(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+	functions := app.CurrentEnvironment.LambdaFunctions
+	app.CurrentEnvironment.LambdaFunctions = []app.LambdaFunction{{Name: "orders-handler", EndPoint: ts.URL}}
+	defer func() { app.CurrentEnvironment.LambdaFunctions = functions }()
+	defer func(timeout time.Duration) { lambdaTimeout = timeout }(lambdaTimeout)
+	lambdaTimeout = 50 * time.Millisecond
+
+	// the timeout covers the retries too
+	start := time.Now()
+	assert.Error(t, invokeLambda(context.Background(), &app.Subscription{EndPoint: "orders-handler"}, app.SNSMessage{}))
+	assert.Less(t, time.Since(start), time.Second)
+
+	// nor does it retry once the publish request is gone
+	lambdaTimeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	assert.Error(t, invokeLambda(ctx, &app.Subscription{EndPoint: "orders-handler"}, app.SNSMessage{}))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestInvokeLambda_BacksOffBetweenRetries(t *testing.T) {
+	var attempts []time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		attempts = append(attempts, time.Now())
+		if len(attempts) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+	functions := app.CurrentEnvironment.LambdaFunctions
+	app.CurrentEnvironment.LambdaFunctions = []app.LambdaFunction{{Name: "orders-handler", EndPoint: ts.URL}}
+	defer func() { app.CurrentEnvironment.LambdaFunctions = functions }()
+	defer func(backoff time.Duration) { lambdaBackoff = backoff }(lambdaBackoff)
+	lambdaBackoff = 20 * time.Millisecond
+
+	assert.NoError(t, invokeLambda(context.Background(), &app.Subscription{EndPoint: "orders-handler"}, app.SNSMessage{}))
+	if assert.Len(t, attempts, 3) {
+		assert.GreaterOrEqual(t, attempts[1].Sub(attempts[0]), 20*time.Millisecond)
+		assert.GreaterOrEqual(t, attempts[2].Sub(attempts[1]), 40*time.Millisecond)
+	}
+}
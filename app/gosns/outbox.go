@@ -0,0 +1,168 @@
+package gosns
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// outboxLimit is how many messages the outbox keeps, dropping the oldest ones.
+const outboxLimit = 10000
+
+// defaultEmailSubject is the subject of emails for messages published without one.
+const defaultEmailSubject = "AWS Notification Message"
+
+var phoneNumberRegexp = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// outbox holds the messages delivered to email, email-json, sms and application
+// endpoints, oldest first.
+var outbox = struct {
+	sync.RWMutex
+	messages []app.OutboxMessage
+}{}
+
+// OutboxMessages returns the messages in the outbox, oldest first.
+func OutboxMessages() []app.OutboxMessage {
+	outbox.RLock()
+	defer outbox.RUnlock()
+	return append([]app.OutboxMessage{}, outbox.messages...)
+}
+
+// ClearOutbox deletes the messages in the outbox.
+func ClearOutbox() {
+	outbox.Lock()
+	outbox.messages = nil
+	outbox.Unlock()
+}
+
+func recordOutboxMessage(msg app.OutboxMessage) {
+	log.WithFields(log.Fields{
+		"protocol": msg.Protocol,
+		"endpoint": msg.EndPoint,
+		"type":     msg.Type,
+	}).Info("Recording message in the outbox")
+	outbox.Lock()
+	outbox.messages = append(outbox.messages, msg)
+	if len(outbox.messages) > outboxLimit {
+		outbox.messages = append([]app.OutboxMessage{}, outbox.messages[len(outbox.messages)-outboxLimit:]...)
+	}
+	outbox.Unlock()
+}
+
+// validEndpoint tells whether an endpoint can be subscribed with a protocol
+// whose messages go to the outbox.
+func validEndpoint(protocol app.Protocol, endpoint string) bool {
+	switch protocol {
+	case app.ProtocolEmail, app.ProtocolEmailJSON:
+		name, domain, ok := strings.Cut(endpoint, "@")
+		return ok && name != "" && domain != ""
+	case app.ProtocolSMS:
+		return phoneNumberRegexp.MatchString(endpoint)
+	case app.ProtocolApplication:
+		return isEndpointArn(endpoint)
+	}
+	return true
+}
+
+// isEndpointArn tells whether an ARN is the ARN of a mobile application
+// endpoint, e.g. arn:aws:sns:us-east-1:123456789012:endpoint/GCM/app/id.
+func isEndpointArn(arn string) bool {
+	parts := strings.SplitN(arn, ":", 6)
+	return len(parts) == 6 && parts[0] == "arn" && parts[2] == "sns" && strings.HasPrefix(parts[5], "endpoint/") &&
+		len(strings.Split(parts[5], "/")) == 4
+}
+
+// messageStructureKey returns the key of the message for a protocol and
+// endpoint in a MessageStructure=json message. Application endpoints get the
+// message of their platform, e.g. GCM or APNS.
+func messageStructureKey(protocol app.Protocol, endpoint string) string {
+	if protocol == app.ProtocolApplication && isEndpointArn(endpoint) {
+		return strings.Split(endpoint, "/")[1]
+	}
+	return string(protocol)
+}
+
+func publishOutbox(subs *app.Subscription, entry publishEntry, topicArn string) error {
+	msg, err := outboxMessage(app.Protocol(subs.Protocol), subs.EndPoint, subs.SubscriptionArn, entry, topicArn)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	recordOutboxMessage(msg)
+	metrics.ObserveDelivery(topicArn, subs.SubscriptionArn, nil)
+	return nil
+}
+
+// outboxMessage formats a notification like SNS would deliver it to the endpoint.
+func outboxMessage(protocol app.Protocol, endpoint string, subArn string, entry publishEntry, topicArn string) (app.OutboxMessage, error) {
+	message := protocolMessage(entry, messageStructureKey(protocol, endpoint))
+	msg := app.OutboxMessage{
+		MessageId:         entry.MessageId,
+		Type:              "Notification",
+		Protocol:          string(protocol),
+		EndPoint:          endpoint,
+		TopicArn:          topicArn,
+		SubscriptionArn:   subArn,
+		Message:           message,
+		MessageAttributes: entry.MessageAttributes,
+		SentTime:          app.Now(),
+	}
+	switch protocol {
+	case app.ProtocolEmail, app.ProtocolEmailJSON:
+		msg.Subject = entry.Subject
+		if msg.Subject == "" {
+			msg.Subject = defaultEmailSubject
+		}
+	}
+	if protocol == app.ProtocolEmailJSON {
+		// email-json gets the JSON document HTTP endpoints get
+		snsMsg := app.SNSMessage{
+			Type:              "Notification",
+			MessageId:         entry.MessageId,
+			TopicArn:          topicArn,
+			Subject:           entry.Subject,
+			Message:           message,
+			Timestamp:         msg.SentTime.UTC().Format(time.RFC3339),
+			SignatureVersion:  signatureVersion(topicArn),
+			UnsubscribeURL:    app.BaseURL() + "/?Action=Unsubscribe&SubscriptionArn=" + subArn,
+			MessageAttributes: formatAttributes(entry.MessageAttributes),
+		}
+		if err := signMessage(&snsMsg); err != nil {
+			log.Error(err)
+		}
+		body, err := json.Marshal(snsMsg)
+		if err != nil {
+			return app.OutboxMessage{}, err
+		}
+		msg.Message = string(body)
+	}
+	return msg, nil
+}
+
+// recordConfirmation records the SubscriptionConfirmation email of an email or
+// email-json subscription.
+func recordConfirmation(sub *app.Subscription, snsMsg app.SNSMessage) {
+	msg := app.OutboxMessage{
+		MessageId:       snsMsg.MessageId,
+		Type:            snsMsg.Type,
+		Protocol:        sub.Protocol,
+		EndPoint:        sub.EndPoint,
+		TopicArn:        sub.TopicArn,
+		SubscriptionArn: sub.SubscriptionArn,
+		Subject:         "AWS Notification - Subscription Confirmation",
+		Message:         snsMsg.Message + "\n" + snsMsg.SubscribeURL,
+		SubscribeURL:    snsMsg.SubscribeURL,
+		SentTime:        app.Now(),
+	}
+	if app.Protocol(sub.Protocol) == app.ProtocolEmailJSON {
+		body, _ := json.Marshal(snsMsg)
+		msg.Message = string(body)
+	}
+	recordOutboxMessage(msg)
+}
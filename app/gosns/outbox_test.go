@@ -0,0 +1,97 @@
+package gosns
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+func TestPublish_RecordsOutboxMessages(t *testing.T) {
+	defer ClearOutbox()
+	topicArn := addConfirmationTopic(t, "OutboxTopic")
+	endpointArn := "arn:aws:sns:us-east-1:000000000000:endpoint/GCM/orders-app/0f7d8c3a"
+	for protocol, endpoint := range map[string]string{"sms": "+15555550100", "application": endpointArn} {
+		rr := callSNS(Subscribe, url.Values{"TopicArn": {topicArn}, "Protocol": {protocol}, "Endpoint": {endpoint}}, true)
+		assert.NotContains(t, rr.Body.String(), "pending confirmation")
+	}
+
+	// email subscriptions are confirmed with the SubscribeURL of their confirmation email
+	for _, protocol := range []string{"email", "email-json"} {
+		rr := callSNS(Subscribe, url.Values{"TopicArn": {topicArn}, "Protocol": {protocol}, "Endpoint": {protocol + "@example.com"}}, true)
+		assert.Contains(t, rr.Body.String(), "pending confirmation")
+	}
+	confirmations := OutboxMessages()
+	if !assert.Len(t, confirmations, 2) {
+		return
+	}
+	assert.Equal(t, "SubscriptionConfirmation", confirmations[0].Type)
+	assert.Equal(t, "email@example.com", confirmations[0].EndPoint)
+	assert.Contains(t, confirmations[0].Message, confirmations[0].SubscribeURL)
+	var confirmation app.SNSMessage
+	assert.NoError(t, json.Unmarshal([]byte(confirmations[1].Message), &confirmation))
+	assert.Equal(t, "SubscriptionConfirmation", confirmation.Type)
+	for _, c := range confirmations {
+		u, _ := url.Parse(c.SubscribeURL)
+		rr := callSNS(ConfirmSubscription, url.Values{"TopicArn": {topicArn}, "Token": {u.Query().Get("Token")}}, false)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	ClearOutbox()
+
+	message := `{"default": "default message", "sms": "sms message", "GCM": "{\"data\": {\"id\": 1}}", "email-json": "json message"}`
+	callSNS(Publish, url.Values{"TopicArn": {topicArn}, "Message": {message}, "MessageStructure": {"json"}}, true)
+	messages := map[string]app.OutboxMessage{}
+	for _, msg := range OutboxMessages() {
+		messages[msg.Protocol] = msg
+	}
+	assert.Len(t, messages, 4)
+	assert.Equal(t, "sms message", messages["sms"].Message)
+	assert.Equal(t, `{"data": {"id": 1}}`, messages["application"].Message)
+	assert.Equal(t, "default message", messages["email"].Message)
+	assert.Equal(t, "AWS Notification Message", messages["email"].Subject)
+
+	var notification app.SNSMessage
+	assert.NoError(t, json.Unmarshal([]byte(messages["email-json"].Message), &notification))
+	assert.Equal(t, "Notification", notification.Type)
+	assert.Equal(t, "json message", notification.Message)
+	assert.Equal(t, topicArn, notification.TopicArn)
+	verifySignature(t, notification)
+}
+
+func TestPublish_DirectToPhoneNumbersAndEndpoints(t *testing.T) {
+	defer ClearOutbox()
+
+	rr := callSNS(Publish, url.Values{"PhoneNumber": {"+15555550100"}, "Message": {"your code is 1234"}, "Subject": {"ignored"}}, true)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	endpointArn := "arn:aws:sns:us-east-1:000000000000:endpoint/APNS/orders-app/5e3c"
+	rr = callSNS(Publish, url.Values{"TargetArn": {endpointArn}, "Message": {`{"default": "hi", "APNS": "{\"aps\": {}}"}`}, "MessageStructure": {"json"}}, true)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	messages := OutboxMessages()
+	if assert.Len(t, messages, 2) {
+		assert.Contains(t, rr.Body.String(), "<MessageId>"+messages[1].MessageId+"</MessageId>")
+		assert.Equal(t, app.OutboxMessage{
+			MessageId:         messages[0].MessageId,
+			Type:              "Notification",
+			Protocol:          "sms",
+			EndPoint:          "+15555550100",
+			Message:           "your code is 1234",
+			MessageAttributes: map[string]app.MessageAttributeValue{},
+			SentTime:          messages[0].SentTime,
+		}, messages[0])
+		assert.Equal(t, "application", messages[1].Protocol)
+		assert.Equal(t, `{"aps": {}}`, messages[1].Message)
+	}
+
+	rr = callSNS(Publish, url.Values{"PhoneNumber": {"555-0100"}, "Message": {"hi"}}, true)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Invalid parameter: PhoneNumber")
+	rr = callSNS(Subscribe, url.Values{"TopicArn": {"arn:aws:sns:local:000000000000:OutboxTopic"}, "Protocol": {"email"}, "Endpoint": {"nobody"}}, true)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Invalid parameter: Endpoint")
+	assert.Len(t, OutboxMessages(), 2)
+}
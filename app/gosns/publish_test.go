@@ -0,0 +1,230 @@
+package gosns
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/common"
+	"github.com/Admiral-Piett/goaws/app/gosqs"
+)
+
+func TestPublish_UsesOneMessageId(t *testing.T) {
+	defer ClearPublishedMessages()
+	topicArn := addConfirmationTopic(t, "MessageIdTopic")
+	queueArn := "arn:aws:sqs:local:000000000000:message-id-queue"
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["message-id-queue"] = &app.Queue{Name: "message-id-queue", Arn: queueArn}
+	app.SyncQueues.Unlock()
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "message-id-queue")
+		app.SyncQueues.Unlock()
+	}()
+	var header string
+	var notification app.SNSMessage
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header = req.Header.Get("x-amz-sns-message-id")
+		json.NewDecoder(req.Body).Decode(&notification)
+	}))
+	defer ts.Close()
+	app.SyncTopics.Topics["MessageIdTopic"].Subscriptions = []*app.Subscription{
+		{TopicArn: topicArn, Protocol: "sqs", SubscriptionArn: topicArn + ":sqs", EndPoint: queueArn},
+		{TopicArn: topicArn, Protocol: "http", SubscriptionArn: topicArn + ":http", EndPoint: ts.URL},
+		{TopicArn: topicArn, Protocol: "sqs", SubscriptionArn: topicArn + ":missing", EndPoint: queueArn + "-missing"},
+		{TopicArn: topicArn, Protocol: "sqs", SubscriptionArn: topicArn + ":filtered", EndPoint: queueArn, FilterPolicy: &app.FilterPolicy{"kind": {"order"}}},
+	}
+
+	rr := callSNS(Publish, url.Values{"TopicArn": {topicArn}, "Message": {"hello"}}, true)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	msgId := regexp.MustCompile(`<MessageId>([^<]+)</MessageId>`).FindStringSubmatch(rr.Body.String())
+	if !assert.Len(t, msgId, 2) {
+		return
+	}
+
+	var envelope app.SNSMessage
+	assert.NoError(t, json.Unmarshal(app.SyncQueues.Queues["message-id-queue"].Messages[0].MessageBody, &envelope))
+	assert.Equal(t, msgId[1], envelope.MessageId)
+	assert.Equal(t, common.GetMD5Hash(string(app.SyncQueues.Queues["message-id-queue"].Messages[0].MessageBody)),
+		app.SyncQueues.Queues["message-id-queue"].Messages[0].MD5OfMessageBody)
+	assert.Equal(t, msgId[1], notification.MessageId)
+	assert.Equal(t, msgId[1], header)
+
+	published, ok := FindPublishedMessage(msgId[1])
+	if assert.True(t, ok) {
+		assert.Equal(t, topicArn, published.TopicArn)
+		assert.Equal(t, "hello", published.Message)
+		assert.Equal(t, []app.Delivery{
+			{SubscriptionArn: topicArn + ":sqs", Protocol: "sqs", EndPoint: queueArn, Status: app.DeliveryDelivered},
+			{SubscriptionArn: topicArn + ":http", Protocol: "http", EndPoint: ts.URL, Status: app.DeliveryDelivered},
+			{SubscriptionArn: topicArn + ":missing", Protocol: "sqs", EndPoint: queueArn + "-missing", Status: app.DeliveryFailed, Error: "queue message-id-queue-missing does not exist"},
+			{SubscriptionArn: topicArn + ":filtered", Protocol: "sqs", EndPoint: queueArn, Status: app.DeliveryFiltered},
+		}, published.Deliveries)
+	}
+	_, ok = FindPublishedMessage("unknown")
+	assert.False(t, ok)
+}
+
+func TestPublish_ValidatesTheRequest(t *testing.T) {
+	topicArn := addConfirmationTopic(t, "ValidationTopic")
+	ts, received := confirmationEndpoint(t)
+	app.SyncTopics.Topics["ValidationTopic"].Subscriptions = []*app.Subscription{
+		{TopicArn: topicArn, Protocol: "http", SubscriptionArn: topicArn + ":http", EndPoint: ts.URL},
+	}
+	attributes := func(n int, dataType string, value string) url.Values {
+		form := url.Values{"TopicArn": {topicArn}, "Message": {"hello"}}
+		for i := 1; i <= n; i++ {
+			form.Set(fmt.Sprintf("MessageAttributes.entry.%d.Name", i), fmt.Sprintf("attr%d", i))
+			form.Set(fmt.Sprintf("MessageAttributes.entry.%d.Value.DataType", i), dataType)
+			form.Set(fmt.Sprintf("MessageAttributes.entry.%d.Value.StringValue", i), value)
+		}
+		return form
+	}
+
+	for _, test := range []struct {
+		form    url.Values
+		code    string
+		message string
+	}{
+		{url.Values{"TopicArn": {topicArn}}, "InvalidParameter", "Invalid parameter: Empty message"},
+		{url.Values{"TopicArn": {topicArn}, "Message": {strings.Repeat("x", maxMessageSize+1)}}, "InvalidParameter", "Invalid parameter: Message too long"},
+		{url.Values{"TopicArn": {topicArn}, "Message": {"hello"}, "Subject": {strings.Repeat("s", 101)}}, "InvalidParameter", "Invalid parameter: Subject"},
+		{url.Values{"TopicArn": {topicArn}, "Message": {"hello"}, "Subject": {"line\nbreak"}}, "InvalidParameter", "Invalid parameter: Subject"},
+		{url.Values{"TopicArn": {topicArn}, "Message": {"hello"}, "Subject": {" leading space"}}, "InvalidParameter", "Invalid parameter: Subject"},
+		{attributes(11, "String", "value"), "InvalidParameterValue", "exceeds the allowed maximum of 10"},
+		{attributes(1, "Date", "value"), "InvalidParameterValue", "invalid message attribute type"},
+		{attributes(1, "Number.int", "ten"), "InvalidParameterValue", "must contain a numeric value"},
+		{url.Values{"TopicArn": {topicArn}, "Message": {"hello"}, "MessageStructure": {"json"}}, "InvalidParameter", "JSON message body failed to parse"},
+		{url.Values{"TopicArn": {topicArn}, "Message": {`{"http": "hello"}`}, "MessageStructure": {"json"}}, "InvalidParameter", app.ErrNoDefaultElementInJSON},
+		{url.Values{"TopicArn": {topicArn}, "Message": {"hello"}, "MessageStructure": {"xml"}}, "InvalidParameter", "Invalid parameter: MessageStructure"},
+	} {
+		rr := callSNS(Publish, test.form, true)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), "<Code>"+test.code+"</Code>")
+		assert.Contains(t, rr.Body.String(), test.message)
+	}
+
+	// nothing is delivered for invalid requests
+	rr := callSNS(Publish, attributes(10, "Number", "1.5"), true)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, receive(t, received, "Notification").MessageAttributes, 10)
+	assert.Empty(t, received)
+}
+
+func TestPublish_RawMessageDelivery(t *testing.T) {
+	topicArn := addConfirmationTopic(t, "RawTopic")
+	queueArn := "arn:aws:sqs:local:000000000000:raw-queue"
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["raw-queue"] = &app.Queue{Name: "raw-queue", Arn: queueArn}
+	app.SyncQueues.Unlock()
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "raw-queue")
+		app.SyncQueues.Unlock()
+	}()
+	var header http.Header
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header = req.Header
+		body, _ = io.ReadAll(req.Body)
+	}))
+	defer ts.Close()
+	app.SyncTopics.Topics["RawTopic"].Subscriptions = []*app.Subscription{
+		{TopicArn: topicArn, Protocol: "sqs", SubscriptionArn: topicArn + ":sqs", EndPoint: queueArn, Raw: true},
+		{TopicArn: topicArn, Protocol: "http", SubscriptionArn: topicArn + ":http", EndPoint: ts.URL, Raw: true},
+	}
+
+	rr := callSNS(Publish, url.Values{"TopicArn": {topicArn}, "Message": {`{"default": "{\"id\": 1}", "http": "<b>hi</b>"}`}, "MessageStructure": {"json"},
+		"MessageAttributes.entry.1.Name": {"kind"}, "MessageAttributes.entry.1.Value.DataType": {"String"}, "MessageAttributes.entry.1.Value.StringValue": {"order"},
+		"MessageAttributes.entry.2.Name": {"blob"}, "MessageAttributes.entry.2.Value.DataType": {"Binary"}, "MessageAttributes.entry.2.Value.BinaryValue": {"AQID"}}, true)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	messages := app.SyncQueues.Queues["raw-queue"].Messages
+	if assert.Len(t, messages, 1) {
+		attributes := map[string]app.MessageAttributeValue{
+			"kind": {Name: "kind", DataType: "String", Value: "order", ValueKey: "StringValue"},
+			"blob": {Name: "blob", DataType: "Binary", Value: "AQID", ValueKey: "BinaryValue"},
+		}
+		assert.Equal(t, `{"id": 1}`, string(messages[0].MessageBody))
+		assert.Equal(t, common.GetMD5Hash(`{"id": 1}`), messages[0].MD5OfMessageBody)
+		assert.Equal(t, attributes, messages[0].MessageAttributes)
+		assert.Equal(t, common.HashAttributes(attributes), messages[0].MD5OfMessageAttributes)
+	}
+
+	// raw HTTP deliveries are the published bytes, without the message attributes
+	assert.Equal(t, "<b>hi</b>", string(body))
+	assert.Equal(t, "text/plain; charset=UTF-8", header.Get("Content-Type"))
+	assert.Equal(t, "true", header.Get("x-amz-sns-rawdelivery"))
+	assert.NotEmpty(t, header.Get("x-amz-sns-message-id"))
+}
+
+func TestPublish_KeepsMessagesWithinTheQueueRetentionPeriod(t *testing.T) {
+	topicArn := addConfirmationTopic(t, "RetentionTopic")
+	queueArn := "arn:aws:sqs:local:000000000000:retention-queue"
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["retention-queue"] = &app.Queue{Name: "retention-queue", Arn: queueArn, MessageRetentionPeriod: 3600}
+	app.SyncQueues.Unlock()
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "retention-queue")
+		app.SyncQueues.Unlock()
+	}()
+	app.SyncTopics.Topics["RetentionTopic"].Subscriptions = []*app.Subscription{
+		{TopicArn: topicArn, Protocol: "sqs", SubscriptionArn: topicArn + ":sqs", EndPoint: queueArn},
+	}
+
+	rr := callSNS(Publish, url.Values{"TopicArn": {topicArn}, "Message": {"hello"}}, true)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	quit := make(chan struct{})
+	go gosqs.PeriodicTasks(10*time.Millisecond, quit)
+	time.Sleep(50 * time.Millisecond)
+	close(quit)
+
+	app.SyncQueues.RLock()
+	defer app.SyncQueues.RUnlock()
+	assert.Len(t, app.SyncQueues.Queues["retention-queue"].Messages, 1)
+}
+
+func TestPublish_ReportsTheSentTimestampOfDeliveries(t *testing.T) {
+	topicArn := addConfirmationTopic(t, "SentTimestampTopic")
+	queueArn := "arn:aws:sqs:local:000000000000:sent-timestamp-queue"
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["sent-timestamp-queue"] = &app.Queue{Name: "sent-timestamp-queue", Arn: queueArn}
+	app.SyncQueues.Unlock()
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "sent-timestamp-queue")
+		app.SyncQueues.Unlock()
+	}()
+	app.SyncTopics.Topics["SentTimestampTopic"].Subscriptions = []*app.Subscription{
+		{TopicArn: topicArn, Protocol: "sqs", SubscriptionArn: topicArn + ":sqs", EndPoint: queueArn},
+	}
+
+	before := time.Now().UnixNano() / int64(time.Millisecond)
+	rr := callSNS(Publish, url.Values{"TopicArn": {topicArn}, "Message": {"hello"}}, true)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	after := time.Now().UnixNano() / int64(time.Millisecond)
+
+	req, _ := http.NewRequest("POST", "/", nil)
+	req.PostForm = url.Values{"Action": {"ReceiveMessage"}, "QueueUrl": {"http://localhost:4100/queue/sent-timestamp-queue"}}
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(gosqs.ReceiveMessage).ServeHTTP(rr, req)
+
+	match := regexp.MustCompile(`<Name>SentTimestamp</Name>\s*<Value>(-?\d+)</Value>`).FindStringSubmatch(rr.Body.String())
+	if assert.Len(t, match, 2) {
+		sent, _ := strconv.ParseInt(match[1], 10, 64)
+		assert.True(t, sent >= before && sent <= after, "SentTimestamp %d not between %d and %d", sent, before, after)
+	}
+}
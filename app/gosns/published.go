@@ -0,0 +1,93 @@
+package gosns
+
+import (
+	"sync"
+
+	"github.com/Admiral-Piett/goaws/app"
+	log "github.com/sirupsen/logrus"
+)
+
+// publishedLimit is how many published messages the audit trail keeps, dropping
+// the oldest ones.
+const publishedLimit = 10000
+
+// published is the audit trail of the messages published to topics, phone
+// numbers and endpoints, oldest first, and indexed by MessageId.
+var published = struct {
+	sync.RWMutex
+	messages []app.PublishedMessage
+	byId     map[string]int // index in messages
+}{byId: map[string]int{}}
+
+// PublishedMessages returns the messages in the audit trail, oldest first.
+func PublishedMessages() []app.PublishedMessage {
+	published.RLock()
+	defer published.RUnlock()
+	return append([]app.PublishedMessage{}, published.messages...)
+}
+
+// FindPublishedMessage returns the message in the audit trail with a MessageId.
+func FindPublishedMessage(messageId string) (app.PublishedMessage, bool) {
+	published.RLock()
+	defer published.RUnlock()
+	i, ok := published.byId[messageId]
+	if !ok {
+		return app.PublishedMessage{}, false
+	}
+	return published.messages[i], true
+}
+
+// ClearPublishedMessages deletes the messages in the audit trail.
+func ClearPublishedMessages() {
+	published.Lock()
+	published.messages = nil
+	published.byId = map[string]int{}
+	published.Unlock()
+}
+
+func newPublishedMessage(entry publishEntry, topicArn string) app.PublishedMessage {
+	return app.PublishedMessage{
+		MessageId:         entry.MessageId,
+		TopicArn:          topicArn,
+		Subject:           entry.Subject,
+		Message:           entry.Message,
+		MessageStructure:  entry.MessageStructure,
+		MessageAttributes: entry.MessageAttributes,
+		MessageGroupId:    entry.MessageGroupId,
+		PublishTime:       app.Now(),
+		Deliveries:        []app.Delivery{},
+	}
+}
+
+func newDelivery(subs *app.Subscription, status app.DeliveryStatus, err error) app.Delivery {
+	delivery := app.Delivery{
+		SubscriptionArn: subs.SubscriptionArn,
+		Protocol:        subs.Protocol,
+		EndPoint:        subs.EndPoint,
+		Status:          status,
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	return delivery
+}
+
+func recordPublishedMessage(msg app.PublishedMessage) {
+	log.WithFields(log.Fields{
+		"messageId":  msg.MessageId,
+		"topicArn":   msg.TopicArn,
+		"deliveries": len(msg.Deliveries),
+	}).Debug("Recording published message")
+	published.Lock()
+	defer published.Unlock()
+	published.messages = append(published.messages, msg)
+	if len(published.messages) > publishedLimit {
+		published.messages = append([]app.PublishedMessage{}, published.messages[len(published.messages)-publishedLimit:]...)
+		published.byId = make(map[string]int, len(published.messages))
+		for i, m := range published.messages {
+			published.byId[m.MessageId] = i
+		}
+		return
+	}
+	published.byId[msg.MessageId] = len(published.messages) - 1
+}
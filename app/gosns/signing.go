@@ -0,0 +1,139 @@
+package gosns
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+// signing holds the key messages are signed with and the certificate served at
+// their SigningCertURL.
+var signing = struct {
+	sync.RWMutex
+	key  *rsa.PrivateKey
+	cert []byte // PEM encoded
+	id   string // the name in SigningCertURL, which changes with the certificate
+}{}
+
+// LoadSigningCertificate makes SNS sign messages with the RSA key of the given
+// certificate, or with a generated one if certFile and keyFile are empty.
+func LoadSigningCertificate(certFile string, keyFile string) error {
+	if certFile == "" {
+		key, der, err := createSigningCertificate()
+		if err != nil {
+			return err
+		}
+		setSigningCertificate(key, der)
+		return nil
+	}
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the SNS signing certificate: %s", err)
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return errors.New("failed to load the SNS signing certificate: the key must be an RSA key")
+	}
+	setSigningCertificate(key, pair.Certificate[0])
+	return nil
+}
+
+// SigningCertificate returns the PEM encoded certificate messages are signed with,
+// along with the id it is served under.
+func SigningCertificate() (string, []byte) {
+	signing.RLock()
+	defer signing.RUnlock()
+	return signing.id, signing.cert
+}
+
+func setSigningCertificate(key *rsa.PrivateKey, der []byte) {
+	sum := sha256.Sum256(der)
+	signing.Lock()
+	signing.key = key
+	signing.cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	signing.id = "SimpleNotificationService-" + hex.EncodeToString(sum[:16])
+	signing.Unlock()
+}
+
+// createSigningCertificate generates a self-signed certificate like the one SNS
+// signs messages with.
+func createSigningCertificate() (*rsa.PrivateKey, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"GoAws"}, CommonName: "sns.amazonaws.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, der, nil
+}
+
+// signatureVersion returns the SignatureVersion attribute of the topic, "1" unless
+// it is set to "2".
+func signatureVersion(topicArn string) string {
+	arnSegments := strings.Split(topicArn, ":")
+	app.SyncTopics.RLock()
+	defer app.SyncTopics.RUnlock()
+	if topic, ok := app.SyncTopics.Topics[arnSegments[len(arnSegments)-1]]; ok && topic.SignatureVersion == "2" {
+		return "2"
+	}
+	return "1"
+}
+
+// signMessage sets the SigningCertURL and Signature of a message, signing it with
+// SHA1withRSA or, if its SignatureVersion is "2", SHA256withRSA.
+func signMessage(snsMsg *app.SNSMessage) error {
+	if snsMsg.SignatureVersion == "" {
+		snsMsg.SignatureVersion = "1"
+	}
+	fs, err := formatSignature(snsMsg)
+	if err != nil {
+		return err
+	}
+
+	signing.RLock()
+	key, id := signing.key, signing.id
+	signing.RUnlock()
+	snsMsg.SigningCertURL = app.BaseURL() + "/SimpleNotificationService/" + id + ".pem"
+
+	var signature []byte
+	if snsMsg.SignatureVersion == "2" {
+		h := sha256.Sum256([]byte(fs))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	} else {
+		h := sha1.Sum([]byte(fs))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA1, h[:])
+	}
+	if err != nil {
+		return err
+	}
+	snsMsg.Signature = base64.StdEncoding.EncodeToString(signature)
+	return nil
+}
@@ -0,0 +1,149 @@
+package gosns
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+// verifySignature checks the signature of a message against the certificate
+// served at its SigningCertURL.
+func verifySignature(t *testing.T, msg app.SNSMessage) {
+	t.Helper()
+	_, certPEM := SigningCertificate()
+	block, _ := pem.Decode(certPEM)
+	if !assert.NotNil(t, block) {
+		return
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if !assert.NoError(t, err) {
+		return
+	}
+	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	assert.NoError(t, err)
+	fs, err := formatSignature(&msg)
+	assert.NoError(t, err)
+
+	key := cert.PublicKey.(*rsa.PublicKey)
+	if msg.SignatureVersion == "2" {
+		h := sha256.Sum256([]byte(fs))
+		assert.NoError(t, rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], signature))
+	} else {
+		h := sha1.Sum([]byte(fs))
+		assert.NoError(t, rsa.VerifyPKCS1v15(key, crypto.SHA1, h[:], signature))
+	}
+}
+
+func TestSignMessage_SignatureVersions(t *testing.T) {
+	app.SyncTopics.Lock()
+	app.SyncTopics.Topics["SignedTopic"] = &app.Topic{Name: "SignedTopic", Arn: "arn:aws:sns:local:000000000000:SignedTopic"}
+	app.SyncTopics.Unlock()
+	defer func() {
+		app.SyncTopics.Lock()
+		delete(app.SyncTopics.Topics, "SignedTopic")
+		app.SyncTopics.Unlock()
+	}()
+
+	for _, version := range []string{"1", "2"} {
+		app.SyncTopics.Topics["SignedTopic"].SignatureVersion = version
+		subs := &app.Subscription{TopicArn: "arn:aws:sns:local:000000000000:SignedTopic", Protocol: "sqs"}
+		body, err := CreateMessageBody(subs, "hello", "subject", "", nil)
+		assert.NoError(t, err)
+
+		var msg app.SNSMessage
+		assert.NoError(t, json.Unmarshal(body, &msg))
+		assert.Equal(t, version, msg.SignatureVersion)
+		assert.True(t, strings.HasSuffix(msg.SigningCertURL, "/SimpleNotificationService/"+signing.id+".pem"), msg.SigningCertURL)
+		verifySignature(t, msg)
+	}
+}
+
+func TestLoadSigningCertificate(t *testing.T) {
+	defer LoadSigningCertificate("", "")
+
+	dir := t.TempDir()
+	key, der, err := createSigningCertificate()
+	assert.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600))
+
+	assert.NoError(t, LoadSigningCertificate(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")))
+	_, cert := SigningCertificate()
+	assert.Equal(t, certPEM, cert)
+	msg := confirmationMessage("SubscriptionConfirmation", "arn:aws:sns:local:000000000000:missing", "token", "confirm")
+	verifySignature(t, msg)
+
+	// SNS signs with RSA keys only
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+	template := &x509.Certificate{SerialNumber: big.NewInt(1)}
+	ecCert, err := x509.CreateCertificate(rand.Reader, template, template, &ecKey.PublicKey, ecKey)
+	assert.NoError(t, err)
+	os.WriteFile(filepath.Join(dir, "ec.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ecCert}), 0644)
+	os.WriteFile(filepath.Join(dir, "ec.key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}), 0600)
+	err = LoadSigningCertificate(filepath.Join(dir, "ec.pem"), filepath.Join(dir, "ec.key"))
+	assert.EqualError(t, err, "failed to load the SNS signing certificate: the key must be an RSA key")
+	_, cert = SigningCertificate()
+	assert.Equal(t, certPEM, cert)
+}
+
+func TestUnsubscribe_SendsUnsubscribeConfirmation(t *testing.T) {
+	received := make(chan app.SNSMessage, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var msg app.SNSMessage
+		json.NewDecoder(req.Body).Decode(&msg)
+		received <- msg
+	}))
+	defer ts.Close()
+
+	topicArn := "arn:aws:sns:local:000000000000:UnsubscribeTopic"
+	subArn := topicArn + ":sub"
+	app.SyncTopics.Lock()
+	app.SyncTopics.Topics["UnsubscribeTopic"] = &app.Topic{
+		Name:             "UnsubscribeTopic",
+		Arn:              topicArn,
+		SignatureVersion: "2",
+		Subscriptions:    []*app.Subscription{{TopicArn: topicArn, Protocol: "http", SubscriptionArn: subArn, EndPoint: ts.URL}},
+	}
+	app.SyncTopics.Unlock()
+	defer func() {
+		app.SyncTopics.Lock()
+		delete(app.SyncTopics.Topics, "UnsubscribeTopic")
+		app.SyncTopics.Unlock()
+	}()
+
+	req, _ := http.NewRequest("POST", "/", nil)
+	req.PostForm = url.Values{"Action": {"Unsubscribe"}, "SubscriptionArn": {subArn}}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Unsubscribe).ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	msg := <-received
+	assert.Equal(t, "UnsubscribeConfirmation", msg.Type)
+	assert.Equal(t, topicArn, msg.TopicArn)
+	assert.Equal(t, "2", msg.SignatureVersion)
+	assert.Contains(t, msg.SubscribeURL, "Action=ConfirmSubscription")
+	verifySignature(t, msg)
+}
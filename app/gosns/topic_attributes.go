@@ -0,0 +1,106 @@
+package gosns
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/common"
+)
+
+// extractTopicAttributes reads the Attributes.entry.N.key/value pairs of a CreateTopic request.
+func extractTopicAttributes(req *http.Request) map[string]string {
+	attr := map[string]string{}
+	for i := 1; true; i++ {
+		key := req.FormValue("Attributes.entry." + strconv.Itoa(i) + ".key")
+		if key == "" {
+			break
+		}
+		attr[key] = req.FormValue("Attributes.entry." + strconv.Itoa(i) + ".value")
+	}
+	return attr
+}
+
+// setTopicAttribute validates and applies a single topic attribute, returning the
+// name of the SnsErrors entry to respond with if it is invalid or unknown.
+func setTopicAttribute(topic *app.Topic, name string, value string) string {
+	switch name {
+	case "DisplayName":
+		topic.DisplayName = value
+	case "KmsMasterKeyId":
+		if value != "" {
+			app.SyncKeys.RLock()
+			_, err := app.FindKmsKey(value)
+			app.SyncKeys.RUnlock()
+			if err != nil {
+				return "InvalidParameter"
+			}
+		}
+		topic.KmsMasterKeyId = value
+	case "Policy":
+		if !common.IsJSONObject(value) {
+			return "InvalidParameter"
+		}
+		topic.Policy = value
+	case "DeliveryPolicy":
+		if value != "" && !common.IsJSONObject(value) {
+			return "InvalidParameter"
+		}
+		topic.DeliveryPolicy = value
+	case "SignatureVersion":
+		if value != "1" && value != "2" {
+			return "InvalidParameter"
+		}
+		topic.SignatureVersion = value
+	default:
+		return "InvalidParameter"
+	}
+	return ""
+}
+
+// getTopicAttributes returns the attributes reported by GetTopicAttributes.
+func getTopicAttributes(topic *app.Topic) []app.TopicAttributeEntry {
+	pending := 0
+	for _, sub := range topic.Subscriptions {
+		if sub.PendingConfirmation {
+			pending++
+		}
+	}
+	entries := []app.TopicAttributeEntry{
+		{Key: "TopicArn", Value: topic.Arn},
+		{Key: "Owner", Value: app.GetCurrentEnvironment().AccountID},
+		{Key: "DisplayName", Value: topic.DisplayName},
+		{Key: "SubscriptionsConfirmed", Value: strconv.Itoa(len(topic.Subscriptions) - pending)},
+		{Key: "SubscriptionsPending", Value: strconv.Itoa(pending)},
+		{Key: "SubscriptionsDeleted", Value: "0"},
+	}
+	if topic.KmsMasterKeyId != "" {
+		entries = append(entries, app.TopicAttributeEntry{Key: "KmsMasterKeyId", Value: topic.KmsMasterKeyId})
+	}
+	if topic.Policy != "" {
+		entries = append(entries, app.TopicAttributeEntry{Key: "Policy", Value: topic.Policy})
+	}
+	if topic.DeliveryPolicy != "" {
+		entries = append(entries, app.TopicAttributeEntry{Key: "DeliveryPolicy", Value: topic.DeliveryPolicy})
+	}
+	if topic.SignatureVersion != "" {
+		entries = append(entries, app.TopicAttributeEntry{Key: "SignatureVersion", Value: topic.SignatureVersion})
+	}
+	return entries
+}
+
+// checkTopicKmsKey makes sure SNS can generate data keys with a topic's KMS key
+// and returns the name of the SnsErrors entry to respond with if it can't.
+func checkTopicKmsKey(kmsMasterKeyId string) string {
+	if kmsMasterKeyId == "" {
+		return ""
+	}
+	switch app.CheckKmsKeyAccess(kmsMasterKeyId, "kms:GenerateDataKey") {
+	case nil:
+		return ""
+	case app.ErrKmsAccessDenied:
+		return "KMSAccessDenied"
+	default:
+		return "KMSNotFound"
+	}
+}
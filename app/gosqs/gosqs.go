@@ -13,12 +13,15 @@ import (
 
 	"github.com/Admiral-Piett/goaws/app"
 	"github.com/Admiral-Piett/goaws/app/common"
+	"github.com/Admiral-Piett/goaws/app/metrics"
 	"github.com/gorilla/mux"
 )
 
 func init() {
 	app.SyncQueues.Queues = make(map[string]*app.Queue)
 
+	app.OnClockAdvance(runPeriodicTasks)
+
 	app.SqsErrors = make(map[string]app.SqsErrorType)
 	err1 := app.SqsErrorType{HttpError: http.StatusBadRequest, Type: "Not Found", Code: "AWS.SimpleQueueService.NonExistentQueue", Message: "The specified queue does not exist for this wsdl version."}
 	app.SqsErrors["QueueNotFound"] = err1
@@ -40,6 +43,16 @@ func init() {
 	app.SqsErrors["MessageNotInFlight"] = err9
 	err10 := app.SqsErrorType{HttpError: http.StatusBadRequest, Type: "MessageTooBig", Code: "InvalidMessageContents", Message: "The message size exceeds the limit."}
 	app.SqsErrors["MessageTooBig"] = err10
+	err11 := app.SqsErrorType{HttpError: http.StatusBadRequest, Type: "BatchRequestTooLong", Code: "AWS.SimpleQueueService.BatchRequestTooLong", Message: "The length of all the messages put together is more than the limit."}
+	app.SqsErrors["BatchRequestTooLong"] = err11
+	err12 := app.SqsErrorType{HttpError: http.StatusBadRequest, Type: "InvalidBatchEntryId", Code: "AWS.SimpleQueueService.InvalidBatchEntryId", Message: "A batch entry id can only contain alphanumeric characters, hyphens and underscores. It can be at most 80 letters long."}
+	app.SqsErrors["InvalidBatchEntryId"] = err12
+	err13 := app.SqsErrorType{HttpError: http.StatusForbidden, Type: "OverLimit", Code: "OverLimit", Message: "The maximum number of in flight messages has been reached."}
+	app.SqsErrors["OverLimit"] = err13
+	err14 := app.SqsErrorType{HttpError: http.StatusBadRequest, Type: "KmsNotFound", Code: "KMS.NotFoundException", Message: "The KMS key used to encrypt the queue does not exist."}
+	app.SqsErrors["KmsNotFound"] = err14
+	err15 := app.SqsErrorType{HttpError: http.StatusBadRequest, Type: "KmsAccessDenied", Code: "KMS.AccessDeniedException", Message: "The KMS key policy does not allow SQS to use the key."}
+	app.SqsErrors["KmsAccessDenied"] = err15
 	app.SqsErrors[ErrInvalidParameterValue.Type] = *ErrInvalidParameterValue
 	app.SqsErrors[ErrInvalidAttributeValue.Type] = *ErrInvalidAttributeValue
 }
@@ -49,48 +62,78 @@ func PeriodicTasks(d time.Duration, quit <-chan struct{}) {
 	for {
 		select {
 		case <-ticker.C:
-			app.SyncQueues.Lock()
-			for j := range app.SyncQueues.Queues {
-				queue := app.SyncQueues.Queues[j]
-
-				log.Debugf("Queue [%s] length [%d]", queue.Name, len(queue.Messages))
-				for i := 0; i < len(queue.Messages); i++ {
-					msg := &queue.Messages[i]
-
-					// Reset deduplication period
-					for dedupId, startTime := range queue.Duplicates {
-						if time.Now().After(startTime.Add(app.DeduplicationPeriod)) {
-							log.Debugf("deduplication period for message with deduplicationId [%s] expired", dedupId)
-							delete(queue.Duplicates, dedupId)
-						}
-					}
+			runPeriodicTasks()
+		case <-quit:
+			ticker.Stop()
+			return
+		}
+	}
+}
 
-					if msg.ReceiptHandle != "" {
-						if msg.VisibilityTimeout.Before(time.Now()) {
-							log.Debugf("Making message visible again %s", msg.ReceiptHandle)
-							queue.UnlockGroup(msg.GroupID)
-							msg.ReceiptHandle = ""
-							msg.ReceiptTime = time.Now().UTC()
-							msg.Retry++
-							if queue.MaxReceiveCount > 0 &&
-								queue.DeadLetterQueue != nil &&
-								msg.Retry > queue.MaxReceiveCount {
-								queue.DeadLetterQueue.Messages = append(queue.DeadLetterQueue.Messages, *msg)
-								queue.Messages = append(queue.Messages[:i], queue.Messages[i+1:]...)
-								i++
-							}
-						}
+// runPeriodicTasks expires deduplication ids and messages older than the retention
+// period, and makes messages whose visibility timeout has passed visible again,
+// moving them to the dead letter queue if needed.
+func runPeriodicTasks() {
+	app.SyncQueues.Lock()
+	defer app.SyncQueues.Unlock()
+	now := app.Now()
+	for j := range app.SyncQueues.Queues {
+		queue := app.SyncQueues.Queues[j]
+
+		log.Debugf("Queue [%s] length [%d]", queue.Name, len(queue.Messages))
+
+		// Reset deduplication period
+		for dedupId, startTime := range queue.Duplicates {
+			if now.After(startTime.Add(app.DeduplicationPeriod)) {
+				log.Debugf("deduplication period for message with deduplicationId [%s] expired", dedupId)
+				delete(queue.Duplicates, dedupId)
+			}
+		}
+
+		for i := 0; i < len(queue.Messages); i++ {
+			msg := &queue.Messages[i]
+
+			if queue.MessageRetentionPeriod > 0 &&
+				now.After(msg.SentTime.Add(time.Duration(queue.MessageRetentionPeriod)*time.Second)) {
+				log.Debugf("Message %s expired after the retention period of queue [%s]", msg.Uuid, queue.Name)
+				if msg.ReceiptHandle != "" {
+					queue.UnlockGroup(msg.GroupID)
+				}
+				queue.Messages = append(queue.Messages[:i], queue.Messages[i+1:]...)
+				i--
+				continue
+			}
+
+			if msg.ReceiptHandle != "" {
+				if msg.VisibilityTimeout.Before(now) {
+					log.Debugf("Making message visible again %s", msg.ReceiptHandle)
+					queue.UnlockGroup(msg.GroupID)
+					msg.ReceiptHandle = ""
+					msg.ReceiptTime = now.UTC()
+					msg.Retry++
+					if queue.MaxReceiveCount > 0 &&
+						queue.DeadLetterQueue != nil &&
+						msg.Retry > queue.MaxReceiveCount {
+						queue.DeadLetterQueue.Messages = append(queue.DeadLetterQueue.Messages, *msg)
+						queue.Messages = append(queue.Messages[:i], queue.Messages[i+1:]...)
+						metrics.IncDeadLetterMoves(queue.Name, queue.DeadLetterQueue.Name)
+						i--
 					}
 				}
 			}
-			app.SyncQueues.Unlock()
-		case <-quit:
-			ticker.Stop()
-			return
 		}
 	}
 }
 
+// deduplicationId returns the id used to deduplicate a message: the one it was sent
+// with, or the SHA-256 of its body on queues with content based deduplication.
+func deduplicationId(queue *app.Queue, id string, body string) string {
+	if id == "" && queue.IsFIFO && queue.ContentBasedDeduplication {
+		return common.GetSHA256Hash(body)
+	}
+	return id
+}
+
 func ListQueues(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/xml")
 	respStruct := app.ListQueuesResponse{}
@@ -118,26 +161,24 @@ func CreateQueue(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/xml")
 	queueName := req.FormValue("QueueName")
 
-	queueUrl := "http://" + app.CurrentEnvironment.Host + ":" + app.CurrentEnvironment.Port +
-		"/" + app.CurrentEnvironment.AccountID + "/" + queueName
-	if app.CurrentEnvironment.Region != "" {
-		queueUrl = "http://" + app.CurrentEnvironment.Region + "." + app.CurrentEnvironment.Host + ":" +
-			app.CurrentEnvironment.Port + "/" + app.CurrentEnvironment.AccountID + "/" + queueName
-	}
-	queueArn := "arn:aws:sqs:" + app.CurrentEnvironment.Region + ":" + app.CurrentEnvironment.AccountID + ":" + queueName
+	queueUrl := app.QueueURL(queueName)
+	env := app.GetCurrentEnvironment()
+	queueArn := "arn:aws:sqs:" + env.Region + ":" + env.AccountID + ":" + queueName
 
 	if _, ok := app.SyncQueues.Queues[queueName]; !ok {
 		log.Println("Creating Queue:", queueName)
 		queue := &app.Queue{
-			Name:                queueName,
-			URL:                 queueUrl,
-			Arn:                 queueArn,
-			TimeoutSecs:         app.CurrentEnvironment.QueueAttributeDefaults.VisibilityTimeout,
-			ReceiveWaitTimeSecs: app.CurrentEnvironment.QueueAttributeDefaults.ReceiveMessageWaitTimeSeconds,
-			MaximumMessageSize:  app.CurrentEnvironment.QueueAttributeDefaults.MaximumMessageSize,
-			IsFIFO:              app.HasFIFOQueueName(queueName),
-			EnableDuplicates:    app.CurrentEnvironment.EnableDuplicates,
-			Duplicates:          make(map[string]time.Time),
+			Name:                 queueName,
+			URL:                  queueUrl,
+			Arn:                  queueArn,
+			TimeoutSecs:          env.QueueAttributeDefaults.VisibilityTimeout,
+			ReceiveWaitTimeSecs:  env.QueueAttributeDefaults.ReceiveMessageWaitTimeSeconds,
+			MaximumMessageSize:   env.QueueAttributeDefaults.MaximumMessageSize,
+			IsFIFO:               app.HasFIFOQueueName(queueName),
+			EnableDuplicates:     env.EnableDuplicates,
+			Duplicates:           make(map[string]time.Time),
+			DuplicateProbability: env.QueueAttributeDefaults.DuplicateProbability,
+			ReorderProbability:   env.QueueAttributeDefaults.ReorderProbability,
 		}
 		if err := validateAndSetQueueAttributes(queue, req.Form); err != nil {
 			createErrorResponse(w, req, err.Error())
@@ -188,9 +229,22 @@ func SendMessage(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	delaySecs := app.SyncQueues.Queues[queueName].DelaySecs
-	if mv := req.FormValue("DelaySeconds"); mv != "" {
-		delaySecs, _ = strconv.Atoi(mv)
+	if app.SyncQueues.Queues[queueName].IsFIFO && req.FormValue("DelaySeconds") != "" {
+		// FIFO queues only support a queue level delay
+		createErrorResponse(w, req, "InvalidParameterValue")
+		return
+	}
+
+	delaySecs, err := parseDelaySeconds(req.FormValue("DelaySeconds"), app.SyncQueues.Queues[queueName].DelaySecs)
+	if err != nil {
+		log.Println(err)
+		createErrorResponse(w, req, "InvalidParameterValue")
+		return
+	}
+
+	if errName := checkQueueKmsKey(app.SyncQueues.Queues[queueName]); errName != "" {
+		createErrorResponse(w, req, errName)
+		return
 	}
 
 	log.Println("Putting Message in Queue:", queueName)
@@ -202,8 +256,9 @@ func SendMessage(w http.ResponseWriter, req *http.Request) {
 	msg.MD5OfMessageBody = common.GetMD5Hash(messageBody)
 	msg.Uuid, _ = common.NewUUID()
 	msg.GroupID = messageGroupID
+	messageDeduplicationID = deduplicationId(app.SyncQueues.Queues[queueName], messageDeduplicationID, messageBody)
 	msg.DeduplicationID = messageDeduplicationID
-	msg.SentTime = time.Now()
+	msg.SentTime = app.Now()
 	msg.DelaySecs = delaySecs
 
 	app.SyncQueues.Lock()
@@ -248,6 +303,7 @@ type SendEntry struct {
 	MessageAttributes      map[string]app.MessageAttributeValue
 	MessageGroupId         string
 	MessageDeduplicationId string
+	DelaySeconds           string
 }
 
 func SendMessageBatch(w http.ResponseWriter, req *http.Request) {
@@ -264,7 +320,10 @@ func SendMessageBatch(w http.ResponseWriter, req *http.Request) {
 		queueName = uriSegments[len(uriSegments)-1]
 	}
 
-	if _, ok := app.SyncQueues.Queues[queueName]; !ok {
+	app.SyncQueues.RLock()
+	_, ok := app.SyncQueues.Queues[queueName]
+	app.SyncQueues.RUnlock()
+	if !ok {
 		createErrorResponse(w, req, "QueueNotFound")
 		return
 	}
@@ -279,8 +338,12 @@ func SendMessageBatch(w http.ResponseWriter, req *http.Request) {
 				return
 			}
 			keyIndex, err := strconv.Atoi(keySegments[1])
-			if err != nil {
-				createErrorResponse(w, req, "Error")
+			if err != nil || keyIndex < 1 {
+				createErrorResponse(w, req, "GeneralError")
+				return
+			}
+			if keyIndex > common.MaxBatchEntries {
+				createErrorResponse(w, req, "TooManyEntriesInBatchRequest")
 				return
 			}
 
@@ -305,30 +368,74 @@ func SendMessageBatch(w http.ResponseWriter, req *http.Request) {
 			if keySegments[2] == "MessageDeduplicationId" {
 				sendEntries[keyIndex-1].MessageDeduplicationId = v[0]
 			}
+
+			if keySegments[2] == "DelaySeconds" {
+				sendEntries[keyIndex-1].DelaySeconds = v[0]
+			}
 		}
 	}
 
-	if len(sendEntries) == 0 {
-		createErrorResponse(w, req, "EmptyBatchRequest")
+	ids := make([]string, 0, len(sendEntries))
+	totalSize := 0
+	for i := range sendEntries {
+		sendEntries[i].MessageAttributes = extractMessageAttributes(req, fmt.Sprintf("SendMessageBatchRequestEntry.%d", i+1))
+		ids = append(ids, sendEntries[i].Id)
+		totalSize += common.MessageSize(sendEntries[i].MessageBody, sendEntries[i].MessageAttributes)
+	}
+
+	if errName := common.ValidateBatchEntryIds(ids); errName != "" {
+		createErrorResponse(w, req, errName)
 		return
 	}
 
-	if len(sendEntries) > 10 {
-		createErrorResponse(w, req, "TooManyEntriesInBatchRequest")
+	if totalSize > common.MaxBatchPayloadSize {
+		createErrorResponse(w, req, "BatchRequestTooLong")
 		return
 	}
-	ids := map[string]struct{}{}
-	for _, v := range sendEntries {
-		if _, ok := ids[v.Id]; ok {
-			createErrorResponse(w, req, "BatchEntryIdsNotDistinct")
-			return
-		}
-		ids[v.Id] = struct{}{}
+
+	app.SyncQueues.RLock()
+	queue, ok := app.SyncQueues.Queues[queueName]
+	if !ok {
+		app.SyncQueues.RUnlock()
+		createErrorResponse(w, req, "QueueNotFound")
+		return
+	}
+	maximumMessageSize := queue.MaximumMessageSize
+	queueDelaySecs := queue.DelaySecs
+	isFIFO := queue.IsFIFO
+	errName := checkQueueKmsKey(queue)
+	app.SyncQueues.RUnlock()
+
+	if errName != "" {
+		createErrorResponse(w, req, errName)
+		return
 	}
 
 	sentEntries := make([]app.SendMessageBatchResultEntry, 0)
+	failedEntries := make([]app.BatchResultErrorEntry, 0)
 	log.Println("Putting Message in Queue:", queueName)
 	for _, sendEntry := range sendEntries {
+		if sendEntry.MessageBody == "" {
+			failedEntries = append(failedEntries, common.NewBatchResultErrorEntry(sendEntry.Id, "MissingParameter",
+				"The request must contain the parameter MessageBody."))
+			continue
+		}
+		if maximumMessageSize > 0 && common.MessageSize(sendEntry.MessageBody, sendEntry.MessageAttributes) > maximumMessageSize {
+			failedEntries = append(failedEntries, common.NewBatchResultErrorEntry(sendEntry.Id, "InvalidParameterValue",
+				fmt.Sprintf("One or more parameters are invalid. Reason: Message must be shorter than %d bytes.", maximumMessageSize)))
+			continue
+		}
+		if isFIFO && sendEntry.DelaySeconds != "" {
+			failedEntries = append(failedEntries, common.NewBatchResultErrorEntry(sendEntry.Id, "InvalidParameterValue",
+				fmt.Sprintf("Value %s for parameter DelaySeconds is invalid. Reason: The request include parameter that is not valid for this queue type.", sendEntry.DelaySeconds)))
+			continue
+		}
+		delaySecs, err := parseDelaySeconds(sendEntry.DelaySeconds, queueDelaySecs)
+		if err != nil {
+			failedEntries = append(failedEntries, common.NewBatchResultErrorEntry(sendEntry.Id, "InvalidParameterValue", err.Error()))
+			continue
+		}
+
 		msg := app.Message{MessageBody: []byte(sendEntry.MessageBody)}
 		if len(sendEntry.MessageAttributes) > 0 {
 			msg.MessageAttributes = sendEntry.MessageAttributes
@@ -336,22 +443,24 @@ func SendMessageBatch(w http.ResponseWriter, req *http.Request) {
 		}
 		msg.MD5OfMessageBody = common.GetMD5Hash(sendEntry.MessageBody)
 		msg.GroupID = sendEntry.MessageGroupId
-		msg.DeduplicationID = sendEntry.MessageDeduplicationId
 		msg.Uuid, _ = common.NewUUID()
-		msg.SentTime = time.Now()
+		msg.SentTime = app.Now()
+		msg.DelaySecs = delaySecs
 		app.SyncQueues.Lock()
+		sendEntry.MessageDeduplicationId = deduplicationId(queue, sendEntry.MessageDeduplicationId, sendEntry.MessageBody)
+		msg.DeduplicationID = sendEntry.MessageDeduplicationId
 		fifoSeqNumber := ""
-		if app.SyncQueues.Queues[queueName].IsFIFO {
-			fifoSeqNumber = app.SyncQueues.Queues[queueName].NextSequenceNumber(sendEntry.MessageGroupId)
+		if queue.IsFIFO {
+			fifoSeqNumber = queue.NextSequenceNumber(sendEntry.MessageGroupId)
 		}
 
-		if !app.SyncQueues.Queues[queueName].IsDuplicate(sendEntry.MessageDeduplicationId) {
-			app.SyncQueues.Queues[queueName].Messages = append(app.SyncQueues.Queues[queueName].Messages, msg)
+		if !queue.IsDuplicate(sendEntry.MessageDeduplicationId) {
+			queue.Messages = append(queue.Messages, msg)
 		} else {
 			log.Debugf("Message with deduplicationId [%s] in queue [%s] is duplicate ", sendEntry.MessageDeduplicationId, queueName)
 		}
 
-		app.SyncQueues.Queues[queueName].InitDuplicatation(sendEntry.MessageDeduplicationId)
+		queue.InitDuplicatation(sendEntry.MessageDeduplicationId)
 
 		app.SyncQueues.Unlock()
 		se := app.SendMessageBatchResultEntry{
@@ -367,7 +476,7 @@ func SendMessageBatch(w http.ResponseWriter, req *http.Request) {
 
 	respStruct := app.SendMessageBatchResponse{
 		"http://queue.amazonaws.com/doc/2012-11-05/",
-		app.SendMessageBatchResult{Entry: sentEntries},
+		app.SendMessageBatchResult{Entry: sentEntries, Error: failedEntries},
 		app.ResponseMetadata{RequestId: "00000000-0000-0000-0000-000000000001"}}
 
 	enc := xml.NewEncoder(w)
@@ -391,6 +500,7 @@ func ReceiveMessage(w http.ResponseWriter, req *http.Request) {
 	if mom != "" {
 		maxNumberOfMessages, _ = strconv.Atoi(mom)
 	}
+	vt := req.FormValue("VisibilityTimeout")
 
 	queueUrl := getQueueFromPath(req.FormValue("QueueUrl"), req.URL.String())
 
@@ -408,6 +518,15 @@ func ReceiveMessage(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	app.SyncQueues.RLock()
+	visibilityTimeout, err := parseVisibilityTimeout(vt, app.SyncQueues.Queues[queueName].TimeoutSecs)
+	app.SyncQueues.RUnlock()
+	if err != nil {
+		log.Println(err)
+		createErrorResponse(w, req, "InvalidVisibilityTimeout")
+		return
+	}
+
 	var messages []*app.ResultMessage
 	//	respMsg := ResultMessage{}
 	respStruct := app.ReceiveMessageResponse{}
@@ -427,7 +546,10 @@ func ReceiveMessage(w http.ResponseWriter, req *http.Request) {
 			createErrorResponse(w, req, "QueueNotFound")
 			return
 		}
-		messageFound := len(app.SyncQueues.Queues[queueName].Messages)-numberOfHiddenMessagesInQueue(*app.SyncQueues.Queues[queueName]) != 0
+		queue := app.SyncQueues.Queues[queueName]
+		// long polls wait for messages to free up when the queue is at its in flight limit
+		messageFound := len(queue.Messages)-numberOfHiddenMessagesInQueue(*queue) != 0 &&
+			queue.NumberOfInFlightMessages() < queue.InFlightLimit()
 		app.SyncQueues.RUnlock()
 		if !messageFound {
 			continueTimer := time.NewTimer(100 * time.Millisecond)
@@ -435,6 +557,16 @@ func ReceiveMessage(w http.ResponseWriter, req *http.Request) {
 			case <-req.Context().Done():
 				continueTimer.Stop()
 				return // client gave up
+			case <-app.ShuttingDown():
+				continueTimer.Stop()
+				log.Println("Ending long poll of Queue:", queueName, "to shut down")
+				respStruct = app.ReceiveMessageResponse{Xmlns: "http://queue.amazonaws.com/doc/2012-11-05/", Result: app.ReceiveMessageResult{}, Metadata: app.ResponseMetadata{RequestId: "00000000-0000-0000-0000-000000000000"}}
+				enc := xml.NewEncoder(w)
+				enc.Indent("  ", "    ")
+				if err := enc.Encode(respStruct); err != nil {
+					log.Printf("error: %v\n", err)
+				}
+				return
 			case <-continueTimer.C:
 				continueTimer.Stop()
 			}
@@ -447,11 +579,22 @@ func ReceiveMessage(w http.ResponseWriter, req *http.Request) {
 	log.Println("Getting Message from Queue:", queueName)
 
 	app.SyncQueues.Lock() // Lock the Queues
+	inFlight := app.SyncQueues.Queues[queueName].NumberOfInFlightMessages()
+	inFlightLimit := app.SyncQueues.Queues[queueName].InFlightLimit()
+	// SQS only answers OverLimit to short polls of standard queues, FIFO queues
+	// and long polls get no messages instead
+	if inFlight >= inFlightLimit && !app.SyncQueues.Queues[queueName].IsFIFO && waitTimeSeconds == 0 {
+		app.SyncQueues.Unlock()
+		log.Printf("Queue %s has reached its limit of %d in flight messages", queueName, inFlightLimit)
+		createErrorResponse(w, req, "OverLimit")
+		return
+	}
 	if len(app.SyncQueues.Queues[queueName].Messages) > 0 {
 		numMsg := 0
 		messages = make([]*app.ResultMessage, 0)
-		for i := range app.SyncQueues.Queues[queueName].Messages {
-			if numMsg >= maxNumberOfMessages {
+		var duplicates []app.Message
+		for _, i := range receiveOrder(app.SyncQueues.Queues[queueName]) {
+			if numMsg >= maxNumberOfMessages || inFlight >= inFlightLimit {
 				break
 			}
 
@@ -465,9 +608,6 @@ func ReceiveMessage(w http.ResponseWriter, req *http.Request) {
 			if !msg.IsReadyForReceipt() {
 				continue
 			}
-			msg.ReceiptHandle = msg.Uuid + "#" + uuid
-			msg.ReceiptTime = time.Now().UTC()
-			msg.VisibilityTimeout = time.Now().Add(time.Duration(app.SyncQueues.Queues[queueName].TimeoutSecs) * time.Second)
 
 			if app.SyncQueues.Queues[queueName].IsFIFO {
 				// If we got messages here it means we have not processed it yet, so get next
@@ -478,10 +618,24 @@ func ReceiveMessage(w http.ResponseWriter, req *http.Request) {
 				app.SyncQueues.Queues[queueName].LockGroup(msg.GroupID)
 			}
 
+			msg.ReceiptHandle = msg.Uuid + "#" + uuid
+			msg.ReceiptTime = app.Now().UTC()
+			msg.VisibilityTimeout = app.Now().Add(time.Duration(visibilityTimeout) * time.Second)
+			msg.NumberOfReceives++
+
 			messages = append(messages, getMessageResult(msg))
 
+			if isDuplicateDelivery(app.SyncQueues.Queues[queueName]) {
+				// Deliver the message once more later on, as standard queues sometimes do
+				duplicate := *msg
+				duplicate.ReceiptHandle = ""
+				duplicates = append(duplicates, duplicate)
+			}
+
 			numMsg++
+			inFlight++
 		}
+		app.SyncQueues.Queues[queueName].Messages = append(app.SyncQueues.Queues[queueName].Messages, duplicates...)
 
 		//		respMsg = ResultMessage{MessageId: messages.Uuid, ReceiptHandle: messages.ReceiptHandle, MD5OfBody: messages.MD5OfMessageBody, Body: messages.MessageBody, MD5OfMessageAttributes: messages.MD5OfMessageAttributes}
 		respStruct = app.ReceiveMessageResponse{
@@ -505,10 +659,50 @@ func ReceiveMessage(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// receiveOrder returns the indexes of the queue's messages in the order ReceiveMessage
+// should consider them. Standard queues with a ReorderProbability swap messages around
+// to simulate the best effort ordering of SQS.
+func receiveOrder(queue *app.Queue) []int {
+	order := make([]int, len(queue.Messages))
+	for i := range order {
+		order[i] = i
+	}
+	if queue.IsFIFO || queue.ReorderProbability <= 0 {
+		return order
+	}
+	for i := 0; i < len(order)-1; i++ {
+		if app.RandomFloat64() < queue.ReorderProbability {
+			j := i + 1 + app.RandomIntn(len(order)-i-1)
+			order[i], order[j] = order[j], order[i]
+		}
+	}
+	return order
+}
+
+// isDuplicateDelivery decides whether a message received from a standard queue
+// with a DuplicateProbability will be delivered again.
+func isDuplicateDelivery(queue *app.Queue) bool {
+	return !queue.IsFIFO && queue.DuplicateProbability > 0 && app.RandomFloat64() < queue.DuplicateProbability
+}
+
+// numberOfHiddenMessagesInQueue counts the messages in flight or delayed. The
+// random latency isn't accounted for so that the count is deterministic.
 func numberOfHiddenMessagesInQueue(queue app.Queue) int {
 	num := 0
 	for _, m := range queue.Messages {
-		if m.ReceiptHandle != "" || m.DelaySecs > 0 && time.Now().Before(m.SentTime.Add(time.Duration(m.DelaySecs)*time.Second)) {
+		if m.ReceiptHandle != "" || app.Now().Before(m.DelayedUntil()) {
+			num++
+		}
+	}
+	return num
+}
+
+// numberOfDelayedMessagesInQueue counts the messages whose DelaySeconds haven't
+// elapsed yet.
+func numberOfDelayedMessagesInQueue(queue app.Queue) int {
+	num := 0
+	for _, m := range queue.Messages {
+		if m.ReceiptHandle == "" && app.Now().Before(m.DelayedUntil()) {
 			num++
 		}
 	}
@@ -528,13 +722,14 @@ func ChangeMessageVisibility(w http.ResponseWriter, req *http.Request) {
 		queueName = uriSegments[len(uriSegments)-1]
 	}
 	receiptHandle := req.FormValue("ReceiptHandle")
-	visibilityTimeout, err := strconv.Atoi(req.FormValue("VisibilityTimeout"))
-	if err != nil {
-		createErrorResponse(w, req, "ValidationError")
+	if req.FormValue("VisibilityTimeout") == "" {
+		createErrorResponse(w, req, "InvalidVisibilityTimeout")
 		return
 	}
-	if visibilityTimeout > 43200 {
-		createErrorResponse(w, req, "ValidationError")
+	visibilityTimeout, err := parseVisibilityTimeout(req.FormValue("VisibilityTimeout"), 0)
+	if err != nil {
+		log.Println(err)
+		createErrorResponse(w, req, "InvalidVisibilityTimeout")
 		return
 	}
 
@@ -549,11 +744,19 @@ func ChangeMessageVisibility(w http.ResponseWriter, req *http.Request) {
 		queue := app.SyncQueues.Queues[queueName]
 		msgs := queue.Messages
 		if msgs[i].ReceiptHandle == receiptHandle {
+			// A message can't stay in flight for more than 12 hours after it was received
+			inFlightUntil := app.Now().Add(time.Duration(visibilityTimeout) * time.Second)
+			if visibilityTimeout > 0 && inFlightUntil.Sub(msgs[i].ReceiptTime) > maxVisibilityTimeout*time.Second {
+				app.SyncQueues.Unlock()
+				log.Printf("Total VisibilityTimeout for message %s is beyond the limit [%d seconds]", msgs[i].Uuid, maxVisibilityTimeout)
+				createErrorResponse(w, req, "InvalidParameterValue")
+				return
+			}
 			timeout := app.SyncQueues.Queues[queueName].TimeoutSecs
 			if visibilityTimeout == 0 {
-				msgs[i].ReceiptTime = time.Now().UTC()
+				msgs[i].ReceiptTime = app.Now().UTC()
 				msgs[i].ReceiptHandle = ""
-				msgs[i].VisibilityTimeout = time.Now().Add(time.Duration(timeout) * time.Second)
+				msgs[i].VisibilityTimeout = app.Now().Add(time.Duration(timeout) * time.Second)
 				msgs[i].Retry++
 				if queue.MaxReceiveCount > 0 &&
 					queue.DeadLetterQueue != nil &&
@@ -563,7 +766,7 @@ func ChangeMessageVisibility(w http.ResponseWriter, req *http.Request) {
 					i++
 				}
 			} else {
-				msgs[i].VisibilityTimeout = time.Now().Add(time.Duration(visibilityTimeout) * time.Second)
+				msgs[i].VisibilityTimeout = app.Now().Add(time.Duration(visibilityTimeout) * time.Second)
 			}
 			messageFound = true
 			break
@@ -614,9 +817,17 @@ func DeleteMessageBatch(w http.ResponseWriter, req *http.Request) {
 	for k, v := range req.Form {
 		keySegments := strings.Split(k, ".")
 		if keySegments[0] == "DeleteMessageBatchRequestEntry" {
+			if len(keySegments) < 3 {
+				createErrorResponse(w, req, "EmptyBatchRequest")
+				return
+			}
 			keyIndex, err := strconv.Atoi(keySegments[1])
-			if err != nil {
-				createErrorResponse(w, req, "Error")
+			if err != nil || keyIndex < 1 {
+				createErrorResponse(w, req, "GeneralError")
+				return
+			}
+			if keyIndex > common.MaxBatchEntries {
+				createErrorResponse(w, req, "TooManyEntriesInBatchRequest")
 				return
 			}
 
@@ -636,24 +847,37 @@ func DeleteMessageBatch(w http.ResponseWriter, req *http.Request) {
 		}
 	}
 
+	ids := make([]string, 0, len(deleteEntries))
+	for _, deleteEntry := range deleteEntries {
+		ids = append(ids, deleteEntry.Id)
+	}
+	if errName := common.ValidateBatchEntryIds(ids); errName != "" {
+		createErrorResponse(w, req, errName)
+		return
+	}
+
 	deletedEntries := make([]app.DeleteMessageBatchResultEntry, 0)
 
 	app.SyncQueues.Lock()
-	if _, ok := app.SyncQueues.Queues[queueName]; ok {
-		for _, deleteEntry := range deleteEntries {
-			for i, msg := range app.SyncQueues.Queues[queueName].Messages {
-				if msg.ReceiptHandle == deleteEntry.ReceiptHandle {
-					// Unlock messages for the group
-					log.Printf("FIFO Queue %s unlocking group %s:", queueName, msg.GroupID)
-					app.SyncQueues.Queues[queueName].UnlockGroup(msg.GroupID)
-					app.SyncQueues.Queues[queueName].Messages = append(app.SyncQueues.Queues[queueName].Messages[:i], app.SyncQueues.Queues[queueName].Messages[i+1:]...)
-					delete(app.SyncQueues.Queues[queueName].Duplicates, msg.DeduplicationID)
-
-					deleteEntry.Deleted = true
-					deletedEntry := app.DeleteMessageBatchResultEntry{Id: deleteEntry.Id}
-					deletedEntries = append(deletedEntries, deletedEntry)
-					break
-				}
+	if _, ok := app.SyncQueues.Queues[queueName]; !ok {
+		app.SyncQueues.Unlock()
+		createErrorResponse(w, req, "QueueNotFound")
+		return
+	}
+	for j := range deleteEntries {
+		deleteEntry := &deleteEntries[j]
+		for i, msg := range app.SyncQueues.Queues[queueName].Messages {
+			if msg.ReceiptHandle != "" && msg.ReceiptHandle == deleteEntry.ReceiptHandle {
+				// Unlock messages for the group
+				log.Printf("FIFO Queue %s unlocking group %s:", queueName, msg.GroupID)
+				app.SyncQueues.Queues[queueName].UnlockGroup(msg.GroupID)
+				app.SyncQueues.Queues[queueName].Messages = append(app.SyncQueues.Queues[queueName].Messages[:i], app.SyncQueues.Queues[queueName].Messages[i+1:]...)
+				delete(app.SyncQueues.Queues[queueName].Duplicates, msg.DeduplicationID)
+
+				deleteEntry.Deleted = true
+				deletedEntry := app.DeleteMessageBatchResultEntry{Id: deleteEntry.Id}
+				deletedEntries = append(deletedEntries, deletedEntry)
+				break
 			}
 		}
 	}
@@ -661,12 +885,9 @@ func DeleteMessageBatch(w http.ResponseWriter, req *http.Request) {
 
 	notFoundEntries := make([]app.BatchResultErrorEntry, 0)
 	for _, deleteEntry := range deleteEntries {
-		if deleteEntry.Deleted {
-			notFoundEntries = append(notFoundEntries, app.BatchResultErrorEntry{
-				Code:        "1",
-				Id:          deleteEntry.Id,
-				Message:     "Message not found",
-				SenderFault: true})
+		if !deleteEntry.Deleted {
+			notFoundEntries = append(notFoundEntries, common.NewBatchResultErrorEntry(deleteEntry.Id, "ReceiptHandleIsInvalid",
+				"The input receipt handle is invalid."))
 		}
 	}
 
@@ -877,6 +1098,10 @@ func GetQueueAttributes(w http.ResponseWriter, req *http.Request) {
 			attr := app.Attribute{Name: "ApproximateNumberOfMessagesNotVisible", Value: strconv.Itoa(numberOfHiddenMessagesInQueue(*queue))}
 			attribs = append(attribs, attr)
 		}
+		if include_attr("ApproximateNumberOfMessagesDelayed") {
+			attr := app.Attribute{Name: "ApproximateNumberOfMessagesDelayed", Value: strconv.Itoa(numberOfDelayedMessagesInQueue(*queue))}
+			attribs = append(attribs, attr)
+		}
 		if include_attr("CreatedTimestamp") {
 			attr := app.Attribute{Name: "CreatedTimestamp", Value: "0000000000"}
 			attribs = append(attribs, attr)
@@ -885,10 +1110,46 @@ func GetQueueAttributes(w http.ResponseWriter, req *http.Request) {
 			attr := app.Attribute{Name: "LastModifiedTimestamp", Value: "0000000000"}
 			attribs = append(attribs, attr)
 		}
+		if include_attr("KmsMasterKeyId") && queue.KmsMasterKeyId != "" {
+			attr := app.Attribute{Name: "KmsMasterKeyId", Value: queue.KmsMasterKeyId}
+			attribs = append(attribs, attr)
+		}
+		if include_attr("KmsDataKeyReusePeriodSeconds") && queue.KmsMasterKeyId != "" {
+			reusePeriod := queue.KmsDataKeyReuseSecs
+			if reusePeriod == 0 {
+				reusePeriod = app.DefaultKmsDataKeyReusePeriodSeconds
+			}
+			attr := app.Attribute{Name: "KmsDataKeyReusePeriodSeconds", Value: strconv.Itoa(reusePeriod)}
+			attribs = append(attribs, attr)
+		}
+		if include_attr("SqsManagedSseEnabled") {
+			attr := app.Attribute{Name: "SqsManagedSseEnabled", Value: strconv.FormatBool(queue.SqsManagedSse)}
+			attribs = append(attribs, attr)
+		}
 		if include_attr("QueueArn") {
 			attr := app.Attribute{Name: "QueueArn", Value: queue.Arn}
 			attribs = append(attribs, attr)
 		}
+		if include_attr("MessageRetentionPeriod") {
+			retentionPeriod := queue.MessageRetentionPeriod
+			if retentionPeriod == 0 {
+				retentionPeriod = app.DefaultMessageRetentionPeriod
+			}
+			attr := app.Attribute{Name: "MessageRetentionPeriod", Value: strconv.Itoa(retentionPeriod)}
+			attribs = append(attribs, attr)
+		}
+		if include_attr("Policy") && queue.Policy != "" {
+			attr := app.Attribute{Name: "Policy", Value: queue.Policy}
+			attribs = append(attribs, attr)
+		}
+		if include_attr("FifoQueue") && queue.IsFIFO {
+			attr := app.Attribute{Name: "FifoQueue", Value: "true"}
+			attribs = append(attribs, attr)
+		}
+		if include_attr("ContentBasedDeduplication") && queue.IsFIFO {
+			attr := app.Attribute{Name: "ContentBasedDeduplication", Value: strconv.FormatBool(queue.ContentBasedDeduplication)}
+			attribs = append(attribs, attr)
+		}
 
 		deadLetterTargetArn := ""
 		if queue.DeadLetterQueue != nil {
@@ -950,6 +1211,22 @@ func SetQueueAttributes(w http.ResponseWriter, req *http.Request) {
 	app.SyncQueues.Unlock()
 }
 
+// checkQueueKmsKey makes sure SQS can generate data keys with the queue's KMS key
+// and returns the name of the SqsErrors entry to respond with if it can't.
+func checkQueueKmsKey(q *app.Queue) string {
+	if q.KmsMasterKeyId == "" {
+		return ""
+	}
+	switch app.CheckKmsKeyAccess(q.KmsMasterKeyId, "kms:GenerateDataKey") {
+	case nil:
+		return ""
+	case app.ErrKmsAccessDenied:
+		return "KmsAccessDenied"
+	default:
+		return "KmsNotFound"
+	}
+}
+
 func getMessageResult(m *app.Message) *app.ResultMessage {
 	msgMttrs := []*app.ResultMessageAttribute{}
 	for _, attr := range m.MessageAttributes {
@@ -958,9 +1235,9 @@ func getMessageResult(m *app.Message) *app.ResultMessage {
 
 	attrsMap := map[string]string{
 		"ApproximateFirstReceiveTimestamp": fmt.Sprintf("%d", m.ReceiptTime.UnixNano()/int64(time.Millisecond)),
-		"SenderId":                         app.CurrentEnvironment.AccountID,
-		"ApproximateReceiveCount":          fmt.Sprintf("%d", m.NumberOfReceives+1),
-		"SentTimestamp":                    fmt.Sprintf("%d", time.Now().UTC().UnixNano()/int64(time.Millisecond)),
+		"SenderId":                         app.GetCurrentEnvironment().AccountID,
+		"ApproximateReceiveCount":          fmt.Sprintf("%d", m.NumberOfReceives),
+		"SentTimestamp":                    fmt.Sprintf("%d", m.SentTime.UnixNano()/int64(time.Millisecond)),
 	}
 
 	var attrs []*app.ResultAttribute
@@ -7,12 +7,14 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/Admiral-Piett/goaws/app"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestListQueues_POST_NoQueues(t *testing.T) {
@@ -587,6 +589,199 @@ func TestSendMessageBatchToFIFOQueue_POST_Success(t *testing.T) {
 	}
 }
 
+func TestSendMessageBatch_POST_InvalidBatchEntryId(t *testing.T) {
+	req, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.SyncQueues.Queues["testing"] = &app.Queue{Name: "testing"}
+
+	form := url.Values{}
+	form.Add("Action", "SendMessageBatch")
+	form.Add("QueueUrl", "http://localhost:4100/queue/testing")
+	form.Add("SendMessageBatchRequestEntry.1.Id", "test msg 001")
+	form.Add("SendMessageBatchRequestEntry.1.MessageBody", "test%20message%20body%201")
+	form.Add("Version", "2012-11-05")
+	req.PostForm = form
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SendMessageBatch).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+
+	expected := "InvalidBatchEntryId"
+	if !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestSendMessageBatch_POST_BatchRequestTooLong(t *testing.T) {
+	req, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.SyncQueues.Queues["testing"] = &app.Queue{Name: "testing"}
+
+	form := url.Values{}
+	form.Add("Action", "SendMessageBatch")
+	form.Add("QueueUrl", "http://localhost:4100/queue/testing")
+	form.Add("SendMessageBatchRequestEntry.1.Id", "test_msg_001")
+	form.Add("SendMessageBatchRequestEntry.1.MessageBody", strings.Repeat("a", 200000))
+	form.Add("SendMessageBatchRequestEntry.2.Id", "test_msg_002")
+	form.Add("SendMessageBatchRequestEntry.2.MessageBody", strings.Repeat("b", 100000))
+	form.Add("Version", "2012-11-05")
+	req.PostForm = form
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SendMessageBatch).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+
+	expected := "BatchRequestTooLong"
+	if !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestBatch_POST_EntryIndexOutOfRange(t *testing.T) {
+	app.SyncQueues.Queues["testing"] = &app.Queue{Name: "testing"}
+
+	for action, handler := range map[string]http.HandlerFunc{"SendMessageBatch": SendMessageBatch, "DeleteMessageBatch": DeleteMessageBatch} {
+		req, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		form := url.Values{}
+		form.Add("Action", action)
+		form.Add("QueueUrl", "http://localhost:4100/queue/testing")
+		form.Add(action+"RequestEntry.9000000000000.Id", "test_msg_001")
+		form.Add("Version", "2012-11-05")
+		req.PostForm = form
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s returned wrong status code: got %v want %v",
+				action, status, http.StatusBadRequest)
+		}
+
+		expected := "TooManyEntriesInBatchRequest"
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("%s returned unexpected body: got %v want %v",
+				action, rr.Body.String(), expected)
+		}
+	}
+}
+
+func TestSendMessageBatch_POST_PartialFailure(t *testing.T) {
+	req, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.SyncQueues.Queues["testing"] = &app.Queue{Name: "testing", MaximumMessageSize: 10}
+
+	form := url.Values{}
+	form.Add("Action", "SendMessageBatch")
+	form.Add("QueueUrl", "http://localhost:4100/queue/testing")
+	form.Add("SendMessageBatchRequestEntry.1.Id", "ok")
+	form.Add("SendMessageBatchRequestEntry.1.MessageBody", "short")
+	form.Add("SendMessageBatchRequestEntry.1.DelaySeconds", "5")
+	form.Add("SendMessageBatchRequestEntry.2.Id", "too_big")
+	form.Add("SendMessageBatchRequestEntry.2.MessageBody", "this message is too long")
+	form.Add("SendMessageBatchRequestEntry.3.Id", "bad_delay")
+	form.Add("SendMessageBatchRequestEntry.3.MessageBody", "short")
+	form.Add("SendMessageBatchRequestEntry.3.DelaySeconds", "901")
+	form.Add("Version", "2012-11-05")
+	req.PostForm = form
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(SendMessageBatch).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	resp := app.SendMessageBatchResponse{}
+	if err := xml.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unexpected unmarshal error: %s", err)
+	}
+	if len(resp.Result.Entry) != 1 || resp.Result.Entry[0].Id != "ok" {
+		t.Errorf("expected only entry 'ok' to succeed, got %+v", resp.Result.Entry)
+	}
+	if len(resp.Result.Error) != 2 {
+		t.Fatalf("expected 2 failed entries, got %+v", resp.Result.Error)
+	}
+	for _, e := range resp.Result.Error {
+		if e.Code != "InvalidParameterValue" || !e.SenderFault {
+			t.Errorf("unexpected failed entry %+v", e)
+		}
+	}
+
+	messages := app.SyncQueues.Queues["testing"].Messages
+	if len(messages) != 1 || messages[0].DelaySecs != 5 {
+		t.Errorf("expected a single message delayed by 5 seconds, got %+v", messages)
+	}
+}
+
+func TestDeleteMessageBatch_POST_ReceiptHandleIsInvalid(t *testing.T) {
+	req, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.SyncQueues.Queues["testing"] = &app.Queue{Name: "testing"}
+	app.SyncQueues.Queues["testing"].Messages = []app.Message{{
+		MessageBody:   []byte("test1"),
+		ReceiptHandle: "123",
+	}}
+
+	form := url.Values{}
+	form.Add("Action", "DeleteMessageBatch")
+	form.Add("QueueUrl", "http://localhost:4100/queue/testing")
+	form.Add("DeleteMessageBatchRequestEntry.1.Id", "found")
+	form.Add("DeleteMessageBatchRequestEntry.1.ReceiptHandle", "123")
+	form.Add("DeleteMessageBatchRequestEntry.2.Id", "not_found")
+	form.Add("DeleteMessageBatchRequestEntry.2.ReceiptHandle", "456")
+	form.Add("Version", "2012-11-05")
+	req.PostForm = form
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(DeleteMessageBatch).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	resp := app.DeleteMessageBatchResponse{}
+	if err := xml.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unexpected unmarshal error: %s", err)
+	}
+	if len(resp.Result.Entry) != 1 || resp.Result.Entry[0].Id != "found" {
+		t.Errorf("expected entry 'found' to be deleted, got %+v", resp.Result.Entry)
+	}
+	if len(resp.Result.Error) != 1 || resp.Result.Error[0].Id != "not_found" || resp.Result.Error[0].Code != "ReceiptHandleIsInvalid" {
+		t.Errorf("expected entry 'not_found' to fail, got %+v", resp.Result.Error)
+	}
+	if len(app.SyncQueues.Queues["testing"].Messages) != 0 {
+		t.Errorf("expected message to be deleted")
+	}
+}
+
 func TestChangeMessageVisibility_POST_SUCCESS(t *testing.T) {
 	req, err := http.NewRequest("POST", "/", nil)
 	if err != nil {
@@ -629,6 +824,217 @@ func TestChangeMessageVisibility_POST_SUCCESS(t *testing.T) {
 	}
 }
 
+func TestChangeMessageVisibility_POST_BeyondTwelveHours(t *testing.T) {
+	app.SyncQueues.Queues["testing"] = &app.Queue{Name: "testing"}
+	app.SyncQueues.Queues["testing"].Messages = []app.Message{{
+		MessageBody:   []byte("test1"),
+		ReceiptHandle: "123",
+		ReceiptTime:   time.Now().Add(-11 * time.Hour),
+	}}
+
+	changeVisibility := func(timeout string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := url.Values{}
+		form.Add("Action", "ChangeMessageVisibility")
+		form.Add("QueueUrl", "http://localhost:4100/queue/testing")
+		form.Add("VisibilityTimeout", timeout)
+		form.Add("ReceiptHandle", "123")
+		form.Add("Version", "2012-11-05")
+		req.PostForm = form
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(ChangeMessageVisibility).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// 11 hours in flight plus 30 minutes is still within the limit
+	if rr := changeVisibility("1800"); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// 11 hours in flight plus 2 hours is beyond the limit
+	rr := changeVisibility("7200")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	expected := "InvalidParameterValue"
+	if !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// out of range timeouts are rejected up front
+	rr = changeVisibility("43201")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	expected = "ValidationError"
+	if !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+}
+
+func TestReceiveMessage_POST_VisibilityTimeout(t *testing.T) {
+	app.SyncQueues.Queues["testing"] = &app.Queue{Name: "testing", TimeoutSecs: 30}
+	app.SyncQueues.Queues["testing"].Messages = []app.Message{{
+		MessageBody: []byte("test1"),
+		Uuid:        "abc",
+	}}
+
+	receive := func(timeout string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := url.Values{}
+		form.Add("Action", "ReceiveMessage")
+		form.Add("QueueUrl", "http://localhost:4100/queue/testing")
+		form.Add("VisibilityTimeout", timeout)
+		form.Add("Version", "2012-11-05")
+		req.PostForm = form
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(ReceiveMessage).ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := receive("43201"); rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	start := time.Now()
+	rr := receive("600")
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if ok := strings.Contains(rr.Body.String(), "<Message>"); !ok {
+		t.Fatal("handler should return a message")
+	}
+	visibleAt := app.SyncQueues.Queues["testing"].Messages[0].VisibilityTimeout
+	if visibleAt.Before(start.Add(600*time.Second)) || visibleAt.After(time.Now().Add(600*time.Second)) {
+		t.Errorf("expected the message to be hidden for 600 seconds, visible again at %s", visibleAt)
+	}
+}
+
+func TestReceiveMessage_POST_ApproximateReceiveCount(t *testing.T) {
+	app.SyncQueues.Queues["testing"] = &app.Queue{Name: "testing", TimeoutSecs: 30}
+	app.SyncQueues.Queues["testing"].Messages = []app.Message{{
+		MessageBody: []byte("test1"),
+		Uuid:        "abc",
+	}}
+
+	for _, expected := range []string{"1", "2"} {
+		req, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.PostForm = url.Values{"Action": {"ReceiveMessage"}, "QueueUrl": {"http://localhost:4100/queue/testing"}}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(ReceiveMessage).ServeHTTP(rr, req)
+		assert.Regexp(t, `<Name>ApproximateReceiveCount</Name>\s*<Value>`+expected+`</Value>`, rr.Body.String())
+
+		// make the message visible again, like its visibility timeout expiring
+		app.SyncQueues.Queues["testing"].Messages[0].ReceiptHandle = ""
+	}
+}
+
+func TestReceiveMessage_POST_OverLimit(t *testing.T) {
+	app.CurrentEnvironment.InFlightLimits.Standard = 2
+	defer func() {
+		app.CurrentEnvironment.InFlightLimits.Standard = 0
+	}()
+
+	app.SyncQueues.Queues["testing"] = &app.Queue{Name: "testing", TimeoutSecs: 30}
+	app.SyncQueues.Queues["testing"].Messages = []app.Message{
+		{MessageBody: []byte("1"), Uuid: "1"},
+		{MessageBody: []byte("2"), Uuid: "2"},
+		{MessageBody: []byte("3"), Uuid: "3"},
+	}
+
+	receive := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := url.Values{}
+		form.Add("Action", "ReceiveMessage")
+		form.Add("QueueUrl", "http://localhost:4100/queue/testing")
+		form.Add("MaxNumberOfMessages", "10")
+		form.Add("Version", "2012-11-05")
+		req.PostForm = form
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(ReceiveMessage).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// only as many messages as the limit allows are received
+	rr := receive()
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if n := strings.Count(rr.Body.String(), "<Message>"); n != 2 {
+		t.Errorf("expected 2 messages, got %d", n)
+	}
+
+	rr = receive()
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	expected := "<Code>OverLimit</Code>"
+	if !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+
+	// long polls wait and get no messages instead
+	app.SyncQueues.Queues["testing"].ReceiveWaitTimeSecs = 1
+	rr = receive()
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if n := strings.Count(rr.Body.String(), "<Message>"); n != 0 {
+		t.Errorf("expected no messages, got %d", n)
+	}
+}
+
+func TestReceiveMessage_POST_FIFOQueueOverLimit(t *testing.T) {
+	app.CurrentEnvironment.InFlightLimits.FIFO = 1
+	defer func() {
+		app.CurrentEnvironment.InFlightLimits.FIFO = 0
+	}()
+
+	app.SyncQueues.Queues["testing.fifo"] = &app.Queue{Name: "testing.fifo", TimeoutSecs: 30, IsFIFO: true}
+	app.SyncQueues.Queues["testing.fifo"].Messages = []app.Message{
+		{MessageBody: []byte("1"), Uuid: "1", GroupID: "a"},
+		{MessageBody: []byte("2"), Uuid: "2", GroupID: "b"},
+	}
+
+	for _, expected := range []int{1, 0} {
+		req, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := url.Values{}
+		form.Add("Action", "ReceiveMessage")
+		form.Add("QueueUrl", "http://localhost:4100/queue/testing.fifo")
+		form.Add("MaxNumberOfMessages", "10")
+		form.Add("Version", "2012-11-05")
+		req.PostForm = form
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(ReceiveMessage).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		if n := strings.Count(rr.Body.String(), "<Message>"); n != expected {
+			t.Errorf("expected %d messages, got %d", expected, n)
+		}
+	}
+}
+
 func TestRequeueing_VisibilityTimeoutExpires(t *testing.T) {
 	done := make(chan struct{}, 0)
 	go PeriodicTasks(1*time.Second, done)
@@ -896,6 +1302,39 @@ func TestRequeueing_ResetVisibilityTimeout(t *testing.T) {
 	done <- struct{}{}
 }
 
+func TestDeadLetterQueue_MovesEveryExpiredMessage(t *testing.T) {
+	deadLetterQueue := &app.Queue{Name: "dlq-moves-failed", Messages: []app.Message{}}
+	queue := &app.Queue{Name: "dlq-moves", MaxReceiveCount: 1, DeadLetterQueue: deadLetterQueue}
+	for _, body := range []string{"1", "2", "3"} {
+		queue.Messages = append(queue.Messages, app.Message{
+			MessageBody:       []byte(body),
+			ReceiptHandle:     "handle-" + body,
+			VisibilityTimeout: time.Now().Add(-time.Minute),
+			Retry:             1,
+		})
+	}
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["dlq-moves"] = queue
+	app.SyncQueues.Queues["dlq-moves-failed"] = deadLetterQueue
+	app.SyncQueues.Unlock()
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "dlq-moves")
+		delete(app.SyncQueues.Queues, "dlq-moves-failed")
+		app.SyncQueues.Unlock()
+	}()
+
+	runPeriodicTasks()
+
+	// adjacent messages are all moved by the same run
+	app.SyncQueues.RLock()
+	defer app.SyncQueues.RUnlock()
+	if len(queue.Messages) != 0 || len(deadLetterQueue.Messages) != 3 {
+		t.Errorf("expected every message to be moved to the dead letter queue, got %d left and %d moved",
+			len(queue.Messages), len(deadLetterQueue.Messages))
+	}
+}
+
 func TestDeadLetterQueue(t *testing.T) {
 	done := make(chan struct{}, 0)
 	go PeriodicTasks(1*time.Second, done)
@@ -1850,6 +2289,53 @@ func TestSendMessage_POST_DuplicatationEnabledOnFifoQueue(t *testing.T) {
 	}
 }
 
+func TestSendMessage_POST_InvalidDelaySeconds(t *testing.T) {
+	app.SyncQueues.Queues["testing"] = &app.Queue{Name: "testing"}
+	app.SyncQueues.Queues["testing.fifo"] = &app.Queue{Name: "testing.fifo", IsFIFO: true}
+
+	cases := map[string]struct {
+		queueName    string
+		delaySeconds string
+	}{
+		"out_of_range": {"testing", "901"},
+		"negative":     {"testing", "-1"},
+		"not_a_number": {"testing", "ten"},
+		"fifo_queue":   {"testing.fifo", "5"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			form := url.Values{}
+			form.Add("Action", "SendMessage")
+			form.Add("QueueUrl", "http://localhost:4100/queue/"+c.queueName)
+			form.Add("MessageBody", "1")
+			form.Add("MessageGroupId", "GROUP-X")
+			form.Add("DelaySeconds", c.delaySeconds)
+			form.Add("Version", "2012-11-05")
+			req.PostForm = form
+
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(SendMessage).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, http.StatusBadRequest)
+			}
+			expected := "InvalidParameterValue"
+			if !strings.Contains(rr.Body.String(), expected) {
+				t.Errorf("handler returned unexpected body: got %v want %v",
+					rr.Body.String(), expected)
+			}
+			if len(app.SyncQueues.Queues[c.queueName].Messages) != 0 {
+				t.Errorf("message should not be added to queue")
+			}
+		})
+	}
+}
+
 func TestSendMessage_POST_DelaySeconds(t *testing.T) {
 	// create a queue
 	req, err := http.NewRequest("POST", "/", nil)
@@ -2100,3 +2586,350 @@ func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
 		return true // timed out
 	}
 }
+
+func TestQueueEncryption_KmsMasterKeyId(t *testing.T) {
+	app.SyncKeys.Keys["sqs-key"] = &app.KmsKey{
+		KeyId:    "sqs-key",
+		Policy:   `{"Statement": [{"Effect": "Deny", "Action": "kms:GenerateDataKey"}]}`,
+		Material: make([]byte, 32),
+	}
+	defer delete(app.SyncKeys.Keys, "sqs-key")
+
+	createQueue := func(keyId string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := url.Values{}
+		form.Add("Action", "CreateQueue")
+		form.Add("QueueName", "encrypted-queue")
+		form.Add("Attribute.1.Name", "KmsMasterKeyId")
+		form.Add("Attribute.1.Value", keyId)
+		form.Add("Version", "2012-11-05")
+		req.PostForm = form
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(CreateQueue).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := createQueue("unknown-key")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr = createQueue("sqs-key")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "encrypted-queue")
+		app.SyncQueues.Unlock()
+	}()
+
+	req, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{}
+	form.Add("Action", "GetQueueAttributes")
+	form.Add("QueueUrl", "http://localhost:4100/queue/encrypted-queue")
+	form.Add("AttributeName.1", "All")
+	form.Add("Version", "2012-11-05")
+	req.PostForm = form
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(GetQueueAttributes).ServeHTTP(rr, req)
+	for _, expected := range []string{
+		"<Value>sqs-key</Value>",
+		"<Name>KmsDataKeyReusePeriodSeconds</Name>",
+		"<Name>SqsManagedSseEnabled</Name>",
+	} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+		}
+	}
+
+	// the key policy doesn't let SQS generate data keys
+	req, err = http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form = url.Values{}
+	form.Add("Action", "SendMessage")
+	form.Add("QueueUrl", "http://localhost:4100/queue/encrypted-queue")
+	form.Add("MessageBody", "Test123")
+	form.Add("Version", "2012-11-05")
+	req.PostForm = form
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(SendMessage).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	expected := "<Code>KMS.AccessDeniedException</Code>"
+	if !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+}
+
+func TestRequeueing_AdvanceClock(t *testing.T) {
+	app.SetClock(app.NewManualClock(time.Now()))
+	defer app.SetClock(nil)
+
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["advance-clock"] = &app.Queue{
+		Name:        "advance-clock",
+		TimeoutSecs: 30,
+		Messages:    []app.Message{{Uuid: "1", MessageBody: []byte("1"), SentTime: app.Now()}},
+	}
+	app.SyncQueues.Unlock()
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "advance-clock")
+		app.SyncQueues.Unlock()
+	}()
+
+	receive := func() string {
+		req, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := url.Values{}
+		form.Add("Action", "ReceiveMessage")
+		form.Add("QueueUrl", "http://localhost:4100/queue/advance-clock")
+		form.Add("Version", "2012-11-05")
+		req.PostForm = form
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(ReceiveMessage).ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	if !strings.Contains(receive(), "<Message>") {
+		t.Fatal("handler should return a message")
+	}
+	app.AdvanceClock(29 * time.Second)
+	if strings.Contains(receive(), "<Message>") {
+		t.Fatal("handler should not return a message before the visibility timeout expires")
+	}
+	// no sleeping and no PeriodicTasks running: advancing the clock requeues the message
+	app.AdvanceClock(2 * time.Second)
+	if !strings.Contains(receive(), "<Message>") {
+		t.Fatal("handler should return the message after the visibility timeout expires")
+	}
+}
+
+func receiveMessageIds(t *testing.T, queueName string, maxNumberOfMessages string) []string {
+	req, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{}
+	form.Add("Action", "ReceiveMessage")
+	form.Add("QueueUrl", "http://localhost:4100/queue/"+queueName)
+	form.Add("MaxNumberOfMessages", maxNumberOfMessages)
+	form.Add("Version", "2012-11-05")
+	req.PostForm = form
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ReceiveMessage).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	ids := []string{}
+	for _, part := range strings.Split(rr.Body.String(), "<MessageId>")[1:] {
+		ids = append(ids, strings.Split(part, "</MessageId>")[0])
+	}
+	return ids
+}
+
+func TestReceiveMessage_DuplicateProbability(t *testing.T) {
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["duplicates"] = &app.Queue{
+		Name:                 "duplicates",
+		TimeoutSecs:          30,
+		DuplicateProbability: 1,
+		Messages:             []app.Message{{Uuid: "1", MessageBody: []byte("1")}},
+	}
+	app.SyncQueues.Queues["duplicates.fifo"] = &app.Queue{
+		Name:                 "duplicates.fifo",
+		TimeoutSecs:          30,
+		IsFIFO:               true,
+		DuplicateProbability: 1,
+		Messages:             []app.Message{{Uuid: "1", MessageBody: []byte("1"), GroupID: "1"}},
+	}
+	app.SyncQueues.Unlock()
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "duplicates")
+		delete(app.SyncQueues.Queues, "duplicates.fifo")
+		app.SyncQueues.Unlock()
+	}()
+
+	assert.Equal(t, []string{"1"}, receiveMessageIds(t, "duplicates", "1"))
+	// the message is in flight, its duplicate is delivered anyway
+	assert.Equal(t, []string{"1"}, receiveMessageIds(t, "duplicates", "1"))
+
+	assert.Equal(t, []string{"1"}, receiveMessageIds(t, "duplicates.fifo", "1"))
+	assert.Empty(t, receiveMessageIds(t, "duplicates.fifo", "1"))
+}
+
+func TestReceiveMessage_ReorderProbability(t *testing.T) {
+	messages := func() []app.Message {
+		m := []app.Message{}
+		for i := 0; i < 10; i++ {
+			id := strconv.Itoa(i)
+			m = append(m, app.Message{Uuid: id, MessageBody: []byte(id), GroupID: "1"})
+		}
+		return m
+	}
+	inOrder := []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}
+
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["reorder"] = &app.Queue{Name: "reorder", TimeoutSecs: 30, ReorderProbability: 1, Messages: messages()}
+	app.SyncQueues.Queues["reorder.fifo"] = &app.Queue{Name: "reorder.fifo", TimeoutSecs: 30, IsFIFO: true, ReorderProbability: 1, Messages: messages()}
+	app.SyncQueues.Unlock()
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "reorder")
+		delete(app.SyncQueues.Queues, "reorder.fifo")
+		app.SyncQueues.Unlock()
+	}()
+
+	app.SeedRandom(1)
+	reordered := receiveMessageIds(t, "reorder", "10")
+	assert.ElementsMatch(t, inOrder, reordered)
+	assert.NotEqual(t, inOrder, reordered)
+
+	// the same seed gives the same order
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["reorder"].Messages = messages()
+	app.SyncQueues.Unlock()
+	app.SeedRandom(1)
+	assert.Equal(t, reordered, receiveMessageIds(t, "reorder", "10"))
+
+	// FIFO queues keep their order, a group only delivers its first message
+	assert.Equal(t, []string{"0"}, receiveMessageIds(t, "reorder.fifo", "10"))
+}
+
+func TestSendMessage_POST_ContentBasedDeduplication(t *testing.T) {
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["content-dedup.fifo"] = &app.Queue{
+		Name:                      "content-dedup.fifo",
+		IsFIFO:                    true,
+		EnableDuplicates:          true,
+		ContentBasedDeduplication: true,
+		Duplicates:                make(map[string]time.Time),
+	}
+	app.SyncQueues.Unlock()
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "content-dedup.fifo")
+		app.SyncQueues.Unlock()
+	}()
+
+	for _, body := range []string{"same", "same", "different"} {
+		req, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := url.Values{}
+		form.Add("Action", "SendMessage")
+		form.Add("QueueUrl", "http://localhost:4100/queue/content-dedup.fifo")
+		form.Add("MessageBody", body)
+		form.Add("MessageGroupId", "1")
+		form.Add("Version", "2012-11-05")
+		req.PostForm = form
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(SendMessage).ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+	}
+
+	app.SyncQueues.RLock()
+	defer app.SyncQueues.RUnlock()
+	if n := len(app.SyncQueues.Queues["content-dedup.fifo"].Messages); n != 2 {
+		t.Errorf("expected the duplicate body to be dropped, got %d messages", n)
+	}
+}
+
+func TestMessageRetentionPeriod(t *testing.T) {
+	app.SetClock(app.NewManualClock(time.Now()))
+	defer app.SetClock(nil)
+
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["retention"] = &app.Queue{
+		Name:                   "retention",
+		MessageRetentionPeriod: 60,
+		Messages: []app.Message{
+			{Uuid: "1", MessageBody: []byte("1"), SentTime: app.Now().Add(-30 * time.Second)},
+			{Uuid: "2", MessageBody: []byte("2"), SentTime: app.Now()},
+		},
+	}
+	app.SyncQueues.Unlock()
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "retention")
+		app.SyncQueues.Unlock()
+	}()
+
+	app.AdvanceClock(45 * time.Second)
+	app.SyncQueues.RLock()
+	messages := app.SyncQueues.Queues["retention"].Messages
+	app.SyncQueues.RUnlock()
+	if len(messages) != 1 || messages[0].Uuid != "2" {
+		t.Errorf("expected only the message older than the retention period to be deleted, got %v", messages)
+	}
+}
+
+func TestReceiveMessage_LongPollEndsOnShutdown(t *testing.T) {
+	defer func() {
+		app.ResetShutdown()
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "shutdown-queue")
+		app.SyncQueues.Unlock()
+	}()
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["shutdown-queue"] = &app.Queue{Name: "shutdown-queue", ReceiveWaitTimeSecs: 20}
+	app.SyncQueues.Unlock()
+
+	done := make(chan []string)
+	go func() {
+		done <- receiveMessageIds(t, "shutdown-queue", "1")
+	}()
+	time.Sleep(100 * time.Millisecond) // let the receive start waiting
+	app.BeginShutdown()
+
+	select {
+	case ids := <-done:
+		assert.Empty(t, ids)
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the long poll to end when shutting down")
+	}
+}
+
+func TestNumberOfHiddenAndDelayedMessages_IgnoreRandomLatency(t *testing.T) {
+	latency := app.CurrentEnvironment.RandomLatency
+	app.CurrentEnvironment.RandomLatency = app.RandomLatency{Min: 60000, Max: 120000}
+	defer func() { app.CurrentEnvironment.RandomLatency = latency }()
+
+	now := app.Now()
+	queue := app.Queue{Name: "latency", Messages: []app.Message{
+		{MessageBody: []byte("visible"), SentTime: now.Add(-time.Second)},
+		{MessageBody: []byte("delayed"), SentTime: now, DelaySecs: 60},
+		{MessageBody: []byte("in flight"), SentTime: now.Add(-time.Second), ReceiptHandle: "handle"},
+	}}
+	for i := 0; i < 10; i++ {
+		if n := numberOfHiddenMessagesInQueue(queue); n != 2 {
+			t.Fatalf("expected 2 hidden messages, got %d", n)
+		}
+		if n := numberOfDelayedMessagesInQueue(queue); n != 1 {
+			t.Fatalf("expected 1 delayed message, got %d", n)
+		}
+	}
+}
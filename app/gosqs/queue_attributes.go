@@ -9,6 +9,15 @@ import (
 	"strings"
 
 	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/common"
+)
+
+const (
+	// maxDelaySeconds is the upper bound for both the queue and per-message DelaySeconds.
+	maxDelaySeconds = 900
+	// maxVisibilityTimeout is the upper bound for a visibility timeout, and for the
+	// total time a message may stay in flight after being received.
+	maxVisibilityTimeout = 43200 // 12 hours
 )
 
 var (
@@ -22,17 +31,21 @@ var (
 		HttpError: http.StatusBadRequest,
 		Type:      "InvalidAttributeValue",
 		Code:      "AWS.SimpleQueueService.InvalidAttributeValue",
-		Message:   "Invalid Value for the parameter RedrivePolicy.",
+		Message:   "An invalid value was supplied for one of the queue attributes.",
 	}
 )
 
 // validateAndSetQueueAttributes applies the requested queue attributes to the given
 // queue.
-// TODO Currently it only supports VisibilityTimeout, MaximumMessageSize, DelaySeconds, RedrivePolicy and ReceiveMessageWaitTimeSeconds  attributes.
+// TODO Currently it only supports VisibilityTimeout, MaximumMessageSize, DelaySeconds, RedrivePolicy, ReceiveMessageWaitTimeSeconds,
+// MessageRetentionPeriod, Policy, ContentBasedDeduplication and the server-side encryption attributes.
 func validateAndSetQueueAttributes(q *app.Queue, u url.Values) error {
 	attr := extractQueueAttributes(u)
-	visibilityTimeout, _ := strconv.Atoi(attr["VisibilityTimeout"])
-	if visibilityTimeout != 0 {
+	if strVisibilityTimeout, ok := attr["VisibilityTimeout"]; ok {
+		visibilityTimeout, err := parseVisibilityTimeout(strVisibilityTimeout, q.TimeoutSecs)
+		if err != nil {
+			return ErrInvalidAttributeValue
+		}
 		q.TimeoutSecs = visibilityTimeout
 	}
 	receiveWaitTime, _ := strconv.Atoi(attr["ReceiveMessageWaitTimeSeconds"])
@@ -78,14 +91,92 @@ func validateAndSetQueueAttributes(q *app.Queue, u url.Values) error {
 		q.DeadLetterQueue = deadLetterQueue
 		q.MaxReceiveCount = maxReceiveCount
 	}
-	delaySecs, _ := strconv.Atoi(attr["DelaySeconds"])
-	if delaySecs != 0 {
+	if strDelaySecs, ok := attr["DelaySeconds"]; ok {
+		delaySecs, err := parseDelaySeconds(strDelaySecs, q.DelaySecs)
+		if err != nil {
+			return ErrInvalidAttributeValue
+		}
 		q.DelaySecs = delaySecs
+		// A FIFO queue's delay applies to every message that hasn't been delivered yet,
+		// while standard queues keep the delay each message was sent with.
+		if q.IsFIFO {
+			for i := range q.Messages {
+				if q.Messages[i].ReceiptHandle == "" {
+					q.Messages[i].DelaySecs = delaySecs
+				}
+			}
+		}
+	}
+
+	if strRetentionPeriod, ok := attr["MessageRetentionPeriod"]; ok {
+		retentionPeriod, err := strconv.Atoi(strRetentionPeriod)
+		if err != nil || retentionPeriod < 60 || retentionPeriod > 1209600 {
+			return ErrInvalidAttributeValue
+		}
+		q.MessageRetentionPeriod = retentionPeriod
+	}
+	if policy, ok := attr["Policy"]; ok {
+		if !common.IsJSONObject(policy) {
+			return ErrInvalidAttributeValue
+		}
+		q.Policy = policy
+	}
+	if strContentBasedDedup, ok := attr["ContentBasedDeduplication"]; ok {
+		contentBasedDedup, err := strconv.ParseBool(strContentBasedDedup)
+		if err != nil || (contentBasedDedup && !q.IsFIFO) {
+			return ErrInvalidAttributeValue
+		}
+		q.ContentBasedDeduplication = contentBasedDedup
+	}
+
+	if err := setQueueEncryptionAttributes(q, attr); err != nil {
+		return err
 	}
 
 	return nil
 }
 
+// setQueueEncryptionAttributes applies the server-side encryption attributes. A queue
+// is either encrypted with a KMS key or with SQS managed keys, never both.
+func setQueueEncryptionAttributes(q *app.Queue, attr map[string]string) error {
+	kmsMasterKeyId := q.KmsMasterKeyId
+	if keyId, ok := attr["KmsMasterKeyId"]; ok {
+		app.SyncKeys.RLock()
+		_, err := app.FindKmsKey(keyId)
+		app.SyncKeys.RUnlock()
+		if err != nil {
+			return ErrInvalidAttributeValue
+		}
+		kmsMasterKeyId = keyId
+	}
+	kmsDataKeyReuseSecs := q.KmsDataKeyReuseSecs
+	if strReusePeriod, ok := attr["KmsDataKeyReusePeriodSeconds"]; ok {
+		reusePeriod, err := strconv.Atoi(strReusePeriod)
+		if err != nil || reusePeriod < 60 || reusePeriod > 86400 {
+			return ErrInvalidAttributeValue
+		}
+		kmsDataKeyReuseSecs = reusePeriod
+	}
+	sqsManagedSse := q.SqsManagedSse
+	if strManagedSse, ok := attr["SqsManagedSseEnabled"]; ok {
+		managedSse, err := strconv.ParseBool(strManagedSse)
+		if err != nil {
+			return ErrInvalidAttributeValue
+		}
+		sqsManagedSse = managedSse
+	} else if _, ok := attr["KmsMasterKeyId"]; ok {
+		sqsManagedSse = false
+	}
+	if sqsManagedSse && kmsMasterKeyId != "" {
+		return ErrInvalidAttributeValue
+	}
+
+	q.KmsMasterKeyId = kmsMasterKeyId
+	q.KmsDataKeyReuseSecs = kmsDataKeyReuseSecs
+	q.SqsManagedSse = sqsManagedSse
+	return nil
+}
+
 func extractQueueAttributes(u url.Values) map[string]string {
 	attr := map[string]string{}
 	for i := 1; true; i++ {
@@ -103,3 +194,29 @@ func extractQueueAttributes(u url.Values) map[string]string {
 	}
 	return attr
 }
+
+// parseDelaySeconds parses a per-message DelaySeconds value, falling back to
+// the queue default when it isn't set.
+func parseDelaySeconds(value string, defaultDelay int) (int, error) {
+	if value == "" {
+		return defaultDelay, nil
+	}
+	delaySecs, err := strconv.Atoi(value)
+	if err != nil || delaySecs < 0 || delaySecs > maxDelaySeconds {
+		return 0, fmt.Errorf("Value %s for parameter DelaySeconds is invalid. Reason: DelaySeconds must be >= 0 and <= %d.", value, maxDelaySeconds)
+	}
+	return delaySecs, nil
+}
+
+// parseVisibilityTimeout parses a VisibilityTimeout value, falling back to the
+// queue default when it isn't set.
+func parseVisibilityTimeout(value string, defaultTimeout int) (int, error) {
+	if value == "" {
+		return defaultTimeout, nil
+	}
+	visibilityTimeout, err := strconv.Atoi(value)
+	if err != nil || visibilityTimeout < 0 || visibilityTimeout > maxVisibilityTimeout {
+		return 0, fmt.Errorf("Value %s for parameter VisibilityTimeout is invalid. Reason: VisibilityTimeout must be >= 0 and <= %d.", value, maxVisibilityTimeout)
+	}
+	return visibilityTimeout, nil
+}
@@ -59,6 +59,46 @@ func TestApplyQueueAttributes(t *testing.T) {
 			t.Fatalf("expected %s, got %s", ErrInvalidAttributeValue, err)
 		}
 	})
+	t.Run("invalid_delay_seconds", func(t *testing.T) {
+		q := &app.Queue{TimeoutSecs: 30}
+		u := url.Values{}
+		u.Add("Attribute.1.Name", "DelaySeconds")
+		u.Add("Attribute.1.Value", "901")
+		err := validateAndSetQueueAttributes(q, u)
+		if err != ErrInvalidAttributeValue {
+			t.Fatalf("expected %s, got %s", ErrInvalidAttributeValue, err)
+		}
+	})
+	t.Run("reset_delay_seconds", func(t *testing.T) {
+		q := &app.Queue{DelaySecs: 30}
+		u := url.Values{}
+		u.Add("Attribute.1.Name", "DelaySeconds")
+		u.Add("Attribute.1.Value", "0")
+		if err := validateAndSetQueueAttributes(q, u); err != nil {
+			t.Fatalf("expected nil, got %s", err)
+		}
+		if q.DelaySecs != 0 {
+			t.Fatalf("expected DelaySecs 0, got %d", q.DelaySecs)
+		}
+	})
+	t.Run("fifo_delay_applies_to_pending_messages", func(t *testing.T) {
+		q := &app.Queue{
+			IsFIFO: true,
+			Messages: []app.Message{
+				{Uuid: "pending", DelaySecs: 30},
+				{Uuid: "in-flight", DelaySecs: 30, ReceiptHandle: "handle"},
+			},
+		}
+		u := url.Values{}
+		u.Add("Attribute.1.Name", "DelaySeconds")
+		u.Add("Attribute.1.Value", "5")
+		if err := validateAndSetQueueAttributes(q, u); err != nil {
+			t.Fatalf("expected nil, got %s", err)
+		}
+		if q.Messages[0].DelaySecs != 5 || q.Messages[1].DelaySecs != 30 {
+			t.Fatalf("unexpected message delays %+v", q.Messages)
+		}
+	})
 }
 
 func TestExtractQueueAttributes(t *testing.T) {
@@ -77,3 +117,31 @@ func TestExtractQueueAttributes(t *testing.T) {
 		t.Fatalf("expected %+v, got %+v", expected, attr)
 	}
 }
+
+func TestParseDelaySeconds(t *testing.T) {
+	if d, err := parseDelaySeconds("", 7); err != nil || d != 7 {
+		t.Errorf("expected queue default 7, got %d (%v)", d, err)
+	}
+	if d, err := parseDelaySeconds("900", 0); err != nil || d != 900 {
+		t.Errorf("expected 900, got %d (%v)", d, err)
+	}
+	for _, v := range []string{"-1", "901", "abc"} {
+		if _, err := parseDelaySeconds(v, 0); err == nil {
+			t.Errorf("expected error for %q", v)
+		}
+	}
+}
+
+func TestParseVisibilityTimeout(t *testing.T) {
+	if v, err := parseVisibilityTimeout("", 30); err != nil || v != 30 {
+		t.Errorf("expected queue default 30, got %d (%v)", v, err)
+	}
+	if v, err := parseVisibilityTimeout("43200", 30); err != nil || v != 43200 {
+		t.Errorf("expected 43200, got %d (%v)", v, err)
+	}
+	for _, v := range []string{"-1", "43201", "abc"} {
+		if _, err := parseVisibilityTimeout(v, 30); err == nil {
+			t.Errorf("expected error for %q", v)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type KmsErrorType struct {
+	HttpError int
+	Type      string
+	Message   string
+}
+
+func (k *KmsErrorType) Error() string {
+	return k.Type
+}
+
+var (
+	ErrKmsNotFound = &KmsErrorType{
+		HttpError: http.StatusBadRequest,
+		Type:      "NotFoundException",
+		Message:   "The specified KMS key does not exist.",
+	}
+	ErrKmsAccessDenied = &KmsErrorType{
+		HttpError: http.StatusBadRequest,
+		Type:      "AccessDeniedException",
+		Message:   "The key policy does not allow the requested operation.",
+	}
+	ErrKmsIncorrectKey = &KmsErrorType{
+		HttpError: http.StatusBadRequest,
+		Type:      "IncorrectKeyException",
+		Message:   "The ciphertext was not encrypted under the specified KMS key.",
+	}
+)
+
+type KmsKey struct {
+	KeyId        string
+	Arn          string
+	Description  string
+	KeyUsage     string
+	KeyState     string
+	CreationDate time.Time
+	Policy       string
+	Material     []byte
+}
+
+// Aliases of the keys AWS manages on behalf of SQS and SNS. They are always available.
+const (
+	KmsAliasSQS = "alias/aws/sqs"
+	KmsAliasSNS = "alias/aws/sns"
+)
+
+var SyncKeys = struct {
+	sync.RWMutex
+	Keys map[string]*KmsKey
+}{Keys: make(map[string]*KmsKey)}
+
+// FindKmsKey looks up a key by id, key ARN, or the alias of an AWS managed key.
+// The caller must hold a lock on SyncKeys.
+func FindKmsKey(keyId string) (*KmsKey, error) {
+	if keyId == "" {
+		return nil, ErrKmsNotFound
+	}
+	if strings.HasPrefix(keyId, "alias/") || strings.Contains(keyId, ":alias/") {
+		alias := keyId[strings.Index(keyId, "alias/"):]
+		if alias == KmsAliasSQS || alias == KmsAliasSNS {
+			return &KmsKey{KeyId: alias, KeyState: "Enabled"}, nil
+		}
+		return nil, ErrKmsNotFound
+	}
+	segments := strings.Split(keyId, "/")
+	if key, ok := SyncKeys.Keys[segments[len(segments)-1]]; ok {
+		return key, nil
+	}
+	return nil, ErrKmsNotFound
+}
+
+// CheckKmsKeyAccess returns an error if the given key does not exist or its
+// policy does not allow the given action, e.g. "kms:GenerateDataKey".
+func CheckKmsKeyAccess(keyId string, action string) error {
+	SyncKeys.RLock()
+	defer SyncKeys.RUnlock()
+	key, err := FindKmsKey(keyId)
+	if err != nil {
+		return err
+	}
+	if !key.Allows(action) {
+		return ErrKmsAccessDenied
+	}
+	return nil
+}
+
+type kmsPolicy struct {
+	Statement []struct {
+		Effect string
+		Action interface{}
+	}
+}
+
+// Allows evaluates the key policy for the given action. Principals and conditions
+// are ignored: an explicit Deny wins, and a key without a policy allows everything.
+func (k *KmsKey) Allows(action string) bool {
+	if k.Policy == "" {
+		return true
+	}
+	policy := kmsPolicy{}
+	if err := json.Unmarshal([]byte(k.Policy), &policy); err != nil {
+		return false
+	}
+	allowed := false
+	for _, statement := range policy.Statement {
+		if !kmsActionMatches(statement.Action, action) {
+			continue
+		}
+		if strings.EqualFold(statement.Effect, "Deny") {
+			return false
+		}
+		if strings.EqualFold(statement.Effect, "Allow") {
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// ValidateKmsPolicy returns an error if the policy isn't a JSON policy document.
+func ValidateKmsPolicy(policy string) error {
+	if policy == "" {
+		return nil
+	}
+	p := kmsPolicy{}
+	if err := json.Unmarshal([]byte(policy), &p); err != nil {
+		return err
+	}
+	if len(p.Statement) == 0 {
+		return errors.New("policy has no statements")
+	}
+	return nil
+}
+
+func kmsActionMatches(statementAction interface{}, action string) bool {
+	var actions []string
+	switch a := statementAction.(type) {
+	case string:
+		actions = []string{a}
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok {
+				actions = append(actions, s)
+			}
+		}
+	}
+	for _, a := range actions {
+		if a == "*" || strings.EqualFold(a, "kms:*") || strings.EqualFold(a, action) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package app
+
+/*** Key Metadata ***/
+type KeyMetadata struct {
+	AWSAccountId string  `json:"AWSAccountId"`
+	Arn          string  `json:"Arn"`
+	CreationDate float64 `json:"CreationDate"`
+	Description  string  `json:"Description"`
+	Enabled      bool    `json:"Enabled"`
+	KeyId        string  `json:"KeyId"`
+	KeyManager   string  `json:"KeyManager"`
+	KeySpec      string  `json:"KeySpec"`
+	KeyState     string  `json:"KeyState"`
+	KeyUsage     string  `json:"KeyUsage"`
+	Origin       string  `json:"Origin"`
+}
+
+/*** Create Key ***/
+type CreateKeyRequest struct {
+	Description string `json:"Description"`
+	KeyUsage    string `json:"KeyUsage"`
+	Policy      string `json:"Policy"`
+}
+
+type CreateKeyResponse struct {
+	KeyMetadata KeyMetadata `json:"KeyMetadata"`
+}
+
+/*** Describe Key ***/
+type DescribeKeyRequest struct {
+	KeyId string `json:"KeyId"`
+}
+
+type DescribeKeyResponse struct {
+	KeyMetadata KeyMetadata `json:"KeyMetadata"`
+}
+
+/*** Encrypt ***/
+type EncryptRequest struct {
+	KeyId             string            `json:"KeyId"`
+	Plaintext         []byte            `json:"Plaintext"`
+	EncryptionContext map[string]string `json:"EncryptionContext"`
+}
+
+type EncryptResponse struct {
+	CiphertextBlob      []byte `json:"CiphertextBlob"`
+	KeyId               string `json:"KeyId"`
+	EncryptionAlgorithm string `json:"EncryptionAlgorithm"`
+}
+
+/*** Decrypt ***/
+type DecryptRequest struct {
+	CiphertextBlob    []byte            `json:"CiphertextBlob"`
+	KeyId             string            `json:"KeyId"`
+	EncryptionContext map[string]string `json:"EncryptionContext"`
+}
+
+type DecryptResponse struct {
+	KeyId               string `json:"KeyId"`
+	Plaintext           []byte `json:"Plaintext"`
+	EncryptionAlgorithm string `json:"EncryptionAlgorithm"`
+}
+
+/*** Generate Data Key ***/
+type GenerateDataKeyRequest struct {
+	KeyId             string            `json:"KeyId"`
+	KeySpec           string            `json:"KeySpec"`
+	NumberOfBytes     int               `json:"NumberOfBytes"`
+	EncryptionContext map[string]string `json:"EncryptionContext"`
+}
+
+type GenerateDataKeyResponse struct {
+	CiphertextBlob []byte `json:"CiphertextBlob"`
+	KeyId          string `json:"KeyId"`
+	Plaintext      []byte `json:"Plaintext"`
+}
+
+/*** Error Response ***/
+type KmsErrorResponse struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKmsKey_Allows(t *testing.T) {
+	assert.True(t, (&KmsKey{}).Allows("kms:Encrypt"))
+
+	key := &KmsKey{Policy: `{"Statement": [{"Effect": "Allow", "Action": ["kms:Encrypt", "kms:Decrypt"]}]}`}
+	assert.True(t, key.Allows("kms:Encrypt"))
+	assert.False(t, key.Allows("kms:GenerateDataKey"))
+
+	key = &KmsKey{Policy: `{"Statement": [{"Effect": "Allow", "Action": "*"}, {"Effect": "Deny", "Action": "kms:Decrypt"}]}`}
+	assert.True(t, key.Allows("kms:Encrypt"))
+	assert.False(t, key.Allows("kms:Decrypt"))
+}
+
+func TestFindKmsKey(t *testing.T) {
+	SyncKeys.Keys["1234"] = &KmsKey{KeyId: "1234"}
+	defer delete(SyncKeys.Keys, "1234")
+
+	for _, keyId := range []string{"1234", "arn:aws:kms:us-east-1:000000000000:key/1234", KmsAliasSQS, "arn:aws:kms:us-east-1:000000000000:alias/aws/sns"} {
+		_, err := FindKmsKey(keyId)
+		assert.Nil(t, err, keyId)
+	}
+	for _, keyId := range []string{"", "5678", "alias/my-key"} {
+		_, err := FindKmsKey(keyId)
+		assert.Equal(t, ErrKmsNotFound, err, keyId)
+	}
+}
@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+// latencyBuckets are the upper bounds of the request latency histogram, in seconds.
+// They go up to 20s as that's the longest a ReceiveMessage long poll can wait.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+var registry = struct {
+	sync.Mutex
+	requests         map[[2]string]uint64
+	latencies        map[string]*histogram
+	deadLetterMoves  map[[2]string]uint64
+	publishes        map[string]uint64
+	deliveries       map[[2]string]uint64
+	deliveryFailures map[[2]string]uint64
+}{}
+
+func init() {
+	Reset()
+}
+
+// Reset clears all the collected metrics.
+func Reset() {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.requests = make(map[[2]string]uint64)
+	registry.latencies = make(map[string]*histogram)
+	registry.deadLetterMoves = make(map[[2]string]uint64)
+	registry.publishes = make(map[string]uint64)
+	registry.deliveries = make(map[[2]string]uint64)
+	registry.deliveryFailures = make(map[[2]string]uint64)
+}
+
+// ObserveRequest records a handled request and how long it took.
+func ObserveRequest(action string, status int, duration time.Duration) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.requests[[2]string{action, fmt.Sprint(status)}]++
+
+	h, ok := registry.latencies[action]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		registry.latencies[action] = h
+	}
+	seconds := duration.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// IncDeadLetterMoves records a message being moved to a dead letter queue.
+func IncDeadLetterMoves(queueName string, deadLetterQueueName string) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.deadLetterMoves[[2]string{queueName, deadLetterQueueName}]++
+}
+
+// IncPublishes records a message being published to a topic.
+func IncPublishes(topicArn string) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.publishes[topicArn]++
+}
+
+// ObserveDelivery records the outcome of delivering a message to a subscription.
+func ObserveDelivery(topicArn string, subscriptionArn string, err error) {
+	registry.Lock()
+	defer registry.Unlock()
+	key := [2]string{topicArn, subscriptionArn}
+	if err != nil {
+		registry.deliveryFailures[key]++
+	} else {
+		registry.deliveries[key]++
+	}
+}
+
+// Handler writes the metrics in the Prometheus text exposition format.
+func Handler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	registry.Lock()
+	writeHeader(w, "goaws_requests_total", "counter", "Requests handled, by action and HTTP status code.")
+	for _, k := range sortedPairs(registry.requests) {
+		fmt.Fprintf(w, "goaws_requests_total{action=%s,code=%s} %d\n", quote(k[0]), quote(k[1]), registry.requests[k])
+	}
+
+	writeHeader(w, "goaws_request_duration_seconds", "histogram", "Request latencies, by action.")
+	actions := make([]string, 0, len(registry.latencies))
+	for action := range registry.latencies {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	for _, action := range actions {
+		h := registry.latencies[action]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "goaws_request_duration_seconds_bucket{action=%s,le=%s} %d\n", quote(action), quote(fmt.Sprint(bound)), h.counts[i])
+		}
+		fmt.Fprintf(w, "goaws_request_duration_seconds_bucket{action=%s,le=\"+Inf\"} %d\n", quote(action), h.count)
+		fmt.Fprintf(w, "goaws_request_duration_seconds_sum{action=%s} %g\n", quote(action), h.sum)
+		fmt.Fprintf(w, "goaws_request_duration_seconds_count{action=%s} %d\n", quote(action), h.count)
+	}
+
+	writeHeader(w, "goaws_dead_letter_moves_total", "counter", "Messages moved to a dead letter queue, by source queue.")
+	for _, k := range sortedPairs(registry.deadLetterMoves) {
+		fmt.Fprintf(w, "goaws_dead_letter_moves_total{queue=%s,dead_letter_queue=%s} %d\n", quote(k[0]), quote(k[1]), registry.deadLetterMoves[k])
+	}
+
+	writeHeader(w, "goaws_sns_publishes_total", "counter", "Messages published, by topic.")
+	topics := make([]string, 0, len(registry.publishes))
+	for topic := range registry.publishes {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	for _, topic := range topics {
+		fmt.Fprintf(w, "goaws_sns_publishes_total{topic=%s} %d\n", quote(topic), registry.publishes[topic])
+	}
+
+	writeHeader(w, "goaws_sns_deliveries_total", "counter", "Successful deliveries to HTTP(S) endpoints, by subscription.")
+	for _, k := range sortedPairs(registry.deliveries) {
+		fmt.Fprintf(w, "goaws_sns_deliveries_total{topic=%s,subscription=%s} %d\n", quote(k[0]), quote(k[1]), registry.deliveries[k])
+	}
+	writeHeader(w, "goaws_sns_delivery_failures_total", "counter", "Failed deliveries to HTTP(S) endpoints, by subscription.")
+	for _, k := range sortedPairs(registry.deliveryFailures) {
+		fmt.Fprintf(w, "goaws_sns_delivery_failures_total{topic=%s,subscription=%s} %d\n", quote(k[0]), quote(k[1]), registry.deliveryFailures[k])
+	}
+	registry.Unlock()
+
+	writeQueueMessages(w)
+}
+
+// writeQueueMessages reports the current number of messages of every queue by state.
+func writeQueueMessages(w io.Writer) {
+	writeHeader(w, "goaws_queue_messages", "gauge", "Messages currently in a queue, by state.")
+
+	app.SyncQueues.RLock()
+	defer app.SyncQueues.RUnlock()
+	names := make([]string, 0, len(app.SyncQueues.Queues))
+	for name := range app.SyncQueues.Queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := app.Now()
+	for _, name := range names {
+		visible, inFlight, delayed := 0, 0, 0
+		for _, m := range app.SyncQueues.Queues[name].Messages {
+			switch {
+			case m.ReceiptHandle != "":
+				inFlight++
+			case now.Before(m.DelayedUntil()):
+				delayed++
+			default:
+				visible++
+			}
+		}
+		fmt.Fprintf(w, "goaws_queue_messages{queue=%s,state=\"visible\"} %d\n", quote(name), visible)
+		fmt.Fprintf(w, "goaws_queue_messages{queue=%s,state=\"inflight\"} %d\n", quote(name), inFlight)
+		fmt.Fprintf(w, "goaws_queue_messages{queue=%s,state=\"delayed\"} %d\n", quote(name), delayed)
+	}
+}
+
+func writeHeader(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quote(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func sortedPairs(m map[[2]string]uint64) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
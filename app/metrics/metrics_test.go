@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Admiral-Piett/goaws/app"
+)
+
+func TestHandler(t *testing.T) {
+	Reset()
+	defer Reset()
+
+	ObserveRequest("SendMessage", 200, 30*time.Millisecond)
+	ObserveRequest("SendMessage", 200, 2*time.Second)
+	ObserveRequest("SendMessage", 400, time.Millisecond)
+	IncDeadLetterMoves("metrics-queue", "metrics-dlq")
+	IncPublishes("arn:aws:sns:local:queue:metrics-topic")
+	ObserveDelivery("arn:aws:sns:local:queue:metrics-topic", "arn:aws:sns:local:queue:metrics-topic:1", nil)
+	ObserveDelivery("arn:aws:sns:local:queue:metrics-topic", "arn:aws:sns:local:queue:metrics-topic:1", errors.New("failed"))
+
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["metrics-queue"] = &app.Queue{
+		Name: "metrics-queue",
+		Messages: []app.Message{
+			{SentTime: time.Now()},
+			{SentTime: time.Now(), ReceiptHandle: "handle"},
+			{SentTime: time.Now(), DelaySecs: 60},
+			{SentTime: time.Now(), DelaySecs: 60},
+		},
+	}
+	app.SyncQueues.Unlock()
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "metrics-queue")
+		app.SyncQueues.Unlock()
+	}()
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Handler).ServeHTTP(rr, req)
+
+	for _, expected := range []string{
+		`goaws_requests_total{action="SendMessage",code="200"} 2`,
+		`goaws_requests_total{action="SendMessage",code="400"} 1`,
+		`goaws_request_duration_seconds_bucket{action="SendMessage",le="0.05"} 2`,
+		`goaws_request_duration_seconds_bucket{action="SendMessage",le="+Inf"} 3`,
+		`goaws_request_duration_seconds_count{action="SendMessage"} 3`,
+		`goaws_dead_letter_moves_total{queue="metrics-queue",dead_letter_queue="metrics-dlq"} 1`,
+		`goaws_sns_publishes_total{topic="arn:aws:sns:local:queue:metrics-topic"} 1`,
+		`goaws_sns_deliveries_total{topic="arn:aws:sns:local:queue:metrics-topic",subscription="arn:aws:sns:local:queue:metrics-topic:1"} 1`,
+		`goaws_sns_delivery_failures_total{topic="arn:aws:sns:local:queue:metrics-topic",subscription="arn:aws:sns:local:queue:metrics-topic:1"} 1`,
+		`goaws_queue_messages{queue="metrics-queue",state="visible"} 1`,
+		`goaws_queue_messages{queue="metrics-queue",state="inflight"} 1`,
+		`goaws_queue_messages{queue="metrics-queue",state="delayed"} 2`,
+	} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got := quote("a\"b\\c\nd"); got != `"a\"b\\c\nd"` {
+		t.Errorf("unexpected label value: %s", got)
+	}
+}
@@ -0,0 +1,35 @@
+package app
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// random drives every simulated behaviour (random latency, duplicate and out of
+// order delivery, fault injection), so seeding it makes runs reproducible.
+var random = struct {
+	sync.Mutex
+	rand *rand.Rand
+}{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// SeedRandom reseeds the random number generator used for simulations.
+func SeedRandom(seed int64) {
+	random.Lock()
+	defer random.Unlock()
+	random.rand = rand.New(rand.NewSource(seed))
+}
+
+// RandomFloat64 returns a number in [0.0, 1.0).
+func RandomFloat64() float64 {
+	random.Lock()
+	defer random.Unlock()
+	return random.rand.Float64()
+}
+
+// RandomIntn returns a number in [0, n).
+func RandomIntn(n int) int {
+	random.Lock()
+	defer random.Unlock()
+	return random.rand.Intn(n)
+}
@@ -0,0 +1,17 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeedRandom(t *testing.T) {
+	SeedRandom(42)
+	first := []float64{RandomFloat64(), RandomFloat64(), float64(RandomIntn(100))}
+
+	SeedRandom(42)
+	second := []float64{RandomFloat64(), RandomFloat64(), float64(RandomIntn(100))}
+
+	assert.Equal(t, first, second)
+}
@@ -3,13 +3,20 @@ package router
 import (
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"fmt"
 
+	"github.com/Admiral-Piett/goaws/app/dashboard"
+	"github.com/Admiral-Piett/goaws/app/fault"
+	admin "github.com/Admiral-Piett/goaws/app/goadmin"
+	kms "github.com/Admiral-Piett/goaws/app/gokms"
 	sns "github.com/Admiral-Piett/goaws/app/gosns"
 	sqs "github.com/Admiral-Piett/goaws/app/gosqs"
+	"github.com/Admiral-Piett/goaws/app/metrics"
 	"github.com/gorilla/mux"
 )
 
@@ -17,8 +24,33 @@ import (
 func New() http.Handler {
 	r := mux.NewRouter()
 
+	// Admin API, registered first so it isn't mistaken for /{account}/{queueName}
+	a := r.PathPrefix("/_goaws").Subrouter()
+	a.HandleFunc("/queues", admin.ListQueues).Methods("GET")
+	a.HandleFunc("/queues/{queueName}/messages", admin.ListMessages).Methods("GET")
+	a.HandleFunc("/queues/{queueName}/messages", admin.SendMessage).Methods("POST")
+	a.HandleFunc("/queues/{queueName}/messages/{messageId}", admin.DeleteMessage).Methods("DELETE")
+	a.HandleFunc("/topics", admin.ListTopics).Methods("GET")
+	a.HandleFunc("/outbox", admin.ListOutbox).Methods("GET")
+	a.HandleFunc("/outbox", admin.ClearOutbox).Methods("DELETE")
+	a.HandleFunc("/published", admin.ListPublished).Methods("GET")
+	a.HandleFunc("/published", admin.ClearPublished).Methods("DELETE")
+	a.HandleFunc("/published/{messageId}", admin.GetPublished).Methods("GET")
+	a.HandleFunc("/reset", admin.Reset).Methods("POST")
+	a.HandleFunc("/snapshot", admin.GetSnapshot).Methods("GET")
+	a.HandleFunc("/ca.pem", admin.GetCACertificate).Methods("GET")
+	a.HandleFunc("/clock", admin.GetClock).Methods("GET")
+	a.HandleFunc("/clock/advance", admin.AdvanceClock).Methods("POST")
+	a.HandleFunc("/faults", admin.ListFaults).Methods("GET")
+	a.HandleFunc("/faults", admin.AddFault).Methods("POST")
+	a.HandleFunc("/faults", admin.ClearFaults).Methods("DELETE")
+	a.HandleFunc("/faults/{id}", admin.DeleteFault).Methods("DELETE")
+	a.Handle("/dashboard", http.RedirectHandler("/_goaws/dashboard/", http.StatusMovedPermanently)).Methods("GET")
+	a.PathPrefix("/dashboard/").Handler(dashboard.Handler("/_goaws/dashboard/")).Methods("GET")
+
 	r.HandleFunc("/", actionHandler).Methods("GET", "POST")
 	r.HandleFunc("/health", health).Methods("GET")
+	r.HandleFunc("/metrics", metrics.Handler).Methods("GET")
 	r.HandleFunc("/{account}", actionHandler).Methods("GET", "POST")
 	r.HandleFunc("/queue/{queueName}", actionHandler).Methods("GET", "POST")
 	r.HandleFunc("/SimpleNotificationService/{id}.pem", pemHandler).Methods("GET")
@@ -27,8 +59,7 @@ func New() http.Handler {
 	return r
 }
 
-var routingTable = map[string]http.HandlerFunc{
-	// SQS
+var sqsRoutingTable = map[string]http.HandlerFunc{
 	"ListQueues":              sqs.ListQueues,
 	"CreateQueue":             sqs.CreateQueue,
 	"GetQueueAttributes":      sqs.GetQueueAttributes,
@@ -42,11 +73,14 @@ var routingTable = map[string]http.HandlerFunc{
 	"PurgeQueue":              sqs.PurgeQueue,
 	"DeleteQueue":             sqs.DeleteQueue,
 	"ChangeMessageVisibility": sqs.ChangeMessageVisibility,
+}
 
-	// SNS
+var snsRoutingTable = map[string]http.HandlerFunc{
 	"ListTopics":                sns.ListTopics,
 	"CreateTopic":               sns.CreateTopic,
 	"DeleteTopic":               sns.DeleteTopic,
+	"GetTopicAttributes":        sns.GetTopicAttributes,
+	"SetTopicAttributes":        sns.SetTopicAttributes,
 	"Subscribe":                 sns.Subscribe,
 	"SetSubscriptionAttributes": sns.SetSubscriptionAttributes,
 	"GetSubscriptionAttributes": sns.GetSubscriptionAttributes,
@@ -54,34 +88,150 @@ var routingTable = map[string]http.HandlerFunc{
 	"ListSubscriptions":         sns.ListSubscriptions,
 	"Unsubscribe":               sns.Unsubscribe,
 	"Publish":                   sns.Publish,
+	"PublishBatch":              sns.PublishBatch,
 
 	// SNS Internal
 	"ConfirmSubscription": sns.ConfirmSubscription,
 }
 
+// kmsRoutingTable maps the X-Amz-Target header of KMS JSON requests to handlers
+var kmsRoutingTable = map[string]http.HandlerFunc{
+	"TrentService.CreateKey":       kms.CreateKey,
+	"TrentService.DescribeKey":     kms.DescribeKey,
+	"TrentService.Encrypt":         kms.Encrypt,
+	"TrentService.Decrypt":         kms.Decrypt,
+	"TrentService.GenerateDataKey": kms.GenerateDataKey,
+}
+
 func health(w http.ResponseWriter, req *http.Request) {
 	w.WriteHeader(200)
 	fmt.Fprint(w, "OK")
 }
 
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// discardWriter swallows the response of a request replayed by a duplicate fault.
+type discardWriter struct {
+	header http.Header
+}
+
+func (d *discardWriter) Header() http.Header {
+	if d.header == nil {
+		d.header = make(http.Header)
+	}
+	return d.header
+}
+
+func (d *discardWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (d *discardWriter) WriteHeader(int) {}
+
 func actionHandler(w http.ResponseWriter, req *http.Request) {
-	log.WithFields(
-		log.Fields{
-			"action": req.FormValue("Action"),
-			"url":    req.URL,
-		}).Debug("Handling URL request")
-	fn, ok := routingTable[req.FormValue("Action")]
-	if !ok {
-		log.Println("Bad Request - Action:", req.FormValue("Action"))
+	start := time.Now()
+	service, action, fn := route(req)
+	if fn == nil {
 		w.WriteHeader(http.StatusBadRequest)
 		io.WriteString(w, "Bad Request")
+		metrics.ObserveRequest("Unknown", http.StatusBadRequest, time.Since(start))
 		return
 	}
+	log.WithFields(
+		log.Fields{
+			"service": service,
+			"action":  action,
+			"url":     req.URL,
+		}).Debug("Handling URL request")
 
-	http.HandlerFunc(fn).ServeHTTP(w, req)
+	outcome := fault.Decide(action, resourceName(req))
+	if outcome.Latency > 0 {
+		select {
+		case <-req.Context().Done():
+			return // client gave up
+		case <-time.After(outcome.Latency):
+		}
+	}
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	switch outcome.Fault {
+	case fault.Reset:
+		metrics.ObserveRequest(action, 0, time.Since(start))
+		// makes the server close the connection without responding
+		panic(http.ErrAbortHandler)
+	case fault.Throttle, fault.Error:
+		fault.WriteError(rec, service, outcome)
+	default:
+		fn(rec, req)
+		if outcome.Duplicate {
+			fn(&discardWriter{}, req)
+		}
+	}
+	metrics.ObserveRequest(action, rec.status, time.Since(start))
 }
 
+// route finds the handler of a request, along with the service and action it is for.
+// KMS requests are routed by their TrentService.* X-Amz-Target header, SQS and SNS
+// ones by their Action.
+func route(req *http.Request) (string, string, http.HandlerFunc) {
+	if target := req.Header.Get("X-Amz-Target"); strings.HasPrefix(target, "TrentService.") {
+		fn, ok := kmsRoutingTable[target]
+		if !ok {
+			log.Println("Bad Request - Target:", target)
+			return fault.ServiceKMS, "", nil
+		}
+		return fault.ServiceKMS, strings.TrimPrefix(target, "TrentService."), fn
+	}
+
+	action := req.FormValue("Action")
+	if fn, ok := sqsRoutingTable[action]; ok {
+		return fault.ServiceSQS, action, fn
+	}
+	if fn, ok := snsRoutingTable[action]; ok {
+		return fault.ServiceSNS, action, fn
+	}
+	log.Println("Bad Request - Action:", action)
+	return "", "", nil
+}
+
+// resourceName returns the name of the queue or topic a request is for, if any.
+func resourceName(req *http.Request) string {
+	if queueUrl := req.FormValue("QueueUrl"); queueUrl != "" {
+		segments := strings.Split(queueUrl, "/")
+		return segments[len(segments)-1]
+	}
+	if topicArn := req.FormValue("TopicArn"); topicArn != "" {
+		segments := strings.Split(topicArn, ":")
+		return segments[len(segments)-1]
+	}
+	if queueName := req.FormValue("QueueName"); queueName != "" {
+		return queueName
+	}
+	if name := req.FormValue("Name"); name != "" {
+		return name
+	}
+	return mux.Vars(req)["queueName"]
+}
+
+// pemHandler serves the certificate SNS messages are signed with, at the
+// SigningCertURL of the messages.
 func pemHandler(w http.ResponseWriter, req *http.Request) {
+	id, cert := sns.SigningCertificate()
+	if mux.Vars(req)["id"] != id {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "Not Found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
 	w.WriteHeader(http.StatusOK)
-	w.Write(sns.PemKEY)
+	w.Write(cert)
 }
@@ -4,7 +4,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
+
+	"github.com/Admiral-Piett/goaws/app"
+	"github.com/Admiral-Piett/goaws/app/fault"
+	sns "github.com/Admiral-Piett/goaws/app/gosns"
 )
 
 func TestIndexServerhandler_POST_BadRequest(t *testing.T) {
@@ -92,7 +97,25 @@ func TestIndexServerhandler_POST_GoodRequest_With_URL(t *testing.T) {
 }
 
 func TestIndexServerhandler_GET_GoodRequest_Pem_cert(t *testing.T) {
+	id, cert := sns.SigningCertificate()
+	req, err := http.NewRequest("GET", "/SimpleNotificationService/"+id+".pem", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	New().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if rr.Body.String() != string(cert) {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), string(cert))
+	}
+}
 
+func TestIndexServerhandler_GET_UnknownPem_cert(t *testing.T) {
 	req, err := http.NewRequest("GET", "/SimpleNotificationService/100010001000.pem", nil)
 	if err != nil {
 		t.Fatal(err)
@@ -101,8 +124,191 @@ func TestIndexServerhandler_GET_GoodRequest_Pem_cert(t *testing.T) {
 	rr := httptest.NewRecorder()
 	New().ServeHTTP(rr, req)
 
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
+
+func TestIndexServerhandler_POST_KmsTarget(t *testing.T) {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`{"Description": "test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.CreateKey")
+
+	rr := httptest.NewRecorder()
+	New().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), `"KeyState":"Enabled"`) {
+		t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
+	}
+
+	req, err = http.NewRequest("POST", "/", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Amz-Target", "TrentService.ScheduleKeyDeletion")
+
+	rr = httptest.NewRecorder()
+	New().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestIndexServerhandler_POST_OtherTargetIsNotKms(t *testing.T) {
+	form := url.Values{}
+	form.Add("Action", "ListQueues")
+	req, err := http.NewRequest("POST", "/", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Amz-Target", "AmazonSQS.ListQueues")
+
+	rr := httptest.NewRecorder()
+	New().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "ListQueuesResponse") {
+		t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	req, err := http.NewRequest("GET", "/_goaws/queues", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	New().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), `"Queues"`) {
+		t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
+	}
+}
+
+func TestDashboardRoutes(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/_goaws/dashboard/":       "<title>GoAws</title>",
+		"/_goaws/dashboard/app.js": "/_goaws/queues",
+	} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		New().ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("%s returned wrong status code: got %v want %v",
+				path, status, http.StatusOK)
+		}
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("%s returned unexpected body: got %v want %v", path, rr.Body.String(), expected)
+		}
+	}
+}
+
+func TestMetricsRoute(t *testing.T) {
+	req, err := http.NewRequest("POST", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{}
+	form.Add("Action", "ListQueues")
+	req.PostForm = form
+	New().ServeHTTP(httptest.NewRecorder(), req)
+
+	req, err = http.NewRequest("GET", "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	New().ServeHTTP(rr, req)
+
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusOK)
 	}
+	expected := `goaws_requests_total{action="ListQueues",code="200"}`
+	if !strings.Contains(rr.Body.String(), expected) {
+		t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	defer fault.SetRules(nil)
+
+	sendMessage := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		form := url.Values{}
+		form.Add("Action", "SendMessage")
+		form.Add("QueueUrl", "http://localhost:4100/queue/fault-queue")
+		form.Add("MessageBody", "1")
+		req.PostForm = form
+
+		rr := httptest.NewRecorder()
+		New().ServeHTTP(rr, req)
+		return rr
+	}
+
+	app.SyncQueues.Lock()
+	app.SyncQueues.Queues["fault-queue"] = &app.Queue{Name: "fault-queue"}
+	app.SyncQueues.Unlock()
+	defer func() {
+		app.SyncQueues.Lock()
+		delete(app.SyncQueues.Queues, "fault-queue")
+		app.SyncQueues.Unlock()
+	}()
+
+	fault.SetRules([]app.FaultRule{{Action: "SendMessage", Resource: "fault-*", Fault: fault.Throttle, Count: 1}})
+	rr := sendMessage()
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	if !strings.Contains(rr.Body.String(), "<Code>RequestThrottled</Code>") {
+		t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
+	}
+
+	// the rule only applied once
+	if rr = sendMessage(); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			rr.Code, http.StatusOK)
+	}
+
+	fault.SetRules([]app.FaultRule{{Action: "SendMessage", Fault: fault.Duplicate}})
+	sendMessage()
+	if n := len(app.SyncQueues.Queues["fault-queue"].Messages); n != 3 {
+		t.Errorf("expected 3 messages, got %d", n)
+	}
+
+	fault.SetRules([]app.FaultRule{{Fault: fault.Reset}})
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("expected the handler to abort, got %v", r)
+		}
+	}()
+	sendMessage()
 }
@@ -41,11 +41,13 @@ func New(addr string) (*Server, error) {
 		addr = "localhost:0"
 	}
 	localURL := strings.Split(addr, ":")
-	app.CurrentEnvironment.Host = localURL[0]
-	app.CurrentEnvironment.Port = localURL[1]
+	env := app.GetCurrentEnvironment()
+	env.Host = localURL[0]
+	env.Port = localURL[1]
+	app.SetCurrentEnvironment(env)
 	log.WithFields(log.Fields{
-		"host": app.CurrentEnvironment.Host,
-		"port": app.CurrentEnvironment.Port,
+		"host": env.Host,
+		"port": env.Port,
 	}).Info("URL Sarting to listen")
 
 	l, err := net.Listen("tcp", addr)
@@ -0,0 +1,34 @@
+package app
+
+import "sync"
+
+var shutdown = struct {
+	sync.Mutex
+	ch chan struct{}
+}{ch: make(chan struct{})}
+
+// ShuttingDown returns a channel that is closed once GoAws starts shutting down,
+// so long running requests such as long polls can return early.
+func ShuttingDown() <-chan struct{} {
+	shutdown.Lock()
+	defer shutdown.Unlock()
+	return shutdown.ch
+}
+
+// BeginShutdown closes the ShuttingDown channel. Calling it again does nothing.
+func BeginShutdown() {
+	shutdown.Lock()
+	defer shutdown.Unlock()
+	select {
+	case <-shutdown.ch:
+	default:
+		close(shutdown.ch)
+	}
+}
+
+// ResetShutdown undoes BeginShutdown, e.g. between tests.
+func ResetShutdown() {
+	shutdown.Lock()
+	defer shutdown.Unlock()
+	shutdown.ch = make(chan struct{})
+}
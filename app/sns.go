@@ -1,7 +1,9 @@
 package app
 
 import (
+	"strings"
 	"sync"
+	"time"
 )
 
 type SnsErrorType struct {
@@ -40,6 +42,12 @@ type Subscription struct {
 	EndPoint        string
 	Raw             bool
 	FilterPolicy    *FilterPolicy
+	DeliveryPolicy  string
+	// http, https and email subscriptions made with Subscribe are pending until their
+	// endpoint confirms them, and get no messages until then
+	PendingConfirmation          bool
+	ConfirmationWasAuthenticated bool
+	AuthenticateOnUnsubscribe    bool // only authenticated Unsubscribe requests can delete it
 }
 
 // only simple "ExactMatch" string policy is supported at the moment
@@ -77,10 +85,36 @@ func stringInSlice(a string, list []string) bool {
 	return false
 }
 
+// LambdaFunctionName returns the name of the function a lambda subscription's
+// endpoint, a function ARN optionally followed by a version or alias, refers to.
+func LambdaFunctionName(endpoint string) string {
+	parts := strings.Split(endpoint, ":")
+	if len(parts) >= 7 && parts[0] == "arn" && parts[2] == "lambda" && parts[5] == "function" {
+		return parts[6]
+	}
+	return endpoint
+}
+
+// FindLambdaFunction returns the function a lambda subscription's endpoint refers to.
+func FindLambdaFunction(functions []LambdaFunction, endpoint string) (LambdaFunction, bool) {
+	for _, function := range functions {
+		if function.Name == endpoint || function.Name == LambdaFunctionName(endpoint) {
+			return function, true
+		}
+	}
+	return LambdaFunction{}, false
+}
+
 type Topic struct {
-	Name          string
-	Arn           string
-	Subscriptions []*Subscription
+	Name             string
+	Arn              string
+	Subscriptions    []*Subscription
+	DisplayName      string
+	KmsMasterKeyId   string
+	Policy           string
+	DeliveryPolicy   string
+	SignatureVersion string // "1" signs messages with SHA1withRSA, "2" with SHA256withRSA
+	Tags             map[string]string
 }
 
 type (
@@ -89,12 +123,74 @@ type (
 )
 
 const (
-	ProtocolHTTP    Protocol = "http"
-	ProtocolHTTPS   Protocol = "https"
-	ProtocolSQS     Protocol = "sqs"
-	ProtocolDefault Protocol = "default"
+	ProtocolHTTP        Protocol = "http"
+	ProtocolHTTPS       Protocol = "https"
+	ProtocolLambda      Protocol = "lambda"
+	ProtocolSQS         Protocol = "sqs"
+	ProtocolEmail       Protocol = "email"
+	ProtocolEmailJSON   Protocol = "email-json"
+	ProtocolSMS         Protocol = "sms"
+	ProtocolApplication Protocol = "application"
+	ProtocolDefault     Protocol = "default"
 )
 
+// IsOutboxProtocol tells whether messages to subscriptions of the protocol are
+// recorded in the outbox rather than sent.
+func IsOutboxProtocol(protocol Protocol) bool {
+	switch protocol {
+	case ProtocolEmail, ProtocolEmailJSON, ProtocolSMS, ProtocolApplication:
+		return true
+	}
+	return false
+}
+
+// OutboxMessage is a message GoAws records instead of sending it to an email
+// address, phone number or mobile application endpoint.
+type OutboxMessage struct {
+	MessageId         string
+	Type              string // Notification or SubscriptionConfirmation
+	Protocol          string
+	EndPoint          string
+	TopicArn          string // empty for messages published to a phone number or endpoint directly
+	SubscriptionArn   string
+	Subject           string
+	Message           string // formatted for the protocol, e.g. a JSON document for email-json
+	MessageAttributes map[string]MessageAttributeValue
+	SubscribeURL      string // of confirmations
+	SentTime          time.Time
+}
+
+// PublishedMessage is a message published to a topic, phone number or mobile
+// application endpoint, and what became of it for each subscription.
+type PublishedMessage struct {
+	MessageId         string // the MessageId Publish returned and subscribers got
+	TopicArn          string // empty for messages published to a phone number or endpoint directly
+	Subject           string
+	Message           string
+	MessageStructure  string
+	MessageAttributes map[string]MessageAttributeValue
+	MessageGroupId    string
+	PublishTime       time.Time
+	Deliveries        []Delivery
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+	DeliveryFiltered  DeliveryStatus = "filtered" // by the filter policy of the subscription
+)
+
+// Delivery is the delivery of a published message to a subscription or endpoint.
+type Delivery struct {
+	SubscriptionArn string // empty for messages published to a phone number or endpoint directly
+	Protocol        string
+	EndPoint        string
+	Status          DeliveryStatus
+	Error           string // of failed deliveries
+}
+
 const (
 	MessageStructureJSON MessageStructure = "json"
 )
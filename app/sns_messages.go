@@ -30,6 +30,32 @@ type CreateTopicResponse struct {
 	Metadata ResponseMetadata  `xml:"ResponseMetadata"`
 }
 
+/*** Get Topic Attributes ***/
+type TopicAttributeEntry struct {
+	Key   string `xml:"key,omitempty"`
+	Value string `xml:"value,omitempty"`
+}
+
+type TopicAttributes struct {
+	Entries []TopicAttributeEntry `xml:"entry,omitempty"`
+}
+
+type GetTopicAttributesResult struct {
+	Attributes TopicAttributes `xml:"Attributes,omitempty"`
+}
+
+type GetTopicAttributesResponse struct {
+	Xmlns    string                   `xml:"xmlns,attr,omitempty"`
+	Result   GetTopicAttributesResult `xml:"GetTopicAttributesResult"`
+	Metadata ResponseMetadata         `xml:"ResponseMetadata,omitempty"`
+}
+
+/*** Set Topic Attributes ***/
+type SetTopicAttributesResponse struct {
+	Xmlns    string           `xml:"xmlns,attr"`
+	Metadata ResponseMetadata `xml:"ResponseMetadata"`
+}
+
 /*** Create Subscription ***/
 type SubscribeResult struct {
 	SubscriptionArn string `xml:"SubscriptionArn"`
@@ -123,6 +149,32 @@ type PublishResponse struct {
 	Metadata ResponseMetadata `xml:"ResponseMetadata"`
 }
 
+/*** Publish Batch ***/
+type PublishBatchResultEntry struct {
+	Id             string `xml:"Id"`
+	MessageId      string `xml:"MessageId"`
+	SequenceNumber string `xml:"SequenceNumber,omitempty"`
+}
+
+type PublishBatchSuccessful struct {
+	Member []PublishBatchResultEntry `xml:"member"`
+}
+
+type PublishBatchFailed struct {
+	Member []BatchResultErrorEntry `xml:"member"`
+}
+
+type PublishBatchResult struct {
+	Successful PublishBatchSuccessful `xml:"Successful"`
+	Failed     PublishBatchFailed     `xml:"Failed"`
+}
+
+type PublishBatchResponse struct {
+	Xmlns    string             `xml:"xmlns,attr"`
+	Result   PublishBatchResult `xml:"PublishBatchResult"`
+	Metadata ResponseMetadata   `xml:"ResponseMetadata"`
+}
+
 /*** Unsubscribe ***/
 type UnsubscribeResponse struct {
 	Xmlns    string           `xml:"xmlns,attr"`
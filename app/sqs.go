@@ -3,7 +3,6 @@ package app
 import (
 	"errors"
 	"fmt"
-	"math/rand"
 	"strconv"
 	"strings"
 	"sync"
@@ -42,19 +41,24 @@ type Message struct {
 	DelaySecs              int
 }
 
+// DelayedUntil returns the time at which the message's delay expires.
+func (m *Message) DelayedUntil() time.Time {
+	return m.SentTime.Add(time.Duration(m.DelaySecs) * time.Second)
+}
+
 func (m *Message) IsReadyForReceipt() bool {
 	randomLatency, err := getRandomLatency()
 	if err != nil {
 		log.Error(err)
-		return true
+		return !Now().Before(m.DelayedUntil())
 	}
-	showAt := m.SentTime.Add(randomLatency).Add(time.Duration(m.DelaySecs) * time.Second)
-	return showAt.Before(time.Now())
+	showAt := m.DelayedUntil().Add(randomLatency)
+	return !Now().Before(showAt)
 }
 
 func getRandomLatency() (time.Duration, error) {
-	min := CurrentEnvironment.RandomLatency.Min
-	max := CurrentEnvironment.RandomLatency.Max
+	latency := GetCurrentEnvironment().RandomLatency
+	min, max := latency.Min, latency.Max
 	if min == 0 && max == 0 {
 		return time.Duration(0), nil
 	}
@@ -62,7 +66,7 @@ func getRandomLatency() (time.Duration, error) {
 	if max == min {
 		randomLatencyValue = max
 	} else {
-		randomLatencyValue = rand.Intn(max-min) + min
+		randomLatencyValue = RandomIntn(max-min) + min
 	}
 	randomDuration, err := time.ParseDuration(fmt.Sprintf("%dms", randomLatencyValue))
 	if err != nil {
@@ -94,6 +98,16 @@ type Queue struct {
 	FIFOSequenceNumbers map[string]int
 	EnableDuplicates    bool
 	Duplicates          map[string]time.Time
+	KmsMasterKeyId      string
+	KmsDataKeyReuseSecs int
+	SqsManagedSse       bool
+	// Chances of a standard queue delivering a message twice or out of order
+	DuplicateProbability      float64
+	ReorderProbability        float64
+	ContentBasedDeduplication bool
+	MessageRetentionPeriod    int // seconds, 0 to keep messages until they are deleted
+	Policy                    string
+	Tags                      map[string]string
 }
 
 var SyncQueues = struct {
@@ -103,6 +117,19 @@ var SyncQueues = struct {
 
 var DeduplicationPeriod = 5 * time.Minute
 
+// DefaultKmsDataKeyReusePeriodSeconds is used for queues encrypted with a KMS key
+// that don't set KmsDataKeyReusePeriodSeconds.
+const DefaultKmsDataKeyReusePeriodSeconds = 300
+
+// DefaultMessageRetentionPeriod is reported for queues without a MessageRetentionPeriod
+const DefaultMessageRetentionPeriod = 345600 // 4 days
+
+// Default maximum number of in flight messages per queue, as enforced by AWS.
+const (
+	DefaultStandardInFlightLimit = 120000
+	DefaultFIFOInFlightLimit     = 20000
+)
+
 func HasFIFOQueueName(queueName string) bool {
 	return strings.HasSuffix(queueName, ".fifo")
 }
@@ -137,6 +164,33 @@ func (q *Queue) UnlockGroup(groupId string) {
 	}
 }
 
+// NumberOfInFlightMessages returns the number of messages that have been received
+// but neither deleted nor made visible again.
+func (q *Queue) NumberOfInFlightMessages() int {
+	num := 0
+	for _, m := range q.Messages {
+		if m.ReceiptHandle != "" {
+			num++
+		}
+	}
+	return num
+}
+
+// InFlightLimit returns the maximum number of in flight messages allowed for the queue.
+func (q *Queue) InFlightLimit() int {
+	limits := GetCurrentEnvironment().InFlightLimits
+	if q.IsFIFO {
+		if limits.FIFO > 0 {
+			return limits.FIFO
+		}
+		return DefaultFIFOInFlightLimit
+	}
+	if limits.Standard > 0 {
+		return limits.Standard
+	}
+	return DefaultStandardInFlightLimit
+}
+
 func (q *Queue) IsDuplicate(deduplicationId string) bool {
 	if !q.EnableDuplicates || !q.IsFIFO || deduplicationId == "" {
 		return false
@@ -153,6 +207,6 @@ func (q *Queue) InitDuplicatation(deduplicationId string) {
 	}
 
 	if _, ok := q.Duplicates[deduplicationId]; !ok {
-		q.Duplicates[deduplicationId] = time.Now()
+		q.Duplicates[deduplicationId] = Now()
 	}
 }
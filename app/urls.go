@@ -0,0 +1,26 @@
+package app
+
+// Scheme is the scheme of the URLs GoAws generates, https when TLS is enabled.
+func Scheme() string {
+	if GetCurrentEnvironment().TLS.Enabled {
+		return "https"
+	}
+	return "http"
+}
+
+// BaseURL is the URL GoAws is reachable at, e.g. http://localhost:4100.
+func BaseURL() string {
+	env := GetCurrentEnvironment()
+	return Scheme() + "://" + env.Host + ":" + env.Port
+}
+
+// QueueURL is the URL of the queue with the given name. The region is part of the
+// host name when there is one, e.g. http://us-east-1.localhost:4100/100010001000/orders.
+func QueueURL(name string) string {
+	env := GetCurrentEnvironment()
+	host := env.Host
+	if env.Region != "" {
+		host = env.Region + "." + host
+	}
+	return Scheme() + "://" + host + ":" + env.Port + "/" + env.AccountID + "/" + name
+}
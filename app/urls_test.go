@@ -0,0 +1,19 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLs(t *testing.T) {
+	defer func(env Environment) { CurrentEnvironment = env }(CurrentEnvironment)
+	CurrentEnvironment = Environment{Host: "localhost", Port: "4100", AccountID: "100010001000"}
+	assert.Equal(t, "http://localhost:4100", BaseURL())
+	assert.Equal(t, "http://localhost:4100/100010001000/orders", QueueURL("orders"))
+
+	CurrentEnvironment.Region = "us-east-1"
+	CurrentEnvironment.TLS.Enabled = true
+	assert.Equal(t, "https://localhost:4100", BaseURL())
+	assert.Equal(t, "https://us-east-1.localhost:4100/100010001000/orders", QueueURL("orders"))
+}